@@ -1,59 +1,77 @@
 package api
 
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
 const (
 	GoPlayitVersion string = "0.17.1"
-
-	TunnelTypeMCBedrock string = "minecraft-bedrock" // Minecraft Bedrock server
-	TunnelTypeMCJava    string = "minecraft-java"    // Minecraft java server
-	TunnelTypeValheim   string = "valheim"           // valheim
-	TunnelTypeTerraria  string = "terraria"          // Terraria multiplayer
-	TunnelTypeStarbound string = "starbound"         // starbound
-	TunnelTypeRust      string = "rust"              // Rust (No programmer language)
-	TunnelType7Days     string = "7days"             // 7days
-	TunnelTypeUnturned  string = "unturned"          // unturned
-
-	PortTypeBoth string = "both" // Tunnel support tcp and udp protocol
-	PortTypeTcp  string = "tcp"  // Tunnel support only tcp protocol
-	PortTypeUdp  string = "udp"  // Tunnel support only udp protocol
-
-	RegionGlobal       string = "global"        // Free account and premium
-	RegionSmartGlobal  string = "smart-global"  // Require premium account
-	RegionNorthAmerica string = "north-america" // Require premium account
-	RegionEurope       string = "europe"        // Require premium account
-	RegionAsia         string = "asia"          // Require premium account
-	RegionIndia        string = "india"         // Require premium account
-	RegionSouthAmerica string = "south-america" // Require premium account
 )
 
 var (
-	PlayitAPI string   = "https://api.playit.gg" // Playit API
-	PortType  []string = []string{
-		PortTypeBoth,
-		PortTypeTcp,
-		PortTypeUdp,
-	} // Tunnel protocol supports
-	TunnelType []string = []string{
-		TunnelTypeMCBedrock,
-		TunnelTypeMCJava,
-		TunnelTypeValheim,
-		TunnelTypeTerraria,
-		TunnelTypeStarbound,
-		TunnelTypeRust,
-		TunnelType7Days,
-		TunnelTypeUnturned,
-	} // Tunnel slice with current supported tunnels
-	Regions []string = []string{
-		RegionSmartGlobal,
-		RegionGlobal,
-		RegionNorthAmerica,
-		RegionEurope,
-		RegionAsia,
-		RegionIndia,
-		RegionSouthAmerica,
-	} // Regions slice
+	PlayitAPI string = "https://api.playit.gg" // Playit API
 )
 
-type Api struct {
+const (
+	DefaultTimeout    time.Duration = time.Second * 30       // Default timeout to every request
+	DefaultMaxRetries int           = 3                      // Default retries to failed requests, see Client.MaxRetries
+	DefaultRetryDelay time.Duration = time.Millisecond * 500 // First retry delay, doubled every retry
+	MaxRetryDelay     time.Duration = time.Second * 10       // Max delay between retries
+)
+
+// Client to playit API, zero value use PlayitAPI and default http client
+type Client struct {
 	Code   string // Claim code
 	Secret string // Agent Secret
+	ApiKey string // Account API key or session key, required by claim details, accept and reject and used if Secret is empty
+
+	BaseURL    string            // API url, default is PlayitAPI
+	HTTPClient *http.Client      // Client to make requests, default is client with Transport
+	Transport  http.RoundTripper // Used only if HTTPClient is nil, default is http.DefaultTransport
+	Timeout    time.Duration     // Timeout to every request, default is DefaultTimeout
+	MaxRetries int               // Retries to 429, 503 and dial errors, idempotent endpoints also retry 5xx and network errors, default is DefaultMaxRetries, negative disable retries
+	UserAgent  string            // User-Agent header, default is "go-playit/<GoPlayitVersion>"
+}
+
+// Deprecated: use Client
+type Api = Client
+
+func (w *Client) baseURL() string {
+	if w.BaseURL == "" {
+		return PlayitAPI
+	}
+	return strings.TrimSuffix(w.BaseURL, "/")
+}
+
+func (w *Client) httpClient() *http.Client {
+	if w.HTTPClient != nil {
+		return w.HTTPClient
+	}
+	return &http.Client{Transport: w.Transport}
+}
+
+func (w *Client) timeout() time.Duration {
+	if w.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return w.Timeout
+}
+
+func (w *Client) maxRetries() int {
+	if w.MaxRetries < 0 {
+		return 0
+	} else if w.MaxRetries == 0 {
+		return DefaultMaxRetries
+	}
+	return w.MaxRetries
+}
+
+func (w *Client) userAgent() string {
+	if w.UserAgent == "" {
+		return fmt.Sprintf("go-playit/%s", GoPlayitVersion)
+	}
+	return w.UserAgent
 }
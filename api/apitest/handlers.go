@@ -0,0 +1,470 @@
+package apitest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+func assignedDomain(tunnelID uuid.UUID) string {
+	return fmt.Sprintf("%s.fake.ply.gg", tunnelID.String()[:8])
+}
+
+// IPv6 of tunnel with region and ip number, like 2602:fbaf:0:<region>::<ip number>
+func tunnelIp(ipNum, regionNum uint16) net.IP {
+	ip := net.IP{0x26, 0x02, 0xfb, 0xaf, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(ip[6:8], regionNum)
+	binary.BigEndian.PutUint16(ip[14:16], ipNum)
+	return ip
+}
+
+func (server *Server) tunnelsCreate(w http.ResponseWriter, r *http.Request) {
+	account, agent := server.authAccount(w, r)
+	if account == nil {
+		return
+	}
+
+	var body api.Tunnel
+	if !decodeBody(w, r, &body) {
+		return
+	} else if !body.PortType.Valid() {
+		validation(w, "invalid port_type")
+		return
+	} else if body.TunnelType != "" && !body.TunnelType.Valid() {
+		validation(w, "invalid tunnel_type")
+		return
+	} else if body.PortCount == 0 {
+		validation(w, "port_count must be bigger than 0")
+		return
+	}
+
+	// Resolve agent of tunnel
+	var agentID uuid.UUID
+	switch body.Origin.Type {
+	case "default":
+		if agent == nil {
+			fail(w, "AgentIdRequired")
+			return
+		}
+		agentID = agent.ID
+	case "agent", "managed":
+		switch origin := body.Origin.Agent.(type) {
+		case api.AssignedAgentCreate:
+			agentID = origin.AgentID
+		case api.AssignedManagedCreate:
+			if origin.AgentID != nil {
+				agentID = *origin.AgentID
+			}
+		}
+		if agentID == uuid.Nil {
+			if body.Origin.Type == "managed" {
+				fail(w, "ManagedMissingAgentId")
+			} else {
+				fail(w, "AgentIdRequired")
+			}
+			return
+		} else if target := server.agents[agentID]; target == nil || target.AccountID != account.ID {
+			fail(w, "AgentNotFound")
+			return
+		}
+	default:
+		validation(w, "invalid origin type")
+		return
+	}
+
+	region := api.RegionGlobal
+	if body.Alloc != nil {
+		switch details := body.Alloc.Data.(type) {
+		case api.UseRegion:
+			if region = details.Region; !region.Valid() {
+				validation(w, "invalid region")
+				return
+			}
+		case api.UseAllocPortAlloc:
+			fail(w, "PortAllocNotFound")
+			return
+		case api.UseAllocDedicatedIp:
+			fail(w, "DedicatedIpNotFound")
+			return
+		}
+	}
+
+	tun := &Tunnel{
+		AgentID:   agentID,
+		AccountID: account.ID,
+		IpNum:     server.IpNum,
+		RegionNum: server.RegionNum,
+		PortFrom:  server.nextPort,
+		LocalIp:   net.IPv4(127, 0, 0, 1),
+	}
+	server.nextPort += body.PortCount
+	tun.LocalPort = tun.PortFrom
+	var localIp net.IP
+	var localPort *uint16
+	switch origin := body.Origin.Agent.(type) {
+	case api.AssignedDefaultCreate:
+		localIp, localPort = origin.LocalIp, origin.LocalPort
+	case api.AssignedAgentCreate:
+		localIp, localPort = origin.LocalIp, origin.LocalPort
+	}
+	if localIp != nil {
+		tun.LocalIp = localIp
+	}
+	if localPort != nil {
+		tun.LocalPort = *localPort
+	}
+
+	tunnelID := uuid.New()
+	tun.AccountTunnel = api.AccountTunnel{
+		ID:         tunnelID,
+		TunnelType: body.TunnelType,
+		CreatedAt:  time.Now().UTC(),
+		Name:       body.Name,
+		PortType:   body.PortType,
+		PortCount:  body.PortCount,
+		Alloc: api.AccountTunnelAllocation{Status: api.AllocationAllocated, Data: api.TunnelAllocated{
+			ID:             tunnelID,
+			IpHostname:     fmt.Sprintf("%d.ip.fake.ply.gg", tun.IpNum),
+			StaticIp4:      net.IPv4(147, 185, 221, byte(tun.IpNum)),
+			AssignedDomain: assignedDomain(tunnelID),
+			TunnelIp:       tunnelIp(tun.IpNum, tun.RegionNum),
+			PortStart:      tun.PortFrom,
+			PortEnd:        tun.PortFrom + body.PortCount,
+			Assignment:     api.TunnelAssignment{Type: "shared-ip"},
+			IpType:         "both",
+			Region:         region,
+		}},
+		Origin: api.TunnelOrigin{Type: body.Origin.Type},
+		Active: body.Enabled,
+		Region: region,
+	}
+	switch body.Origin.Type {
+	case "default":
+		tun.Origin.Agent = api.AssignedDefault{LocalIp: tun.LocalIp, LocalPort: &tun.LocalPort}
+	case "agent":
+		tun.Origin.Agent = api.AssignedAgent{AgentID: agentID, AgentName: server.agents[agentID].Name, LocalIp: tun.LocalIp, LocalPort: &tun.LocalPort}
+	case "managed":
+		tun.Origin.Agent = api.AssignedManaged{AgentID: agentID, AgentName: server.agents[agentID].Name}
+	}
+	server.tunnels = append(server.tunnels, tun)
+	success(w, map[string]any{"id": tun.ID})
+}
+
+func (server *Server) tunnelsDelete(w http.ResponseWriter, r *http.Request) {
+	account, _ := server.authAccount(w, r)
+	if account == nil {
+		return
+	}
+
+	var body struct {
+		TunnelID uuid.UUID `json:"tunnel_id"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	index := slices.IndexFunc(server.tunnels, func(tun *Tunnel) bool {
+		return tun.ID == body.TunnelID && tun.AccountID == account.ID
+	})
+	if index == -1 {
+		fail(w, "TunnelNotFound")
+		return
+	}
+	server.tunnels = slices.Delete(server.tunnels, index, index+1)
+	success(w, nil)
+}
+
+func (server *Server) tunnelsList(w http.ResponseWriter, r *http.Request) {
+	account, _ := server.authAccount(w, r)
+	if account == nil {
+		return
+	}
+
+	var body struct {
+		TunnelID *uuid.UUID `json:"tunnel_id"`
+		AgentID  *uuid.UUID `json:"agent_id"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	list := api.AccountTunnels{Tunnels: []api.AccountTunnel{}}
+	for _, tun := range server.tunnels {
+		if tun.AccountID != account.ID {
+			continue
+		} else if body.TunnelID != nil && *body.TunnelID != tun.ID {
+			continue
+		} else if body.AgentID != nil && *body.AgentID != tun.AgentID {
+			continue
+		}
+		list.Tunnels = append(list.Tunnels, tun.AccountTunnel)
+		if tun.PortType != api.PortTypeUdp {
+			list.Tcp.Claimed += uint16(tun.PortCount)
+		}
+		if tun.PortType != api.PortTypeTcp {
+			list.Udp.Claimed += uint16(tun.PortCount)
+		}
+	}
+	success(w, list)
+}
+
+// Claim by code, write fail if not exists or expired
+func (server *Server) findClaim(w http.ResponseWriter, code, notFound, expired string) *Claim {
+	claim := server.claims[code]
+	if claim == nil {
+		fail(w, notFound)
+		return nil
+	} else if claim.Expired {
+		fail(w, expired)
+		return nil
+	}
+	return claim
+}
+
+func (server *Server) claimSetup(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code      string        `json:"code"`
+		AgentType api.AgentType `json:"agent_type"`
+		Version   string        `json:"version"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	} else if body.Code == "" {
+		fail(w, "InvalidCode")
+		return
+	} else if len(body.Version) > 64 {
+		fail(w, "VersionTextTooLong")
+		return
+	}
+
+	claim := server.claims[body.Code]
+	if claim == nil {
+		claim = &Claim{Code: body.Code, AgentType: body.AgentType, Version: body.Version, State: "WaitingForUserVisit"}
+		claim.RemoteIp, _, _ = net.SplitHostPort(r.RemoteAddr)
+		server.claims[body.Code] = claim
+	} else if claim.Expired {
+		fail(w, "CodeExpired")
+		return
+	}
+
+	if server.AutoAcceptClaims && claim.State != "UserAccepted" && claim.State != "UserRejected" {
+		server.acceptClaim(claim, server.newAccount(api.AccountStatusGuest).ID, "go-playit")
+	}
+	success(w, claim.State)
+}
+
+func (server *Server) claimDetails(w http.ResponseWriter, r *http.Request) {
+	if account, _ := server.authAccount(w, r); account == nil {
+		return
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	claim := server.findClaim(w, body.Code, "InvalidCode", "ClaimExpired")
+	if claim == nil {
+		return
+	}
+
+	switch claim.State {
+	case "UserAccepted":
+		fail(w, "AlreadyClaimed")
+		return
+	case "UserRejected":
+		fail(w, "AlreadyRejected")
+		return
+	case "WaitingForUserVisit":
+		claim.State = "WaitingForUser"
+	}
+	success(w, api.AgentClaimDetails{Name: "go-playit", RemoteIp: claim.RemoteIp, AgentType: claim.AgentType, Version: claim.Version})
+}
+
+func (server *Server) claimAccept(w http.ResponseWriter, r *http.Request) {
+	account, _ := server.authAccount(w, r)
+	if account == nil {
+		return
+	}
+	var body struct {
+		Code      string `json:"code"`
+		Name      string `json:"name"`
+		AgentType string `json:"agent_type"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	claim := server.findClaim(w, body.Code, "CodeNotFound", "CodeExpired")
+	if claim == nil {
+		return
+	} else if claim.State == "UserAccepted" {
+		fail(w, "ClaimAlreadyAccepted")
+		return
+	} else if claim.State == "UserRejected" {
+		fail(w, "ClaimRejected")
+		return
+	} else if !api.AgentType(body.AgentType).Valid() {
+		fail(w, "InvalidAgentType")
+		return
+	} else if body.Name == "" {
+		fail(w, "InvalidName")
+		return
+	}
+	claim.AgentType = api.AgentType(body.AgentType)
+	success(w, api.AgentAccepted{AgentID: server.acceptClaim(claim, account.ID, body.Name)})
+}
+
+func (server *Server) claimReject(w http.ResponseWriter, r *http.Request) {
+	if account, _ := server.authAccount(w, r); account == nil {
+		return
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	claim := server.findClaim(w, body.Code, "CodeNotFound", "CodeNotFound")
+	if claim == nil {
+		return
+	} else if claim.State == "UserAccepted" {
+		fail(w, "ClaimAccepted")
+		return
+	} else if claim.State == "UserRejected" {
+		fail(w, "ClaimAlreadyRejected")
+		return
+	}
+	claim.State = "UserRejected"
+	success(w, nil)
+}
+
+func (server *Server) claimExchange(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	claim := server.findClaim(w, body.Code, "CodeNotFound", "CodeExpired")
+	if claim == nil {
+		return
+	}
+
+	switch claim.State {
+	case "UserAccepted":
+		success(w, map[string]any{"secret_key": server.agents[claim.AgentID].Secret})
+	case "UserRejected":
+		fail(w, "UserRejected")
+	case "WaitingForUserVisit":
+		fail(w, "NotSetup")
+	default:
+		fail(w, "NotAccepted")
+	}
+}
+
+func (server *Server) protoRegister(w http.ResponseWriter, r *http.Request) {
+	agent := server.authAgent(w, r)
+	if agent == nil {
+		return
+	}
+	var body struct {
+		ClientAddr netip.AddrPort `json:"client_addr"`
+		TunnelAddr netip.AddrPort `json:"tunnel_addr"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	var key []byte
+	if server.RegisterKey != nil {
+		var err error
+		if key, err = server.RegisterKey(*agent, body.ClientAddr, body.TunnelAddr); err != nil {
+			internal(w, http.StatusInternalServerError)
+			return
+		}
+	} else {
+		sum := sha256.Sum256([]byte(agent.ID.String() + body.ClientAddr.String() + body.TunnelAddr.String()))
+		key = sum[:]
+	}
+	success(w, map[string]any{"key": hex.EncodeToString(key)})
+}
+
+func (server *Server) loginGuest(w http.ResponseWriter, r *http.Request) {
+	agent := server.authAgent(w, r)
+	if agent == nil {
+		return
+	}
+	account := server.accounts[agent.AccountID]
+	if account.Status != api.AccountStatusGuest {
+		fail(w, "AccountIsNotGuest")
+		return
+	}
+
+	session, epoch := randomKey(), uint64(time.Now().Unix())
+	account.Sessions = append(account.Sessions, session)
+	success(w, api.WebSession{
+		SessionKey: session,
+		Auth: api.WebAuth{
+			AccountID:     account.ID,
+			Timestamp:     uint64(time.Now().UnixMilli()),
+			AccountStatus: account.Status,
+			TotpStatus:    api.TotpStatus{Data: epoch},
+		},
+	})
+}
+
+func (server *Server) agentsRouting(w http.ResponseWriter, r *http.Request) {
+	account, agent := server.authAccount(w, r)
+	if account == nil {
+		return
+	}
+	var body struct {
+		AgentID *uuid.UUID `json:"agent_id"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	if body.AgentID != nil {
+		if agent = server.agents[*body.AgentID]; agent == nil || agent.AccountID != account.ID {
+			fail(w, "InvalidAgentId")
+			return
+		}
+	} else if agent == nil {
+		fail(w, "MissingAgentId")
+		return
+	}
+	success(w, api.AgentRouting{AgentID: agent.ID, Targets4: server.Targets4, Targets6: server.Targets6})
+}
+
+func (server *Server) agentsRundata(w http.ResponseWriter, r *http.Request) {
+	agent := server.authAgent(w, r)
+	if agent == nil {
+		return
+	}
+
+	data := api.AgentRunData{
+		ID:             agent.ID,
+		Type:           agent.Type,
+		AccountStatus:  server.accounts[agent.AccountID].Status,
+		Tunnels:        []api.AgentTunnel{},
+		TunnelsPending: []api.AgentPendingTunnel{},
+	}
+	if data.AccountStatus == "verified" {
+		data.AccountStatus = "ready"
+	}
+	for _, tun := range server.tunnels {
+		if tun.AgentID == agent.ID {
+			data.Tunnels = append(data.Tunnels, tun.AgentTunnel())
+		}
+	}
+	success(w, data)
+}
@@ -0,0 +1,428 @@
+// Package apitest run in-memory playit API to tests without https://api.playit.gg
+package apitest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+const FirstPort uint16 = 10000 // First port allocated to tunnels
+
+type Account struct {
+	ID       uint64
+	Status   string   // guest, email-not-verified or verified
+	Sessions []string // Api-Key values
+}
+
+type Agent struct {
+	ID        uuid.UUID
+	AccountID uint64
+	Name      string
+	Type      api.AgentType
+	Version   string
+	Secret    string // Agent-Key value
+}
+
+type Claim struct {
+	Code      string
+	AgentType api.AgentType
+	Version   string
+	RemoteIp  string
+	State     string    // ClaimSetupResponse: WaitingForUserVisit, WaitingForUser, UserAccepted or UserRejected
+	AgentID   uuid.UUID // Agent created on accept
+	Expired   bool
+}
+
+type Tunnel struct {
+	api.AccountTunnel
+	AgentID   uuid.UUID
+	AccountID uint64
+	IpNum     uint16
+	RegionNum uint16
+	PortFrom  uint16
+	LocalIp   net.IP
+	LocalPort uint16
+}
+
+// Agent view of tunnel, returned in /agents/rundata
+func (tun *Tunnel) AgentTunnel() api.AgentTunnel {
+	return api.AgentTunnel{
+		ID:             tun.ID,
+		Name:           tun.Name,
+		IpNum:          tun.IpNum,
+		RegionNum:      tun.RegionNum,
+		Port:           api.PortRange{From: tun.PortFrom, To: tun.PortFrom + uint16(tun.PortCount)},
+		Proto:          tun.PortType,
+		LocalIp:        tun.LocalIp,
+		LocalPort:      tun.LocalPort,
+		TunnelType:     tun.TunnelType,
+		AssignedDomain: assignedDomain(tun.ID),
+	}
+}
+
+// Change response of endpoint
+type Fault struct {
+	Latency time.Duration // Wait before response
+	Status  int           // Respond HTTP status with internal error, like 503
+	Fail    string        // Respond "fail" with enum value, like AgentNotFound
+	Auth    string        // Respond auth error with AuthError value
+	Times   int           // Requests affected, 0 to every request until ClearFaults
+}
+
+// In-memory playit API, state can be changed by methods while server is running
+type Server struct {
+	*httptest.Server
+
+	AutoAcceptClaims bool         // Accept claims in /claim/setup, agent is created in new guest account
+	IpNum            uint16       // Ip number of new tunnels, default 1
+	RegionNum        uint16       // Region number of new tunnels
+	Targets4         []netip.Addr // Control addresses in /agents/routing/get, default 127.0.0.1
+	Targets6         []netip.Addr
+
+	// Register key returned by /proto/register, default is sha256 of agent and addresses
+	RegisterKey func(agent Agent, client, tunnel netip.AddrPort) ([]byte, error)
+
+	lock     sync.Mutex
+	accounts map[uint64]*Account
+	agents   map[uuid.UUID]*Agent
+	claims   map[string]*Claim
+	tunnels  []*Tunnel
+	faults   map[string]*Fault
+	requests map[string]int
+	nextID   uint64
+	nextPort uint16
+}
+
+// Start server, close with Close
+func NewServer() *Server {
+	server := NewUnstartedServer()
+	server.Start()
+	return server
+}
+
+// Create server without start, call Start after set fields
+func NewUnstartedServer() *Server {
+	server := &Server{
+		IpNum:    1,
+		Targets4: []netip.Addr{netip.AddrFrom4([4]byte{127, 0, 0, 1})},
+		accounts: map[uint64]*Account{},
+		agents:   map[uuid.UUID]*Agent{},
+		claims:   map[string]*Claim{},
+		faults:   map[string]*Fault{},
+		requests: map[string]int{},
+		nextID:   1,
+		nextPort: FirstPort,
+	}
+	server.Server = httptest.NewUnstartedServer(server)
+	return server
+}
+
+// Client to server, secret is Agent-Key and can be empty
+func (server *Server) ApiClient(secret string) *api.Client {
+	return &api.Client{
+		Secret:     secret,
+		BaseURL:    server.URL,
+		HTTPClient: server.Server.Client(),
+	}
+}
+
+func randomKey() string {
+	buff := make([]byte, 32)
+	rand.Read(buff)
+	return hex.EncodeToString(buff)
+}
+
+// Create account and return id and session key
+func (server *Server) NewAccount(status string) (uint64, string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	account := server.newAccount(status)
+	return account.ID, account.Sessions[0]
+}
+
+func (server *Server) newAccount(status string) *Account {
+	account := &Account{ID: server.nextID, Status: status, Sessions: []string{randomKey()}}
+	server.nextID++
+	server.accounts[account.ID] = account
+	return account
+}
+
+// Create agent in account and return id and secret
+func (server *Server) NewAgent(accountID uint64, name string, agentType api.AgentType) (uuid.UUID, string, error) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if server.accounts[accountID] == nil {
+		return uuid.Nil, "", fmt.Errorf("account %d not exists", accountID)
+	}
+	agent := server.newAgent(accountID, name, agentType)
+	return agent.ID, agent.Secret, nil
+}
+
+func (server *Server) newAgent(accountID uint64, name string, agentType api.AgentType) *Agent {
+	agent := &Agent{ID: uuid.New(), AccountID: accountID, Name: name, Type: agentType, Secret: randomKey()}
+	server.agents[agent.ID] = agent
+	return agent
+}
+
+// Copy of agents
+func (server *Server) Agents() []Agent {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	var agents []Agent
+	for _, agent := range server.agents {
+		agents = append(agents, *agent)
+	}
+	return agents
+}
+
+// Copy of tunnels
+func (server *Server) Tunnels() []Tunnel {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	var tunnels []Tunnel
+	for _, tun := range server.tunnels {
+		tunnels = append(tunnels, *tun)
+	}
+	return tunnels
+}
+
+// Copy of claim, nil if not exists
+func (server *Server) Claim(code string) *Claim {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if claim := server.claims[code]; claim != nil {
+		copy := *claim
+		return &copy
+	}
+	return nil
+}
+
+// Accept claim as user of account, create agent and return your id
+func (server *Server) AcceptClaim(code string, accountID uint64, name string) (uuid.UUID, error) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	claim := server.claims[code]
+	if claim == nil {
+		return uuid.Nil, fmt.Errorf("claim %q not exists", code)
+	} else if server.accounts[accountID] == nil {
+		return uuid.Nil, fmt.Errorf("account %d not exists", accountID)
+	}
+	return server.acceptClaim(claim, accountID, name), nil
+}
+
+func (server *Server) acceptClaim(claim *Claim, accountID uint64, name string) uuid.UUID {
+	agent := server.newAgent(accountID, name, claim.AgentType)
+	agent.Version = claim.Version
+	claim.AgentID, claim.State = agent.ID, "UserAccepted"
+	return agent.ID
+}
+
+// Reject claim as user
+func (server *Server) RejectClaim(code string) error {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	claim := server.claims[code]
+	if claim == nil {
+		return fmt.Errorf("claim %q not exists", code)
+	}
+	claim.State = "UserRejected"
+	return nil
+}
+
+// Expire claim, next requests return CodeExpired
+func (server *Server) ExpireClaim(code string) error {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	claim := server.claims[code]
+	if claim == nil {
+		return fmt.Errorf("claim %q not exists", code)
+	}
+	claim.Expired = true
+	return nil
+}
+
+// Set fault to endpoint path, like "/tunnels/create"
+func (server *Server) InjectFault(path string, fault Fault) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.faults[path] = &fault
+}
+
+// Remove all faults
+func (server *Server) ClearFaults() {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	clear(server.faults)
+}
+
+// Requests received by endpoint path
+func (server *Server) Requests(path string) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.requests[path]
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func success(w http.ResponseWriter, data any) {
+	writeJson(w, http.StatusOK, map[string]any{"status": "success", "data": data})
+}
+
+func fail(w http.ResponseWriter, code string) {
+	writeJson(w, http.StatusBadRequest, map[string]any{"status": "fail", "data": code})
+}
+
+func validation(w http.ResponseWriter, message string) {
+	writeJson(w, http.StatusBadRequest, map[string]any{"status": "error", "data": map[string]any{"type": "validation", "message": message}})
+}
+
+func authError(w http.ResponseWriter, code string) {
+	writeJson(w, http.StatusUnauthorized, map[string]any{"status": "error", "data": map[string]any{"type": "auth", "message": code}})
+}
+
+func internal(w http.ResponseWriter, status int) {
+	writeJson(w, status, map[string]any{"status": "error", "data": map[string]any{"type": "internal"}})
+}
+
+// Take fault to path, return false if fault send response
+func (server *Server) applyFault(w http.ResponseWriter, path string) bool {
+	server.lock.Lock()
+	fault := server.faults[path]
+	var current Fault
+	if fault != nil {
+		current = *fault
+		if fault.Times > 0 {
+			if fault.Times--; fault.Times == 0 {
+				delete(server.faults, path)
+			}
+		}
+	}
+	server.lock.Unlock()
+
+	if fault == nil {
+		return true
+	}
+	time.Sleep(current.Latency)
+	switch {
+	case current.Status != 0:
+		internal(w, current.Status)
+	case current.Fail != "":
+		fail(w, current.Fail)
+	case current.Auth != "":
+		authError(w, current.Auth)
+	default:
+		return true
+	}
+	return false
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.lock.Lock()
+	server.requests[r.URL.Path]++
+	server.lock.Unlock()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !server.applyFault(w, r.URL.Path) {
+		return
+	}
+
+	handlers := map[string]func(http.ResponseWriter, *http.Request){
+		"/tunnels/create":     server.tunnelsCreate,
+		"/tunnels/delete":     server.tunnelsDelete,
+		"/tunnels/list":       server.tunnelsList,
+		"/claim/details":      server.claimDetails,
+		"/claim/setup":        server.claimSetup,
+		"/claim/exchange":     server.claimExchange,
+		"/claim/accept":       server.claimAccept,
+		"/claim/reject":       server.claimReject,
+		"/proto/register":     server.protoRegister,
+		"/login/guest":        server.loginGuest,
+		"/agents/routing/get": server.agentsRouting,
+		"/agents/rundata":     server.agentsRundata,
+	}
+	handler, ok := handlers[r.URL.Path]
+	if !ok {
+		writeJson(w, http.StatusNotFound, map[string]any{"status": "error", "data": map[string]any{"type": "validation", "message": "endpoint not found"}})
+		return
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	handler(w, r)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, body any) bool {
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		validation(w, err.Error())
+		return false
+	}
+	return true
+}
+
+// Get authorization value to scheme, "Agent-Key" or "Api-Key"
+func authorization(r *http.Request) (string, string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, value, ok := strings.Cut(header, " ")
+	return scheme, value, ok && value != ""
+}
+
+// Agent from Agent-Key, write error if not authenticated
+func (server *Server) authAgent(w http.ResponseWriter, r *http.Request) *Agent {
+	scheme, value, ok := authorization(r)
+	if r.Header.Get("Authorization") == "" {
+		authError(w, "AuthRequired")
+	} else if !ok || !strings.EqualFold(scheme, "Agent-Key") {
+		authError(w, "InvalidHeader")
+	} else {
+		for _, agent := range server.agents {
+			if agent.Secret == value {
+				return agent
+			}
+		}
+		authError(w, "InvalidAgentKey")
+	}
+	return nil
+}
+
+// Account from Api-Key or Agent-Key, agent is nil with Api-Key
+func (server *Server) authAccount(w http.ResponseWriter, r *http.Request) (*Account, *Agent) {
+	scheme, value, ok := authorization(r)
+	if r.Header.Get("Authorization") == "" {
+		authError(w, "AuthRequired")
+	} else if !ok {
+		authError(w, "InvalidHeader")
+	} else if strings.EqualFold(scheme, "Agent-Key") {
+		if agent := server.authAgent(w, r); agent != nil {
+			return server.accounts[agent.AccountID], agent
+		}
+	} else if strings.EqualFold(scheme, "Api-Key") {
+		for _, account := range server.accounts {
+			if slices.Contains(account.Sessions, value) {
+				return account, nil
+			}
+		}
+		authError(w, "InvalidApiKey")
+	} else {
+		authError(w, "InvalidHeader")
+	}
+	return nil, nil
+}
@@ -0,0 +1,504 @@
+package apitest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+func newAgent(t *testing.T, server *Server) (uuid.UUID, *api.Client) {
+	t.Helper()
+	accountID, _ := server.NewAccount(api.AccountStatusGuest)
+	agentID, secret, err := server.NewAgent(accountID, "test", api.AgentTypeDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agentID, server.ApiClient(secret)
+}
+
+func TestClaimAgentSecret(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.AutoAcceptClaims = true
+
+	client := server.ApiClient("")
+	client.Code = "auto"
+	if err := client.ClaimAgentSecret(context.Background(), api.AgentTypeSelfManaged); err != nil {
+		t.Fatal(err)
+	} else if client.Secret == "" {
+		t.Fatal("secret not set")
+	}
+
+	claim := server.Claim("auto")
+	if claim == nil || claim.State != "UserAccepted" || claim.AgentType != api.AgentTypeSelfManaged {
+		t.Fatalf("claim %+v", claim)
+	}
+	info, err := client.AgentInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if info.ID != claim.AgentID || info.Type != api.AgentTypeSelfManaged {
+		t.Fatalf("agent %s type %s, want %s", info.ID, info.Type, claim.AgentID)
+	}
+}
+
+func TestClaimSession(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	accountID, _ := server.NewAccount(api.AccountStatusVerified)
+
+	for _, accept := range []bool{true, false} {
+		client := server.ApiClient("")
+		client.Code = "code"
+		if !accept {
+			client.Code = "reject"
+		}
+
+		events := make(chan api.ClaimEvent, 8)
+		session := api.ClaimSession{Client: client, PollInterval: 10 * time.Millisecond, Timeout: 5 * time.Second, Events: events}
+		done := make(chan error, 1)
+		go func() {
+			secret, err := session.Run(context.Background())
+			if err == nil && secret == "" {
+				err = errors.New("empty secret")
+			}
+			done <- err
+		}()
+
+		if event := <-events; event.State != api.ClaimWaiting || event.Setup != "WaitingForUserVisit" {
+			t.Fatalf("first event %+v", event)
+		}
+		var err error
+		if accept {
+			_, err = server.AcceptClaim(client.Code, accountID, "test")
+		} else {
+			err = server.RejectClaim(client.Code)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = <-done
+		if accept && err != nil {
+			t.Fatal(err)
+		} else if !accept && !errors.Is(err, api.ErrClaimRejected) {
+			t.Fatalf("expected ErrClaimRejected, got %v", err)
+		}
+	}
+
+	// Expired code
+	client := server.ApiClient("")
+	client.Code = "expired"
+	session := api.ClaimSession{Client: client, PollInterval: 10 * time.Millisecond}
+	server.InjectFault("/claim/setup", Fault{Fail: "CodeExpired", Times: 1})
+	if _, err := session.Run(context.Background()); !errors.Is(err, api.ErrClaimSetupCodeExpired) {
+		t.Fatalf("expected ErrClaimSetupCodeExpired, got %v", err)
+	}
+}
+
+func TestClaimEndpoints(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	accountID, key := server.NewAccount(api.AccountStatusVerified)
+	user := server.ApiClient("")
+	user.ApiKey = key
+
+	agent := server.ApiClient("")
+	for _, code := range []string{"accept", "reject"} {
+		var state string
+		setup := map[string]any{"code": code, "agent_type": api.AgentTypeSelfManaged, "version": "test"}
+		if err := agent.Call(ctx, "/claim/setup", setup, &state); err != nil {
+			t.Fatal(err)
+		} else if state != "WaitingForUserVisit" {
+			t.Fatalf("setup state %q", state)
+		}
+	}
+
+	details, err := user.ClaimDetails(ctx, "accept")
+	if err != nil {
+		t.Fatal(err)
+	} else if details.AgentType != api.AgentTypeSelfManaged || details.Version != "test" || details.RemoteIp == "" {
+		t.Fatalf("details %+v", details)
+	} else if state := server.Claim("accept").State; state != "WaitingForUser" {
+		t.Fatalf("claim state %q after details", state)
+	}
+
+	accepted, err := user.ClaimAccept(ctx, "accept", "home", api.AgentTypeSelfManaged)
+	if err != nil {
+		t.Fatal(err)
+	} else if claim := server.Claim("accept"); claim.State != "UserAccepted" || claim.AgentID != accepted.AgentID {
+		t.Fatalf("claim %+v, accepted %s", claim, accepted.AgentID)
+	}
+	agents := server.Agents()
+	if len(agents) != 1 || agents[0].AccountID != accountID || agents[0].Name != "home" {
+		t.Fatalf("agents %+v", agents)
+	}
+	if _, err := user.ClaimAccept(ctx, "accept", "home", api.AgentTypeSelfManaged); !errors.Is(err, api.ErrClaimAcceptClaimAlreadyAccepted) {
+		t.Fatalf("expected ErrClaimAcceptClaimAlreadyAccepted, got %v", err)
+	} else if err := user.ClaimReject(ctx, "accept"); !errors.Is(err, api.ErrClaimRejectClaimAccepted) {
+		t.Fatalf("expected ErrClaimRejectClaimAccepted, got %v", err)
+	} else if _, err := user.ClaimDetails(ctx, "accept"); !errors.Is(err, api.ErrClaimDetailsAlreadyClaimed) {
+		t.Fatalf("expected ErrClaimDetailsAlreadyClaimed, got %v", err)
+	}
+
+	if err := user.ClaimReject(ctx, "reject"); err != nil {
+		t.Fatal(err)
+	} else if state := server.Claim("reject").State; state != "UserRejected" {
+		t.Fatalf("claim state %q after reject", state)
+	}
+	if err := user.ClaimReject(ctx, "reject"); !errors.Is(err, api.ErrClaimRejectClaimAlreadyRejected) {
+		t.Fatalf("expected ErrClaimRejectClaimAlreadyRejected, got %v", err)
+	} else if _, err := user.ClaimAccept(ctx, "reject", "home", api.AgentTypeSelfManaged); !errors.Is(err, api.ErrClaimAcceptClaimRejected) {
+		t.Fatalf("expected ErrClaimAcceptClaimRejected, got %v", err)
+	} else if _, err := user.ClaimDetails(ctx, "reject"); !errors.Is(err, api.ErrClaimDetailsAlreadyRejected) {
+		t.Fatalf("expected ErrClaimDetailsAlreadyRejected, got %v", err)
+	}
+
+	// Unknown code
+	if _, err := user.ClaimDetails(ctx, "unknown"); !errors.Is(err, api.ErrClaimDetailsInvalidCode) {
+		t.Fatalf("expected ErrClaimDetailsInvalidCode, got %v", err)
+	} else if _, err := user.ClaimAccept(ctx, "unknown", "home", api.AgentTypeSelfManaged); !errors.Is(err, api.ErrClaimAcceptCodeNotFound) {
+		t.Fatalf("expected ErrClaimAcceptCodeNotFound, got %v", err)
+	} else if err := user.ClaimReject(ctx, "unknown"); !errors.Is(err, api.ErrClaimRejectCodeNotFound) {
+		t.Fatalf("expected ErrClaimRejectCodeNotFound, got %v", err)
+	}
+
+	// Checked before request
+	requests := server.Requests("/claim/accept") + server.Requests("/claim/details") + server.Requests("/claim/reject")
+	if _, err := user.ClaimAccept(ctx, "reject", "home", api.AgentType("invalid")); err == nil {
+		t.Fatal("accept with invalid agent type")
+	} else if _, err := agent.ClaimDetails(ctx, "reject"); err == nil {
+		t.Fatal("details without api key")
+	} else if err := agent.ClaimReject(ctx, "reject"); err == nil {
+		t.Fatal("reject without api key")
+	} else if sent := server.Requests("/claim/accept") + server.Requests("/claim/details") + server.Requests("/claim/reject"); sent != requests {
+		t.Fatalf("%d requests sent, want 0", sent-requests)
+	}
+}
+
+// Record Authorization header of every request
+type authRecorder struct {
+	http.RoundTripper
+	headers []string
+}
+
+func (rec *authRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	rec.headers = append(rec.headers, r.Header.Get("Authorization"))
+	return rec.RoundTripper.RoundTrip(r)
+}
+
+func TestGuestLogin(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	agentID, client := newAgent(t, server)
+	secret := client.Secret
+	recorder := &authRecorder{RoundTripper: client.HTTPClient.Transport}
+	client.HTTPClient = &http.Client{Transport: recorder}
+
+	session, err := client.GuestLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if session.SessionKey == "" || session.Auth.AccountStatus != api.AccountStatusGuest {
+		t.Fatalf("session %+v", session)
+	} else if epoch, ok := session.Auth.TotpStatus.Data.(uint64); session.Auth.TotpStatus.Status != "signed" || !ok || epoch == 0 {
+		t.Fatalf("totp status %+v", session.Auth.TotpStatus)
+	}
+
+	user := client.WithSession(session)
+	if client.Secret != secret || client.ApiKey != "" {
+		t.Fatalf("WithSession changed client, secret %q api key %q", client.Secret, client.ApiKey)
+	} else if user.Secret != "" || user.ApiKey != session.SessionKey || user.BaseURL != client.BaseURL {
+		t.Fatalf("session client %+v", user)
+	}
+
+	tunnels, err := user.ListTunnels(ctx, nil, &agentID)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(tunnels.Tunnels) != 0 {
+		t.Fatalf("%d tunnels", len(tunnels.Tunnels))
+	}
+	if _, err := user.ClaimDetails(ctx, "unknown"); !errors.Is(err, api.ErrClaimDetailsInvalidCode) {
+		t.Fatalf("expected ErrClaimDetailsInvalidCode, got %v", err)
+	}
+	if _, err := client.AgentInfo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Agent-Key " + secret, "Api-Key " + session.SessionKey, "Api-Key " + session.SessionKey, "Agent-Key " + secret}
+	if !slices.Equal(recorder.headers, want) {
+		t.Fatalf("authorization headers %q, want %q", recorder.headers, want)
+	}
+
+	// Session client has no agent secret
+	if _, err := user.GuestLogin(ctx); err == nil {
+		t.Fatal("guest login without secret")
+	}
+
+	accountID, _ := server.NewAccount(api.AccountStatusVerified)
+	_, verifiedSecret, err := server.NewAgent(accountID, "verified", api.AgentTypeDefault)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := server.ApiClient(verifiedSecret).GuestLogin(ctx); !errors.Is(err, api.ErrGuestLoginAccountIsNotGuest) {
+		t.Fatalf("expected ErrGuestLoginAccountIsNotGuest, got %v", err)
+	}
+}
+
+func TestCreateTunnel(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	agentID, client := newAgent(t, server)
+	ctx := context.Background()
+
+	port := uint16(25565)
+	err := client.CreateTunnel(ctx, api.Tunnel{
+		Name:      "mc",
+		PortType:  api.PortTypeTcp,
+		PortCount: 2,
+		Enabled:   true,
+		Origin:    api.TunnelOriginCreate{Agent: api.AssignedDefaultCreate{LocalPort: &port}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tunnels, err := client.ListTunnels(ctx, nil, &agentID)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(tunnels.Tunnels) != 1 {
+		t.Fatalf("%d tunnels", len(tunnels.Tunnels))
+	}
+	tun := tunnels.Tunnels[0]
+	if tun.Name != "mc" || tun.PortType != api.PortTypeTcp || tun.PortCount != 2 || tun.Alloc.Status != api.AllocationAllocated {
+		t.Fatalf("tunnel %+v", tun)
+	}
+	if origin, ok := tun.Origin.Agent.(api.AssignedDefault); tun.Origin.Type != "default" || !ok || origin.LocalPort == nil || *origin.LocalPort != port {
+		t.Fatalf("origin %+v", tun.Origin)
+	}
+
+	info, err := client.AgentInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(info.Tunnels) != 1 || info.Tunnels[0].LocalPort != port || info.Tunnels[0].Port.From != FirstPort || info.Tunnels[0].Port.To != FirstPort+2 {
+		t.Fatalf("agent tunnels %+v", info.Tunnels)
+	}
+
+	err = client.CreateTunnel(ctx, api.Tunnel{
+		Name:      "agent",
+		PortType:  api.PortTypeUdp,
+		PortCount: 1,
+		Origin:    api.TunnelOriginCreate{Agent: api.AssignedAgentCreate{AgentID: agentID}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnels, err = client.ListTunnels(ctx, nil, &agentID)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(tunnels.Tunnels) != 2 {
+		t.Fatalf("%d tunnels", len(tunnels.Tunnels))
+	} else if origin, ok := tunnels.Tunnels[1].Origin.Agent.(api.AssignedAgent); !ok || origin.AgentID != agentID || origin.AgentName != "test" {
+		t.Fatalf("origin %+v", tunnels.Tunnels[1].Origin)
+	}
+
+	otherAgent := uuid.New()
+	err = client.CreateTunnel(ctx, api.Tunnel{
+		PortType:  api.PortTypeUdp,
+		PortCount: 1,
+		Origin:    api.TunnelOriginCreate{Agent: api.AssignedAgentCreate{AgentID: otherAgent}},
+	})
+	if !errors.Is(err, api.ErrTunnelCreateAgentNotFound) {
+		t.Fatalf("expected ErrTunnelCreateAgentNotFound, got %v", err)
+	}
+
+	if err := client.DeleteTunnel(ctx, &tun.ID); err != nil {
+		t.Fatal(err)
+	} else if err := client.DeleteTunnel(ctx, &tun.ID); !errors.Is(err, api.ErrDeleteTunnelNotFound) {
+		t.Fatalf("expected ErrDeleteTunnelNotFound, got %v", err)
+	}
+}
+
+func TestReconcileTunnels(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	_, client := newAgent(t, server)
+	ctx := context.Background()
+
+	for _, name := range []string{"old", "keep", "change"} {
+		if err := client.CreateTunnel(ctx, (&api.TunnelSpec{Name: name, PortType: api.PortTypeTcp, PortCount: 1}).Tunnel()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func() []string {
+		var names []string
+		for _, tun := range server.Tunnels() {
+			names = append(names, fmt.Sprintf("%s/%d", tun.Name, tun.PortCount))
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	specs := []api.TunnelSpec{
+		{Name: "keep", PortType: api.PortTypeTcp, PortCount: 1},
+		{Name: "change", PortType: api.PortTypeTcp, PortCount: 2},
+		{Name: "new", PortType: api.PortTypeUdp, PortCount: 1},
+	}
+	plan, err := client.PlanTunnels(ctx, specs, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := map[string]api.ReconcileAction{}
+	for _, step := range plan.Steps {
+		if step.Spec != nil {
+			actions[step.Spec.Name] = step.Action
+		} else {
+			actions[step.Current.Name] = step.Action
+		}
+	}
+	want := map[string]api.ReconcileAction{"old": api.ReconcileDelete, "keep": api.ReconcileKeep, "change": api.ReconcileRecreate, "new": api.ReconcileCreate}
+	if !maps.Equal(actions, want) || plan.Changes() != 3 {
+		t.Fatalf("plan actions %v\n%s", actions, plan)
+	}
+
+	// Failed create stop before any delete
+	server.InjectFault("/tunnels/create", Fault{Fail: "DedicatedIpNotFound", Times: 1})
+	err = client.ApplyPlan(ctx, plan)
+	var applyErr *api.ApplyError
+	if !errors.As(err, &applyErr) || !errors.Is(err, api.ErrTunnelCreateDedicatedIpNotFound) {
+		t.Fatalf("expected ApplyError with ErrTunnelCreateDedicatedIpNotFound, got %v", err)
+	} else if applyErr.Step.Action != api.ReconcileCreate || applyErr.Deleted || len(applyErr.Applied) != 0 {
+		t.Fatalf("apply error %+v", applyErr)
+	} else if got := names(); !slices.Equal(got, []string{"change/1", "keep/1", "old/1"}) {
+		t.Fatalf("tunnels after failed create %v", got)
+	} else if server.Requests("/tunnels/delete") != 0 {
+		t.Fatalf("%d deletes after failed create", server.Requests("/tunnels/delete"))
+	}
+
+	if err := client.ApplyPlan(ctx, plan); err != nil {
+		t.Fatal(err)
+	} else if got := names(); !slices.Equal(got, []string{"change/2", "keep/1", "new/1"}) {
+		t.Fatalf("tunnels after apply %v", got)
+	}
+	if plan, err := client.PlanTunnels(ctx, specs, nil, true); err != nil {
+		t.Fatal(err)
+	} else if plan.Changes() != 0 {
+		t.Fatalf("plan after apply\n%s", plan)
+	}
+
+	// Recreate report deleted tunnel
+	specs[1].PortCount = 3
+	server.InjectFault("/tunnels/create", Fault{Fail: "DedicatedIpNotFound", Times: 1})
+	if _, err := client.ReconcileTunnels(ctx, specs, nil, true); !errors.As(err, &applyErr) {
+		t.Fatalf("expected ApplyError, got %v", err)
+	} else if applyErr.Step.Action != api.ReconcileRecreate || !applyErr.Deleted || len(applyErr.Applied) != 0 {
+		t.Fatalf("apply error %+v", applyErr)
+	} else if got := names(); !slices.Equal(got, []string{"keep/1", "new/1"}) {
+		t.Fatalf("tunnels after failed recreate %v", got)
+	}
+}
+
+func TestAgentRoutings(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Targets6 = []netip.Addr{netip.MustParseAddr("::1")}
+	agentID, client := newAgent(t, server)
+
+	routing, err := client.AgentRoutings(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if routing.AgentID != agentID || len(routing.Targets4) != 1 || routing.Targets4[0] != server.Targets4[0] || len(routing.Targets6) != 1 {
+		t.Fatalf("routing %+v", routing)
+	}
+
+	otherAgent := uuid.New()
+	if _, err := client.AgentRoutings(context.Background(), &otherAgent); !errors.Is(err, api.ErrAgentRoutingGetInvalidAgentId) {
+		t.Fatalf("expected ErrAgentRoutingGetInvalidAgentId, got %v", err)
+	}
+}
+
+func TestInjectFault(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	_, client := newAgent(t, server)
+	ctx := context.Background()
+	tunnel := api.Tunnel{PortType: api.PortTypeTcp, PortCount: 1, Origin: api.TunnelOriginCreate{Agent: api.AssignedDefaultCreate{}}}
+
+	t.Run("retry 5xx", func(t *testing.T) {
+		server.InjectFault("/agents/routing/get", Fault{Status: 500, Times: 1})
+		if _, err := client.AgentRoutings(ctx, nil); err != nil {
+			t.Fatal(err)
+		} else if requests := server.Requests("/agents/routing/get"); requests != 2 {
+			t.Fatalf("%d requests, want 2", requests)
+		}
+
+		// Create is not idempotent, only 503 is retried
+		server.InjectFault("/tunnels/create", Fault{Status: 500, Times: 1})
+		if err := client.CreateTunnel(ctx, tunnel); !errors.Is(err, api.ErrInternal) {
+			t.Fatalf("expected ErrInternal, got %v", err)
+		} else if requests := server.Requests("/tunnels/create"); requests != 1 {
+			t.Fatalf("%d create requests, want 1", requests)
+		}
+		server.InjectFault("/tunnels/create", Fault{Status: 503, Times: 1})
+		if err := client.CreateTunnel(ctx, tunnel); err != nil {
+			t.Fatal(err)
+		} else if requests := server.Requests("/tunnels/create"); requests != 3 {
+			t.Fatalf("%d create requests, want 3", requests)
+		}
+
+		noRetry := *client
+		noRetry.MaxRetries = -1
+		server.InjectFault("/tunnels/list", Fault{Status: 502, Times: 1})
+		var apiErr *api.Error
+		if _, err := noRetry.ListTunnels(ctx, nil, nil); !errors.As(err, &apiErr) || apiErr.StatusCode != 502 {
+			t.Fatalf("expected 502 error, got %v", err)
+		}
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		slow := *client
+		slow.Timeout, slow.MaxRetries = 50*time.Millisecond, -1
+		server.InjectFault("/agents/rundata", Fault{Latency: 200 * time.Millisecond, Times: 1})
+		if _, err := slow.AgentInfo(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected timeout, got %v", err)
+		}
+
+		server.InjectFault("/agents/rundata", Fault{Latency: 10 * time.Millisecond, Times: 1})
+		start := time.Now()
+		if _, err := slow.AgentInfo(ctx); err != nil {
+			t.Fatal(err)
+		} else if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+			t.Fatalf("response in %s without latency", elapsed)
+		}
+	})
+
+	t.Run("error enums", func(t *testing.T) {
+		server.InjectFault("/tunnels/create", Fault{Fail: "DedicatedIpNotFound", Times: 1})
+		if err := client.CreateTunnel(ctx, tunnel); !errors.Is(err, api.ErrTunnelCreateDedicatedIpNotFound) {
+			t.Fatalf("expected ErrTunnelCreateDedicatedIpNotFound, got %v", err)
+		}
+		server.InjectFault("/agents/rundata", Fault{Auth: "SessionExpired", Times: 1})
+		if _, err := client.AgentInfo(ctx); !errors.Is(err, api.ErrAuthSessionExpired) || !errors.Is(err, api.ErrUnauthorized) {
+			t.Fatalf("expected ErrAuthSessionExpired, got %v", err)
+		}
+
+		// Fault without Times stay until ClearFaults
+		server.InjectFault("/agents/routing/get", Fault{Fail: "MissingAgentId"})
+		for range 2 {
+			if _, err := client.AgentRoutings(ctx, nil); !errors.Is(err, api.ErrAgentRoutingGetMissingAgentId) {
+				t.Fatalf("expected ErrAgentRoutingGetMissingAgentId, got %v", err)
+			}
+		}
+		server.ClearFaults()
+		if _, err := client.AgentRoutings(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -2,22 +2,17 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/url"
-	"slices"
-	"time"
-)
 
-var (
-	ClaimAgents     []string = []string{
-		"default", "assignable", "self-managed",
-	}
+	"sirherobrine23.org/playit-cloud/go-playit/api/openapi"
 )
 
-func (w *Api) AiisgnClaimCode() (err error) {
+func (w *Client) AiisgnClaimCode() (err error) {
 	if len(w.Code) > 0 {
 		return nil
 	}
@@ -34,67 +29,100 @@ func (w *Api) AiisgnClaimCode() (err error) {
 }
 
 // Get claim url
-func (w *Api) ClaimUrl() string {
+func (w *Client) ClaimUrl() string {
 	return fmt.Sprintf("https://playit.gg/claim/%s", url.PathEscape(w.Code))
 }
 
-func (w *Api) ClaimAgentSecret(AgentType string) error {
-	if w.Code == "" {
-		return fmt.Errorf("assign claim code")
-	} else if w.Secret != "" {
+// Wait user accept claim and set Secret, see ClaimSession to events and timeout
+func (w *Client) ClaimAgentSecret(ctx context.Context, Type AgentType) error {
+	if w.Secret != "" {
 		return fmt.Errorf("agent secret key ared located")
-	} else if !slices.Contains(ClaimAgents, AgentType) {
-		return fmt.Errorf("set valid agent type")
 	}
 
-	type Claim struct {
-		Code    string `json:"code"`       // Claim code
-		Agent   string `json:"agent_type"` // "default" | "assignable" | "self-managed"
-		Version string `json:"version"`    // Project version
+	secret, err := (&ClaimSession{Client: w, AgentType: Type}).Run(ctx)
+	if err != nil {
+		return err
 	}
-	type Code struct {
-		Code      string `json:"code,omitempty"`
-		SecretKey string `json:"secret_key,omitempty"`
+	w.Secret = secret // Copy secret key to Api struct
+	return nil
+}
+
+// Agent waiting claim
+type AgentClaimDetails struct {
+	Name      string    `json:"name"`
+	RemoteIp  string    `json:"remote_ip"`
+	AgentType AgentType `json:"agent_type"`
+	Version   string    `json:"version"`
+}
+
+type AgentAccepted = openapi.AgentAccepted
+
+// Authorization header to endpoints with ApiKey security
+func (w *Client) apiKeyHeader() (map[string]string, error) {
+	if w.ApiKey == "" {
+		return nil, fmt.Errorf("api key required")
 	}
+	return map[string]string{"Authorization": fmt.Sprintf("Api-Key %s", w.ApiKey)}, nil
+}
 
-	assignSecretRequestBody, err := json.Marshal(Claim{
-		Code:    w.Code,
-		Agent:   AgentType,
-		Version: fmt.Sprintf("go-playit %s", GoPlayitVersion),
-	})
+// Get details of agent waiting claim with code, require ApiKey
+func (w *Client) ClaimDetails(ctx context.Context, Code string) (*AgentClaimDetails, error) {
+	headers, err := w.apiKeyHeader()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	body, err := json.Marshal(struct {
+		Code string `json:"code"`
+	}{Code})
+	if err != nil {
+		return nil, err
 	}
 
-	for {
-		var waitUser string
-		_, err = w.requestToApi("/claim/setup", bytes.NewReader(assignSecretRequestBody[:]), &waitUser, nil)
-		if err != nil {
-			return err
-		}
-
-		if waitUser == "UserRejected" {
-			return fmt.Errorf("claim rejected")
-		} else if waitUser == "UserAccepted" {
-			break
-		}
-		// wait for request
-		time.Sleep(time.Second)
+	var details AgentClaimDetails
+	if _, err = w.requestToApi(ctx, "/claim/details", bytes.NewReader(body), &details, headers); err != nil {
+		return nil, err
 	}
-	var getCode Code
-	getCode.Code = w.Code
+	return &details, nil
+}
 
-	// Code to json
-	exchangeBody, err := json.Marshal(getCode)
+// Accept agent claim to account, require ApiKey
+func (w *Client) ClaimAccept(ctx context.Context, Code, Name string, Type AgentType) (*AgentAccepted, error) {
+	if !Type.Valid() {
+		return nil, fmt.Errorf("set valid agent type")
+	}
+	headers, err := w.apiKeyHeader()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	body, err := json.Marshal(struct {
+		Code      string    `json:"code"`
+		Name      string    `json:"name"`
+		AgentType AgentType `json:"agent_type"`
+	}{Code, Name, Type})
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = w.requestToApi("/claim/exchange", bytes.NewBuffer(exchangeBody), &getCode, nil)
+	var accepted AgentAccepted
+	if _, err = w.requestToApi(ctx, "/claim/accept", bytes.NewReader(body), &accepted, headers); err != nil {
+		return nil, err
+	}
+	return &accepted, nil
+}
+
+// Reject agent claim, require ApiKey
+func (w *Client) ClaimReject(ctx context.Context, Code string) error {
+	headers, err := w.apiKeyHeader()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(struct {
+		Code string `json:"code"`
+	}{Code})
 	if err != nil {
 		return err
 	}
-	w.Secret = getCode.SecretKey // Copy secret key to Api struct
 
-	return nil
+	_, err = w.requestToApi(ctx, "/claim/reject", bytes.NewReader(body), nil, headers)
+	return err
 }
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+const DefaultClaimPoll time.Duration = time.Second // Default interval to check claim setup
+
+var ErrClaimRejected = errors.New("claim rejected")
+
+type ClaimState string
+
+const (
+	ClaimWaiting   ClaimState = "waiting"   // Waiting user open ClaimUrl and accept
+	ClaimAccepted  ClaimState = "accepted"  // User accepted, exchanging secret
+	ClaimRejected  ClaimState = "rejected"  // User rejected agent
+	ClaimExpired   ClaimState = "expired"   // Code expired or session deadline reached
+	ClaimExchanged ClaimState = "exchanged" // Agent secret received
+)
+
+type ClaimEvent struct {
+	State ClaimState
+	Setup string // Last ClaimSetupResponse: WaitingForUserVisit, WaitingForUser, UserAccepted or UserRejected
+}
+
+// Claim agent secret with code, emit events in every state change
+type ClaimSession struct {
+	Client       *Client
+	AgentType    AgentType     // Agent type to claim, default is AgentTypeDefault
+	Name         string        // Agent name in version text, default is "go-playit"
+	Platform     string        // Agent platform in version text, default is runtime.GOOS
+	PollInterval time.Duration // Interval to check claim, default is DefaultClaimPoll
+	Timeout      time.Duration // Max time to wait user, 0 wait until ctx is done
+
+	OnEvent func(ClaimEvent)  // Called in every state change
+	Events  chan<- ClaimEvent // Receive every state change, sent blocking until ctx is done, after only if channel have space
+
+	last ClaimEvent
+}
+
+func (session *ClaimSession) version() string {
+	name, platform := session.Name, session.Platform
+	if name == "" {
+		name = "go-playit"
+	}
+	if platform == "" {
+		platform = runtime.GOOS
+	}
+	return fmt.Sprintf("%s %s %s", name, GoPlayitVersion, platform)
+}
+
+func (session *ClaimSession) emit(ctx context.Context, event ClaimEvent) {
+	if event == session.last {
+		return
+	}
+	session.last = event
+	if session.OnEvent != nil {
+		session.OnEvent(event)
+	}
+	if session.Events != nil {
+		select {
+		case session.Events <- event:
+		case <-ctx.Done():
+			// Session done, send only if channel have space
+			select {
+			case session.Events <- event:
+			default:
+			}
+		}
+	}
+}
+
+// Wait user accept claim and exchange secret key, Client.Code must be set.
+//
+// Return ErrClaimRejected if user reject, ErrClaimSetupCodeExpired if code expire
+// and context error if ctx is done or Timeout reached, timeout of one request is polled again
+func (session *ClaimSession) Run(ctx context.Context) (string, error) {
+	agentType := session.AgentType
+	if agentType == "" {
+		agentType = AgentTypeDefault
+	}
+	if session.Client.Code == "" {
+		return "", fmt.Errorf("assign claim code")
+	} else if !agentType.Valid() {
+		return "", fmt.Errorf("set valid agent type")
+	}
+
+	session.last = ClaimEvent{}
+	if session.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, session.Timeout)
+		defer cancel()
+	}
+	poll := session.PollInterval
+	if poll <= 0 {
+		poll = DefaultClaimPoll
+	}
+
+	setupBody, err := json.Marshal(struct {
+		Code    string    `json:"code"`
+		Agent   AgentType `json:"agent_type"`
+		Version string    `json:"version"`
+	}{session.Client.Code, agentType, session.version()})
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		var waitUser string
+		_, err = session.Client.requestToApi(ctx, "/claim/setup", bytes.NewReader(setupBody), &waitUser, nil)
+		if errors.Is(err, ErrClaimSetupCodeExpired) || (err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+			session.emit(ctx, ClaimEvent{State: ClaimExpired, Setup: session.last.Setup})
+			return "", err
+		} else if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return "", err
+		} else if err == nil {
+			if waitUser == "UserRejected" {
+				session.emit(ctx, ClaimEvent{State: ClaimRejected, Setup: waitUser})
+				return "", ErrClaimRejected
+			} else if waitUser == "UserAccepted" {
+				session.emit(ctx, ClaimEvent{State: ClaimAccepted, Setup: waitUser})
+				break
+			}
+			session.emit(ctx, ClaimEvent{State: ClaimWaiting, Setup: waitUser})
+		}
+		// Request timeout with session running is checked again in next poll
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				session.emit(ctx, ClaimEvent{State: ClaimExpired, Setup: session.last.Setup})
+			}
+			return "", ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+
+	exchangeBody, err := json.Marshal(struct {
+		Code string `json:"code"`
+	}{session.Client.Code})
+	if err != nil {
+		return "", err
+	}
+
+	var secret struct {
+		SecretKey string `json:"secret_key"`
+	}
+	if _, err = session.Client.requestToApi(ctx, "/claim/exchange", bytes.NewReader(exchangeBody), &secret, nil); err != nil {
+		return "", err
+	}
+	session.emit(ctx, ClaimEvent{State: ClaimExchanged, Setup: session.last.Setup})
+	return secret.SecretKey, nil
+}
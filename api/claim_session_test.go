@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func claimServer(t *testing.T, setup func(call int32) (string, time.Duration)) *Client {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/claim/setup":
+			state, delay := setup(calls.Add(1))
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(delay):
+			}
+			w.Write([]byte(`{"status":"success","data":"` + state + `"}`))
+		case "/claim/exchange":
+			w.Write([]byte(`{"status":"success","data":{"secret_key":"secret"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return &Client{BaseURL: server.URL, Code: "code", Timeout: 50 * time.Millisecond, MaxRetries: -1}
+}
+
+func TestClaimSessionRequestTimeout(t *testing.T) {
+	// First poll timeout, session must poll again
+	client := claimServer(t, func(call int32) (string, time.Duration) {
+		if call == 1 {
+			return "WaitingForUser", time.Second
+		}
+		return "UserAccepted", 0
+	})
+
+	var states []ClaimState
+	session := ClaimSession{Client: client, PollInterval: time.Millisecond, Timeout: 5 * time.Second, OnEvent: func(event ClaimEvent) {
+		states = append(states, event.State)
+	}}
+	secret, err := session.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if secret != "secret" {
+		t.Fatalf("secret %q", secret)
+	}
+	for _, state := range states {
+		if state == ClaimExpired {
+			t.Fatalf("request timeout emitted expired: %v", states)
+		}
+	}
+}
+
+func TestClaimSessionExpired(t *testing.T) {
+	client := claimServer(t, func(int32) (string, time.Duration) { return "WaitingForUser", 0 })
+
+	// Nobody read events, Run must not block after session deadline
+	events := make(chan ClaimEvent)
+	session := ClaimSession{Client: client, PollInterval: 10 * time.Millisecond, Timeout: 100 * time.Millisecond, Events: events}
+	done := make(chan error, 1)
+	go func() {
+		_, err := session.Run(context.Background())
+		done <- err
+	}()
+	if event := <-events; event.State != ClaimWaiting {
+		t.Fatalf("first event %s", event.State)
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline error, got %v", err)
+		} else if session.last.State != ClaimExpired {
+			t.Fatalf("last state %s", session.last.State)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run blocked sending expired event")
+	}
+
+	// Buffered channel receive expired event
+	buffered := make(chan ClaimEvent, 4)
+	session = ClaimSession{Client: client, PollInterval: 10 * time.Millisecond, Timeout: 100 * time.Millisecond, Events: buffered}
+	if _, err := session.Run(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	close(buffered)
+	var last ClaimEvent
+	for event := range buffered {
+		last = event
+	}
+	if last.State != ClaimExpired || last.Setup != "WaitingForUser" {
+		t.Fatalf("last event %+v", last)
+	}
+}
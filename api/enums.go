@@ -0,0 +1,326 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Enums are validated on encode and by UnmarshalText, JSON decode keep values added to API
+// after this version as is, so responses with new regions or types still decode
+
+// Protocol of tunnel ports
+type PortType string
+
+// Game or service of tunnel, empty is custom tunnel
+type TunnelType string
+
+// Region to allocate tunnel
+type Region string
+
+// Type of agent, set on claim
+type AgentType string
+
+// Status of tunnel allocation
+type AllocationStatus string
+
+// Reason of disabled tunnel
+type TunnelDisabledReason string
+
+// How tunnel domain is assigned
+type TunnelDomainSource string
+
+// Who disabled agent tunnel
+type AgentTunnelDisabled string
+
+const (
+	PortTypeBoth PortType = "both" // Tunnel support tcp and udp protocol
+	PortTypeTcp  PortType = "tcp"  // Tunnel support only tcp protocol
+	PortTypeUdp  PortType = "udp"  // Tunnel support only udp protocol
+
+	TunnelTypeMCBedrock TunnelType = "minecraft-bedrock" // Minecraft Bedrock server
+	TunnelTypeMCJava    TunnelType = "minecraft-java"    // Minecraft java server
+	TunnelTypeValheim   TunnelType = "valheim"           // valheim
+	TunnelTypeTerraria  TunnelType = "terraria"          // Terraria multiplayer
+	TunnelTypeStarbound TunnelType = "starbound"         // starbound
+	TunnelTypeRust      TunnelType = "rust"              // Rust (No programmer language)
+	TunnelType7Days     TunnelType = "7days"             // 7days
+	TunnelTypeUnturned  TunnelType = "unturned"          // unturned
+
+	RegionGlobal       Region = "global"        // Free account and premium
+	RegionSmartGlobal  Region = "smart-global"  // Require premium account
+	RegionNorthAmerica Region = "north-america" // Require premium account
+	RegionEurope       Region = "europe"        // Require premium account
+	RegionAsia         Region = "asia"          // Require premium account
+	RegionIndia        Region = "india"         // Require premium account
+	RegionSouthAmerica Region = "south-america" // Require premium account
+
+	AgentTypeDefault     AgentType = "default"      // Agent with tunnels of account
+	AgentTypeAssignable  AgentType = "assignable"   // Agent with tunnels assigned to it
+	AgentTypeSelfManaged AgentType = "self-managed" // Agent managing own tunnels
+
+	AllocationPending   AllocationStatus = "pending"   // Waiting allocation, without data
+	AllocationDisabled  AllocationStatus = "disabled"  // Tunnel disabled, data is TunnelDisabled
+	AllocationAllocated AllocationStatus = "allocated" // Tunnel allocated, data is TunnelAllocated
+
+	TunnelDisabledRequiresPremium TunnelDisabledReason = "requires-premium" // Require premium account
+	TunnelDisabledOverPortLimit   TunnelDisabledReason = "over-port-limit"  // Account is over port limit
+	TunnelDisabledIpUsedInGre     TunnelDisabledReason = "ip-used-in-gre"   // Dedicated ip used in GRE
+
+	TunnelDomainFromIp      TunnelDomainSource = "from-ip"       // Domain of tunnel ip
+	TunnelDomainFromTunnel  TunnelDomainSource = "from-tunnel"   // Domain of tunnel
+	TunnelDomainFromAgentIp TunnelDomainSource = "from-agent-ip" // Domain of agent ip
+
+	AgentTunnelDisabledByUser   AgentTunnelDisabled = "ByUser"   // Disabled by user
+	AgentTunnelDisabledBySystem AgentTunnelDisabled = "BySystem" // Disabled by playit
+)
+
+var (
+	PortTypes []PortType = []PortType{
+		PortTypeBoth,
+		PortTypeTcp,
+		PortTypeUdp,
+	} // Tunnel protocol supports
+	TunnelTypes []TunnelType = []TunnelType{
+		TunnelTypeMCBedrock,
+		TunnelTypeMCJava,
+		TunnelTypeValheim,
+		TunnelTypeTerraria,
+		TunnelTypeStarbound,
+		TunnelTypeRust,
+		TunnelType7Days,
+		TunnelTypeUnturned,
+	} // Tunnel slice with current supported tunnels
+	Regions []Region = []Region{
+		RegionSmartGlobal,
+		RegionGlobal,
+		RegionNorthAmerica,
+		RegionEurope,
+		RegionAsia,
+		RegionIndia,
+		RegionSouthAmerica,
+	} // Regions slice
+	AgentTypes []AgentType = []AgentType{
+		AgentTypeDefault,
+		AgentTypeAssignable,
+		AgentTypeSelfManaged,
+	} // Agent types accepted by claim, first is default
+	AllocationStatuses []AllocationStatus = []AllocationStatus{
+		AllocationPending,
+		AllocationDisabled,
+		AllocationAllocated,
+	} // Tunnel allocation status
+	TunnelDisabledReasons []TunnelDisabledReason = []TunnelDisabledReason{
+		TunnelDisabledRequiresPremium,
+		TunnelDisabledOverPortLimit,
+		TunnelDisabledIpUsedInGre,
+	} // Reasons of disabled tunnel
+	TunnelDomainSources []TunnelDomainSource = []TunnelDomainSource{
+		TunnelDomainFromIp,
+		TunnelDomainFromTunnel,
+		TunnelDomainFromAgentIp,
+	} // Sources of tunnel domain
+	AgentTunnelDisabledTypes []AgentTunnelDisabled = []AgentTunnelDisabled{
+		AgentTunnelDisabledByUser,
+		AgentTunnelDisabledBySystem,
+	} // Agent tunnel disabled by
+)
+
+type enum interface {
+	~string
+	Valid() bool
+}
+
+func marshalEnum[T enum](name string, value T) ([]byte, error) {
+	if !value.Valid() {
+		return nil, fmt.Errorf("invalid %s %q", name, string(value))
+	}
+	return []byte(value), nil
+}
+
+func unmarshalEnum[T enum](name string, text []byte, value *T) error {
+	if !T(text).Valid() {
+		return fmt.Errorf("invalid %s %q", name, string(text))
+	}
+	*value = T(text)
+	return nil
+}
+
+func marshalEnumJSON[T enum](name string, value T) ([]byte, error) {
+	text, err := marshalEnum(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+// Decode JSON string without Valid check, unknown values are from newer API,
+// null keep value unchanged
+func unmarshalEnumJSON[T enum](name string, body []byte, value *T) error {
+	if string(body) == "null" {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(body, &text); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	*value = T(text)
+	return nil
+}
+
+func (value PortType) String() string {
+	return string(value)
+}
+func (value PortType) Valid() bool {
+	return slices.Contains(PortTypes, value)
+}
+func (value PortType) MarshalText() ([]byte, error) {
+	return marshalEnum("port type", value)
+}
+func (value *PortType) UnmarshalText(text []byte) error {
+	return unmarshalEnum("port type", text, value)
+}
+func (value PortType) MarshalJSON() ([]byte, error) {
+	return marshalEnumJSON("port type", value)
+}
+func (value *PortType) UnmarshalJSON(body []byte) error {
+	return unmarshalEnumJSON("port type", body, value)
+}
+
+// Port type include proto, both include tcp and udp
+func (value PortType) Has(proto PortType) bool {
+	return value == proto || value == PortTypeBoth
+}
+
+func (value TunnelType) String() string {
+	return string(value)
+}
+func (value TunnelType) Valid() bool {
+	return slices.Contains(TunnelTypes, value)
+}
+func (value TunnelType) MarshalText() ([]byte, error) {
+	return marshalEnum("tunnel type", value)
+}
+func (value *TunnelType) UnmarshalText(text []byte) error {
+	return unmarshalEnum("tunnel type", text, value)
+}
+func (value TunnelType) MarshalJSON() ([]byte, error) {
+	return marshalEnumJSON("tunnel type", value)
+}
+func (value *TunnelType) UnmarshalJSON(body []byte) error {
+	return unmarshalEnumJSON("tunnel type", body, value)
+}
+
+func (value Region) String() string {
+	return string(value)
+}
+func (value Region) Valid() bool {
+	return slices.Contains(Regions, value)
+}
+func (value Region) MarshalText() ([]byte, error) {
+	return marshalEnum("region", value)
+}
+func (value *Region) UnmarshalText(text []byte) error {
+	return unmarshalEnum("region", text, value)
+}
+func (value Region) MarshalJSON() ([]byte, error) {
+	return marshalEnumJSON("region", value)
+}
+func (value *Region) UnmarshalJSON(body []byte) error {
+	return unmarshalEnumJSON("region", body, value)
+}
+
+func (value AgentType) String() string {
+	return string(value)
+}
+func (value AgentType) Valid() bool {
+	return slices.Contains(AgentTypes, value)
+}
+func (value AgentType) MarshalText() ([]byte, error) {
+	return marshalEnum("agent type", value)
+}
+func (value *AgentType) UnmarshalText(text []byte) error {
+	return unmarshalEnum("agent type", text, value)
+}
+func (value AgentType) MarshalJSON() ([]byte, error) {
+	return marshalEnumJSON("agent type", value)
+}
+func (value *AgentType) UnmarshalJSON(body []byte) error {
+	return unmarshalEnumJSON("agent type", body, value)
+}
+
+func (value AllocationStatus) String() string {
+	return string(value)
+}
+func (value AllocationStatus) Valid() bool {
+	return slices.Contains(AllocationStatuses, value)
+}
+func (value AllocationStatus) MarshalText() ([]byte, error) {
+	return marshalEnum("allocation status", value)
+}
+func (value *AllocationStatus) UnmarshalText(text []byte) error {
+	return unmarshalEnum("allocation status", text, value)
+}
+func (value AllocationStatus) MarshalJSON() ([]byte, error) {
+	return marshalEnumJSON("allocation status", value)
+}
+func (value *AllocationStatus) UnmarshalJSON(body []byte) error {
+	return unmarshalEnumJSON("allocation status", body, value)
+}
+
+func (value TunnelDisabledReason) String() string {
+	return string(value)
+}
+func (value TunnelDisabledReason) Valid() bool {
+	return slices.Contains(TunnelDisabledReasons, value)
+}
+func (value TunnelDisabledReason) MarshalText() ([]byte, error) {
+	return marshalEnum("disabled reason", value)
+}
+func (value *TunnelDisabledReason) UnmarshalText(text []byte) error {
+	return unmarshalEnum("disabled reason", text, value)
+}
+func (value TunnelDisabledReason) MarshalJSON() ([]byte, error) {
+	return marshalEnumJSON("disabled reason", value)
+}
+func (value *TunnelDisabledReason) UnmarshalJSON(body []byte) error {
+	return unmarshalEnumJSON("disabled reason", body, value)
+}
+
+func (value TunnelDomainSource) String() string {
+	return string(value)
+}
+func (value TunnelDomainSource) Valid() bool {
+	return slices.Contains(TunnelDomainSources, value)
+}
+func (value TunnelDomainSource) MarshalText() ([]byte, error) {
+	return marshalEnum("domain source", value)
+}
+func (value *TunnelDomainSource) UnmarshalText(text []byte) error {
+	return unmarshalEnum("domain source", text, value)
+}
+func (value TunnelDomainSource) MarshalJSON() ([]byte, error) {
+	return marshalEnumJSON("domain source", value)
+}
+func (value *TunnelDomainSource) UnmarshalJSON(body []byte) error {
+	return unmarshalEnumJSON("domain source", body, value)
+}
+
+func (value AgentTunnelDisabled) String() string {
+	return string(value)
+}
+func (value AgentTunnelDisabled) Valid() bool {
+	return slices.Contains(AgentTunnelDisabledTypes, value)
+}
+func (value AgentTunnelDisabled) MarshalText() ([]byte, error) {
+	return marshalEnum("tunnel disabled", value)
+}
+func (value *AgentTunnelDisabled) UnmarshalText(text []byte) error {
+	return unmarshalEnum("tunnel disabled", text, value)
+}
+func (value AgentTunnelDisabled) MarshalJSON() ([]byte, error) {
+	return marshalEnumJSON("tunnel disabled", value)
+}
+func (value *AgentTunnelDisabled) UnmarshalJSON(body []byte) error {
+	return unmarshalEnumJSON("tunnel disabled", body, value)
+}
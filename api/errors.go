@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+)
+
+//go:generate go run ./internal/openapigen -spec ../openapi.yaml -errors -out errors_gen.go -package api
+
+// Error kind, "error" responses use data.type and "fail" responses use enum name from openapi.yaml
+type ErrorKind string
+
+const (
+	ErrorKindHttp       ErrorKind = "http"       // Response without API body
+	ErrorKindValidation ErrorKind = "validation" // Invalid request body
+	ErrorKindAuth       ErrorKind = "auth"       // Code is AuthError value
+	ErrorKindInternal   ErrorKind = "internal"   // API internal error
+	ErrorKindFail       ErrorKind = "fail"       // Fail from endpoint without known enum, others kinds are in errors_gen.go
+)
+
+// Error returned by API, compare with errors.Is to sentinel values generated from openapi.yaml like ErrTunnelCreateAgentNotFound
+type Error struct {
+	StatusCode int       // HTTP status
+	Kind       ErrorKind // Error kind
+	Code       string    // Enum value, empty to validation, internal and http errors
+	Message    string    // Validation message
+	Body       []byte    // Raw response body
+}
+
+func (err *Error) Error() string {
+	switch {
+	case err.Kind == ErrorKindHttp:
+		return fmt.Sprintf("api: http %d %s", err.StatusCode, http.StatusText(err.StatusCode))
+	case err.Code != "":
+		return fmt.Sprintf("api: %s: %s", err.Kind, err.Code)
+	case err.Message != "":
+		return fmt.Sprintf("api: %s: %s", err.Kind, err.Message)
+	}
+	return fmt.Sprintf("api: %s", err.Kind)
+}
+
+// Match error with same Kind and Code, empty fields in target match any value
+func (err *Error) Is(target error) bool {
+	other, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return (other.Kind == "" || other.Kind == err.Kind) &&
+		(other.Code == "" || other.Code == err.Code) &&
+		(other.StatusCode == 0 || other.StatusCode == err.StatusCode)
+}
+
+var (
+	ErrValidation   = &Error{Kind: ErrorKindValidation}
+	ErrUnauthorized = &Error{Kind: ErrorKindAuth}
+	ErrInternal     = &Error{Kind: ErrorKindInternal}
+)
+
+// Create Error from response body, Status is "error" or "fail"
+func newError(Path string, res *http.Response, body []byte, Status string, Data any) *Error {
+	apiErr := &Error{StatusCode: res.StatusCode, Kind: ErrorKindHttp, Body: body}
+	switch Status {
+	case "fail":
+		if apiErr.Kind = failKinds[Path]; apiErr.Kind == "" {
+			apiErr.Kind = ErrorKindFail
+		}
+		apiErr.Code, _ = Data.(string)
+	case "error":
+		var errStatus struct {
+			Type    string `json:"type"`
+			Message any    `json:"message"`
+		}
+		if recodeJson(&Data, &errStatus) != nil || errStatus.Type == "" {
+			break
+		}
+		apiErr.Kind = ErrorKind(errStatus.Type)
+		if message, isString := errStatus.Message.(string); isString {
+			if apiErr.Kind == ErrorKindAuth {
+				apiErr.Code = message
+			} else {
+				apiErr.Message = message
+			}
+		}
+	}
+	return apiErr
+}
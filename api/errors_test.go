@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestNewError(t *testing.T) {
+	for _, test := range []struct {
+		path, body string
+		status     int
+		want       error
+	}{
+		{"/tunnels/create", `{"status":"fail","data":"AgentNotFound"}`, 400, ErrTunnelCreateAgentNotFound},
+		{"/tunnels/delete", `{"status":"fail","data":"TunnelNotFound"}`, 400, ErrDeleteTunnelNotFound},
+		{"/claim/accept", `{"status":"fail","data":"InvalidAgentType"}`, 400, ErrClaimAcceptInvalidAgentType},
+		{"/login/guest", `{"status":"fail","data":"AccountIsNotGuest"}`, 400, ErrGuestLoginAccountIsNotGuest},
+		{"/agents/rundata", `{"status":"error","data":{"type":"auth","message":"InvalidAgentKey"}}`, 401, ErrAuthInvalidAgentKey},
+		{"/agents/rundata", `{"status":"error","data":{"type":"auth","message":"InvalidAgentKey"}}`, 401, ErrUnauthorized},
+		{"/agents/rundata", `{"status":"error","data":{"type":"validation","message":"bad body"}}`, 400, ErrValidation},
+	} {
+		var body struct {
+			Status string `json:"status"`
+			Data   any    `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(test.body), &body); err != nil {
+			t.Fatal(err)
+		}
+		err := newError(test.path, &http.Response{StatusCode: test.status}, []byte(test.body), body.Status, body.Data)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: %s is not %s", test.path, err, test.want)
+		}
+	}
+
+	// Same code in other enum don't match
+	err := newError("/claim/setup", &http.Response{StatusCode: 400}, nil, "fail", "CodeExpired")
+	if !errors.Is(err, ErrClaimSetupCodeExpired) || errors.Is(err, ErrClaimExchangeCodeExpired) {
+		t.Errorf("%s matched wrong sentinel", err)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type generator struct {
+	spec    *yamlMap
+	schemas *yamlMap
+	imports map[string]bool
+	body    bytes.Buffer
+}
+
+type property struct {
+	Name     string
+	Owner    string // Schema with property, used to field types
+	Schema   any
+	Required bool
+}
+
+func newGenerator(spec *yamlMap) *generator {
+	return &generator{
+		spec:    spec,
+		schemas: spec.Map("components").Map("schemas"),
+		imports: map[string]bool{},
+	}
+}
+
+func (gen *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&gen.body, format, args...)
+}
+
+// Generated file with header and imports
+func (gen *generator) file(pkg string) []byte {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by openapigen from openapi.yaml; DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	var imports []string
+	for path := range gen.imports {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	if len(imports) > 0 {
+		out.WriteString("import (\n")
+		for _, path := range imports {
+			fmt.Fprintf(&out, "\t%q\n", path)
+		}
+		out.WriteString(")\n\n")
+	}
+	out.Write(gen.body.Bytes())
+	return out.Bytes()
+}
+
+// Register import of qualified type like uuid.UUID or []net.IP
+func (gen *generator) use(goType string) string {
+	name := strings.TrimLeft(goType, "[]*")
+	if pkg, _, ok := strings.Cut(name, "."); ok {
+		gen.imports[typeImports[pkg]] = true
+	}
+	return goType
+}
+
+func refName(schema any) string {
+	values, _ := schema.(*yamlMap)
+	ref := values.String("$ref")
+	if ref == "" {
+		return ""
+	}
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// Resolve $ref to schema and name
+func (gen *generator) resolve(schema any) (string, *yamlMap, error) {
+	name := refName(schema)
+	if name == "" {
+		values, _ := schema.(*yamlMap)
+		return "", values, nil
+	}
+	values := gen.schemas.Map(name)
+	if values == nil {
+		return "", nil, fmt.Errorf("schema %q not found", name)
+	}
+	return name, values, nil
+}
+
+// Schema is type without properties or union, enums are plain strings and api package declare enum types
+func isScalar(schema *yamlMap) bool {
+	kind := schema.String("type")
+	return kind == "string" || kind == "number" || kind == "integer" || kind == "boolean"
+}
+
+// Generated types, scalar schemas and oneOf variants are inlined
+func (gen *generator) isGenerated(name string) bool {
+	schema := gen.schemas.Map(name)
+	if strings.HasPrefix(name, "_") || strings.Contains(name, "_oneOf") || schema == nil {
+		return false
+	} else if _, ok := schemaTypes[name]; ok {
+		return false
+	}
+	return !isScalar(schema)
+}
+
+func scalarType(schema *yamlMap) string {
+	switch schema.String("type") {
+	case "string":
+		if schema.String("format") == "date-time" {
+			return "time.Time"
+		}
+		return "string"
+	case "number":
+		return "float64"
+	case "integer":
+		return "int64"
+	case "boolean":
+		return "bool"
+	}
+	return ""
+}
+
+// Go type of property schema
+func (gen *generator) goType(schema any, owner, field string) (string, error) {
+	if override := fieldType(owner, field); override != "" {
+		return gen.use(override), nil
+	}
+
+	name, values, err := gen.resolve(schema)
+	if err != nil {
+		return "", err
+	} else if values == nil {
+		return "", fmt.Errorf("%s.%s: invalid schema", owner, field)
+	}
+	if name != "" {
+		if override, ok := schemaTypes[name]; ok {
+			return gen.use(override), nil
+		} else if values.Get("oneOf") != nil {
+			return gen.use("json.RawMessage"), nil // Unions are declared in api, variants are in Unions
+		} else if gen.isGenerated(name) {
+			return goName(name), nil
+		}
+	}
+
+	if values.String("type") == "array" {
+		item, err := gen.goType(values.Get("items"), owner, field)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + item, nil
+	} else if kind := scalarType(values); kind != "" {
+		return gen.use(kind), nil
+	} else if values.String("type") == "object" && values.Get("properties") == nil {
+		return gen.use("json.RawMessage"), nil
+	}
+	return "", fmt.Errorf("%s.%s: inline schema not supported", owner, field)
+}
+
+// Properties of object, allOf schemas are merged
+func (gen *generator) properties(name string, schema *yamlMap) ([]property, error) {
+	var props []property
+	for _, part := range schema.List("allOf") {
+		partName, partSchema, err := gen.resolve(part)
+		if err != nil {
+			return nil, err
+		} else if partName == "" {
+			partName = name
+		}
+		partProps, err := gen.properties(partName, partSchema)
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, partProps...)
+	}
+
+	var required []string
+	for _, value := range schema.List("required") {
+		if field, ok := value.(string); ok {
+			required = append(required, field)
+		}
+	}
+	values := schema.Map("properties")
+	if values == nil {
+		return props, nil
+	}
+	for _, field := range values.Keys {
+		props = append(props, property{
+			Name:     field,
+			Owner:    name,
+			Schema:   values.Values[field],
+			Required: slices.Contains(required, field),
+		})
+	}
+	return props, nil
+}
+
+func (gen *generator) generate() error {
+	if gen.schemas == nil {
+		return fmt.Errorf("components.schemas not found")
+	}
+
+	names := slices.Clone(gen.schemas.Keys)
+	sort.Strings(names)
+	for _, name := range names {
+		if !gen.isGenerated(name) {
+			continue
+		}
+		if schema := gen.schemas.Map(name); schema.Get("oneOf") == nil {
+			if err := gen.object(name, schema); err != nil {
+				return fmt.Errorf("schema %s: %w", name, err)
+			}
+		}
+	}
+
+	if err := gen.unions(names); err != nil {
+		return err
+	}
+	return gen.enums(names)
+}
+
+// Values of enum schemas to test enums declared in api package, error enums are generated by -errors
+func (gen *generator) enums(names []string) error {
+	gen.printf("// Values of enum schemas in spec order, enum types are declared in api package\nvar Enums = map[string][]string{\n")
+	for _, name := range names {
+		schema := gen.schemas.Map(name)
+		if schema.Get("enum") == nil || strings.HasSuffix(name, "Error") {
+			continue
+		}
+		gen.printf("\t%q: {", name)
+		for index, value := range schema.List("enum") {
+			text, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("schema %s: enum value is not string", name)
+			} else if index > 0 {
+				gen.printf(", ")
+			}
+			gen.printf("%q", text)
+		}
+		gen.printf("},\n")
+	}
+	gen.printf("}\n")
+	return nil
+}
+
+func (gen *generator) object(name string, schema *yamlMap) error {
+	props, err := gen.properties(name, schema)
+	if err != nil {
+		return err
+	}
+
+	gen.printf("type %s struct {\n", goName(name))
+	for _, prop := range props {
+		goType, err := gen.goType(prop.Schema, prop.Owner, prop.Name)
+		if err != nil {
+			return err
+		}
+		tag := prop.Name
+		if !prop.Required {
+			tag += ",omitempty"
+			if !isNilable(goType) {
+				goType = "*" + goType
+			}
+		}
+		gen.printf("\t%s %s `json:%q`\n", goName(prop.Name), goType, tag)
+	}
+	gen.printf("}\n\n")
+	return nil
+}
+
+type unionVariant struct {
+	Value   string // Tag value
+	Content string // Property with variant data, empty if variant only have tag
+	GoType  string
+}
+
+// oneOf of objects with same tag property, every variant has tag with one enum value
+func (gen *generator) unionVariants(name string, schema *yamlMap) (string, []unionVariant, error) {
+	var variantProps [][]property
+	for _, option := range schema.List("oneOf") {
+		optionName, optionSchema, err := gen.resolve(option)
+		if err != nil {
+			return "", nil, err
+		} else if optionSchema == nil {
+			return "", nil, fmt.Errorf("invalid oneOf option")
+		}
+		props, err := gen.properties(optionName, optionSchema)
+		if err != nil {
+			return "", nil, err
+		}
+		variantProps = append(variantProps, props)
+	}
+	if len(variantProps) == 0 {
+		return "", nil, fmt.Errorf("oneOf without options")
+	}
+
+	// Tag is first property with single enum value in every variant
+	tagValue := func(prop property) string {
+		values, _ := prop.Schema.(*yamlMap)
+		if enum := values.List("enum"); len(enum) == 1 {
+			value, _ := enum[0].(string)
+			return value
+		}
+		return ""
+	}
+	var tag string
+	for _, prop := range variantProps[0] {
+		if tagValue(prop) == "" {
+			continue
+		}
+		if !slices.ContainsFunc(variantProps, func(props []property) bool {
+			return !slices.ContainsFunc(props, func(other property) bool { return other.Name == prop.Name && tagValue(other) != "" })
+		}) {
+			tag = prop.Name
+			break
+		}
+	}
+	if tag == "" {
+		return "", nil, fmt.Errorf("oneOf without tag property")
+	}
+
+	var variants []unionVariant
+	for _, props := range variantProps {
+		var variant unionVariant
+		var content []property
+		for _, prop := range props {
+			if prop.Name == tag {
+				variant.Value = tagValue(prop)
+			} else {
+				content = append(content, prop)
+			}
+		}
+		switch len(content) {
+		case 0:
+		case 1:
+			goType, err := gen.goType(content[0].Schema, content[0].Owner, content[0].Name)
+			if err != nil {
+				return "", nil, err
+			}
+			variant.Content, variant.GoType = content[0].Name, goType
+		default:
+			return "", nil, fmt.Errorf("variant %q with more than one property", variant.Value)
+		}
+		variants = append(variants, variant)
+	}
+	return tag, variants, nil
+}
+
+// Variants of oneOf schemas to test unions declared in api package
+func (gen *generator) unions(names []string) error {
+	gen.printf("// Variants of oneOf schemas, union types are declared in api package\nvar Unions = map[string]Union{\n")
+	for _, name := range names {
+		schema := gen.schemas.Map(name)
+		if !gen.isGenerated(name) || schema.Get("oneOf") == nil {
+			continue
+		}
+		tag, variants, err := gen.unionVariants(name, schema)
+		if err != nil {
+			return fmt.Errorf("schema %s: %w", name, err)
+		}
+		gen.printf("\t%q: {Tag: %q, Variants: map[string]Variant{\n", name, tag)
+		for _, variant := range variants {
+			switch {
+			case variant.Content == "":
+				gen.printf("\t\t%q: {},\n", variant.Value)
+			case unicode.IsUpper(rune(variant.GoType[0])):
+				gen.printf("\t\t%q: {Content: %q, Data: %s{}},\n", variant.Value, variant.Content, variant.GoType)
+			default:
+				gen.printf("\t\t%q: {Content: %q, Data: *new(%s)},\n", variant.Value, variant.Content, variant.GoType)
+			}
+		}
+		gen.printf("\t}},\n")
+	}
+	gen.printf("}\n\n")
+	return nil
+}
+
+// Schema of application/json content
+func jsonSchema(content any) any {
+	values, _ := content.(*yamlMap)
+	return values.Map("content").Map("application/json").Get("schema")
+}
+
+// Enum schema of fail response, fail is oneOf option with {"status": "fail", "data": enum}
+func (gen *generator) failEnum(path string, operation *yamlMap) (string, error) {
+	if schema, ok := failEnums[path]; ok {
+		return schema, nil
+	}
+	_, failSchema, err := gen.resolve(jsonSchema(operation.Map("responses").Get("400")))
+	if err != nil || failSchema == nil {
+		return "", nil
+	}
+	for _, option := range failSchema.List("oneOf") {
+		_, optionSchema, err := gen.resolve(option)
+		if err != nil {
+			return "", err
+		}
+		props := optionSchema.Map("properties")
+		if status := props.Map("status").List("enum"); len(status) == 1 && status[0] == "fail" {
+			return refName(props.Get("data")), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,57 @@
+// Command openapigen generate Go types, enum values and union variants from playit OpenAPI spec.
+//
+//	go run ./api/internal/openapigen -spec openapi.yaml -out api/openapi/openapi_gen.go -package openapi
+//
+// With -errors generate only error kinds and sentinels of error enums to api package:
+//
+//	go run ./api/internal/openapigen -spec openapi.yaml -errors -out api/errors_gen.go -package api
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+)
+
+func main() {
+	specPath := flag.String("spec", "openapi.yaml", "OpenAPI spec in YAML")
+	outPath := flag.String("out", "openapi_gen.go", "Go file to write")
+	pkg := flag.String("package", "openapi", "Go package name")
+	errorsOnly := flag.Bool("errors", false, "Generate only error sentinels")
+	flag.Parse()
+
+	if err := run(*specPath, *outPath, *pkg, *errorsOnly); err != nil {
+		fmt.Fprintf(os.Stderr, "openapigen: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(specPath, outPath, pkg string, errorsOnly bool) error {
+	body, err := os.ReadFile(specPath)
+	if err != nil {
+		return err
+	}
+	doc, err := parseYaml(string(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", specPath, err)
+	}
+	spec, isMap := doc.(*yamlMap)
+	if !isMap {
+		return fmt.Errorf("%s: root is not mapping", specPath)
+	}
+
+	gen := newGenerator(spec)
+	generate := gen.generate
+	if errorsOnly {
+		generate = gen.sentinels
+	}
+	if err := generate(); err != nil {
+		return err
+	}
+	source, err := format.Source(gen.file(pkg))
+	if err != nil {
+		return fmt.Errorf("format generated code: %w", err)
+	}
+	return os.WriteFile(outPath, source, 0o644)
+}
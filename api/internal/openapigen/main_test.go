@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Generated files in repository must match openapi.yaml, run go generate if fail
+func TestGeneratedUpToDate(t *testing.T) {
+	for _, test := range []struct {
+		out, pkg   string
+		errorsOnly bool
+	}{
+		{"../../openapi/openapi_gen.go", "openapi", false},
+		{"../../errors_gen.go", "api", true},
+	} {
+		tmp := filepath.Join(t.TempDir(), "gen.go")
+		if err := run("../../../openapi.yaml", tmp, test.pkg, test.errorsOnly); err != nil {
+			t.Fatal(err)
+		}
+		generated, err := os.ReadFile(tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current, err := os.ReadFile(test.out)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(current) != string(generated) {
+			t.Errorf("%s is out of date, run go generate", test.out)
+		}
+	}
+}
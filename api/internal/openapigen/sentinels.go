@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Error enums not used by fail responses, key is enum schema and value is ErrorKind declared in api package
+var errorEnumKinds = map[string]string{
+	"AuthError": "ErrorKindAuth", // "error" response with type "auth" and enum in message
+}
+
+type errorEnum struct {
+	Schema string   // Enum schema, like TunnelCreateError
+	Kind   string   // Go ErrorKind const, like ErrorKindTunnelCreate
+	Values []string // Enum values
+}
+
+// Error kind name without Error suffix, TunnelCreateError is TunnelCreate
+func errorBase(schema string) string {
+	return goName(strings.TrimSuffix(schema, "Error"))
+}
+
+func (gen *generator) errorEnum(schema string) (errorEnum, error) {
+	enum := errorEnum{Schema: schema, Kind: errorEnumKinds[schema]}
+	if enum.Kind == "" {
+		enum.Kind = "ErrorKind" + errorBase(schema)
+	}
+	values := gen.schemas.Map(schema)
+	if values == nil {
+		return enum, fmt.Errorf("schema %q not found", schema)
+	}
+	for _, value := range values.List("enum") {
+		text, ok := value.(string)
+		if !ok {
+			return enum, fmt.Errorf("%s: enum value is not string", schema)
+		}
+		enum.Values = append(enum.Values, text)
+	}
+	if len(enum.Values) == 0 {
+		return enum, fmt.Errorf("%s: is not enum", schema)
+	}
+	return enum, nil
+}
+
+// Generate ErrorKind of fail enums, failKinds to every endpoint and Err sentinels to api package
+func (gen *generator) sentinels() error {
+	if gen.schemas == nil {
+		return fmt.Errorf("components.schemas not found")
+	}
+	paths := gen.spec.Map("paths")
+	if paths == nil {
+		return fmt.Errorf("paths not found")
+	}
+
+	var enums []errorEnum
+	for _, schema := range []string{"AuthError"} {
+		enum, err := gen.errorEnum(schema)
+		if err != nil {
+			return err
+		}
+		enums = append(enums, enum)
+	}
+
+	// Fail enum of endpoints in spec order
+	failPaths := map[string]string{}
+	var pathOrder []string
+	for _, path := range paths.Keys {
+		operation := paths.Map(path).Map("post")
+		if operation == nil {
+			continue
+		}
+		schema, err := gen.failEnum(path, operation)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		} else if schema == "" {
+			continue
+		}
+		enum, err := gen.errorEnum(schema)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		failPaths[path] = enum.Kind
+		pathOrder = append(pathOrder, path)
+		if !slices.ContainsFunc(enums, func(other errorEnum) bool { return other.Schema == schema }) {
+			enums = append(enums, enum)
+		}
+	}
+
+	gen.printf("// Kinds of \"fail\" responses, value is enum name from openapi.yaml\nconst (\n")
+	for _, enum := range enums {
+		if _, declared := errorEnumKinds[enum.Schema]; !declared {
+			gen.printf("\t%s ErrorKind = %q\n", enum.Kind, enum.Schema)
+		}
+	}
+	gen.printf(")\n\n// Enum used by \"fail\" response of endpoint\nvar failKinds = map[string]ErrorKind{\n")
+	for _, path := range pathOrder {
+		gen.printf("\t%q: %s,\n", path, failPaths[path])
+	}
+	gen.printf("}\n\nvar (\n")
+	for index, enum := range enums {
+		if index > 0 {
+			gen.printf("\n")
+		}
+		gen.printf("\t// %s\n", enum.Schema)
+		for _, value := range enum.Values {
+			gen.printf("\tErr%s%s = &Error{Kind: %s, Code: %q}\n", errorBase(enum.Schema), goName(value), enum.Kind, value)
+		}
+	}
+	gen.printf(")\n")
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import "strings"
+
+// Go types of named scalar schemas
+var schemaTypes = map[string]string{
+	"u16":         "uint16",
+	"u32":         "uint32",
+	"u64":         "uint64",
+	"bool":        "bool",
+	"Uuid":        "uuid.UUID",
+	"DateTime":    "time.Time",
+	"DateTimeUtc": "time.Time",
+	"IpAddr":      "netip.Addr",
+	"Ipv4Addr":    "netip.Addr",
+	"Ipv6Addr":    "netip.Addr",
+	"SocketAddr":  "netip.AddrPort",
+}
+
+// Go types of fields, spec only have "number" and "string" to integers, ids and addresses.
+// Key is "Schema.field" or "*.field" to every schema
+var fieldTypes = map[string]string{
+	"*.id":          "uuid.UUID",
+	"*.agent_id":    "uuid.UUID",
+	"*.tunnel_id":   "uuid.UUID",
+	"*.alloc_id":    "uuid.UUID",
+	"*.firewall_id": "uuid.UUID",
+	"*.local_ip":    "net.IP",
+	"*.local_port":  "uint16",
+	"*.port_count":  "uint16",
+	"*.client_addr": "netip.AddrPort",
+	"*.tunnel_addr": "netip.AddrPort",
+
+	"AgentTunnel.ip_num":           "uint16",
+	"AgentTunnel.region_num":       "uint16",
+	"AllocatedPorts.allowed":       "uint16",
+	"AllocatedPorts.claimed":       "uint16",
+	"AllocatedPorts.desired":       "uint16",
+	"PortRange.from":               "uint16",
+	"PortRange.to":                 "uint16",
+	"Ratelimit.bytes_per_second":   "uint64",
+	"Ratelimit.packets_per_second": "uint64",
+	"SignedEpoch.epoch_sec":        "uint64",
+	"TunnelAllocated.port_start":   "uint16",
+	"TunnelAllocated.port_end":     "uint16",
+	"TunnelAllocated.static_ip4":   "net.IP",
+	"TunnelAllocated.tunnel_ip":    "net.IP",
+	"UseAllocDedicatedIp.port":     "uint16",
+	"WebAuth.account_id":           "uint64",
+	"WebAuth.admin_id":             "uint64",
+	"WebAuth.timestamp":            "uint64",
+	"WebAuth.update_version":       "uint64",
+}
+
+// Fail enum of endpoints without "fail" option in 400 response of spec
+var failEnums = map[string]string{
+	"/tunnels/delete": "DeleteError",
+	"/login/guest":    "GuestLoginError",
+}
+
+// Package of qualified type
+var typeImports = map[string]string{
+	"uuid":    "github.com/google/uuid",
+	"time":    "time",
+	"netip":   "net/netip",
+	"net":     "net",
+	"json":    "encoding/json",
+	"fmt":     "fmt",
+	"context": "context",
+}
+
+func fieldType(owner, field string) string {
+	if value, ok := fieldTypes[owner+"."+field]; ok {
+		return value
+	}
+	return fieldTypes["*."+field]
+}
+
+// Go name from snake, kebab or camel case, "id" is ID like rest of api package
+func goName(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == ' ' || r == '.' || r == '/' })
+	var out strings.Builder
+	for _, part := range parts {
+		if strings.ToLower(part) == "id" {
+			out.WriteString("ID")
+			continue
+		}
+		out.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return out.String()
+}
+
+// Type can be nil without pointer
+func isNilable(goType string) bool {
+	return strings.HasPrefix(goType, "[]") || strings.HasPrefix(goType, "map[") || strings.HasPrefix(goType, "*") ||
+		goType == "net.IP" || goType == "json.RawMessage" || goType == "any"
+}
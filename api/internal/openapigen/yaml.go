@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mapping with keys in file order
+type yamlMap struct {
+	Keys   []string
+	Values map[string]any
+}
+
+func (m *yamlMap) Get(key string) any {
+	if m == nil {
+		return nil
+	}
+	return m.Values[key]
+}
+
+func (m *yamlMap) Map(key string) *yamlMap {
+	value, _ := m.Get(key).(*yamlMap)
+	return value
+}
+
+func (m *yamlMap) List(key string) []any {
+	value, _ := m.Get(key).([]any)
+	return value
+}
+
+func (m *yamlMap) String(key string) string {
+	value, _ := m.Get(key).(string)
+	return value
+}
+
+type yamlLine struct {
+	Number int
+	Indent int
+	Text   string
+}
+
+// Parse block style YAML used by OpenAPI generators: mappings, sequences, plain and quoted scalars,
+// empty flow collections, anchors, tags and multi-line scalars are not supported
+func parseYaml(body string) (any, error) {
+	var lines []yamlLine
+	for number, text := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		} else if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", number+1)
+		}
+		lines = append(lines, yamlLine{number + 1, len(text) - len(trimmed), strings.TrimRight(trimmed, " ")})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	parser := &yamlParser{lines: lines}
+	value, err := parser.node(lines[0].Indent)
+	if err != nil {
+		return nil, err
+	} else if parser.pos < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[parser.pos].Number)
+	}
+	return value, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) node(indent int) (any, error) {
+	if line := p.lines[p.pos]; line.Text == "-" || strings.HasPrefix(line.Text, "- ") {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	items := []any{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.Indent != indent || !(line.Text == "-" || strings.HasPrefix(line.Text, "- ")) {
+			break
+		}
+
+		content := strings.TrimLeft(strings.TrimPrefix(line.Text, "-"), " ")
+		if content == "" {
+			p.pos++
+			value, err := p.child(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+			continue
+		} else if _, _, isKey := splitKey(content); !isKey {
+			value, err := scalar(content)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.Number, err)
+			}
+			items = append(items, value)
+			p.pos++
+			continue
+		}
+
+		// Item is mapping, first key is in same line of "-"
+		itemIndent := line.Indent + len(line.Text) - len(content)
+		p.lines[p.pos] = yamlLine{line.Number, itemIndent, content}
+		value, err := p.mapping(itemIndent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (*yamlMap, error) {
+	values := &yamlMap{Values: map[string]any{}}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.Indent < indent {
+			break
+		} else if line.Indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.Number)
+		}
+
+		key, rest, isKey := splitKey(line.Text)
+		if !isKey {
+			return nil, fmt.Errorf("line %d: expected mapping key", line.Number)
+		}
+		key, err := scalarKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.Number, err)
+		} else if _, exists := values.Values[key]; exists {
+			return nil, fmt.Errorf("line %d: duplicated key %q", line.Number, key)
+		}
+		p.pos++
+
+		var value any
+		if rest != "" {
+			if value, err = scalar(rest); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.Number, err)
+			}
+		} else if value, err = p.child(indent, true); err != nil {
+			return nil, err
+		}
+		values.Keys = append(values.Keys, key)
+		values.Values[key] = value
+	}
+	return values, nil
+}
+
+// Nested value after "key:" or "-", sequences can be in same indent of key
+func (p *yamlParser) child(indent int, sameIndentSeq bool) (any, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	isSeq := next.Text == "-" || strings.HasPrefix(next.Text, "- ")
+	if next.Indent > indent || (sameIndentSeq && next.Indent == indent && isSeq) {
+		return p.node(next.Indent)
+	}
+	return nil, nil
+}
+
+// Split "key: value", colon inside quotes is not separator
+func splitKey(text string) (string, string, bool) {
+	var quote byte
+	for index := 0; index < len(text); index++ {
+		switch char := text[index]; {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			if index == 0 {
+				quote = char
+			}
+		case char == ':' && (index+1 == len(text) || text[index+1] == ' '):
+			return text[:index], strings.TrimLeft(text[index+1:], " "), true
+		}
+	}
+	return "", "", false
+}
+
+func scalarKey(text string) (string, error) {
+	value, err := scalar(text)
+	if err != nil {
+		return "", err
+	} else if key, isString := value.(string); isString {
+		return key, nil
+	}
+	return "", fmt.Errorf("invalid key %q", text)
+}
+
+func scalar(text string) (any, error) {
+	switch {
+	case text == "[]":
+		return []any{}, nil
+	case text == "{}":
+		return &yamlMap{Values: map[string]any{}}, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("unterminated string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case strings.HasPrefix(text, "\""):
+		if len(text) < 2 || !strings.HasSuffix(text, "\"") {
+			return nil, fmt.Errorf("unterminated string %s", text)
+		}
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(text[1 : len(text)-1]), nil
+	case strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{"):
+		return nil, fmt.Errorf("flow collections are not supported: %s", text)
+	case strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">"):
+		return nil, fmt.Errorf("block scalars are not supported: %s", text)
+	}
+	if index := strings.Index(text, " #"); index != -1 {
+		text = strings.TrimRight(text[:index], " ")
+	}
+	return text, nil
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	AccountStatusGuest            string = "guest"              // Guest account, created by GuestLogin
+	AccountStatusEmailNotVerified string = "email-not-verified" // Account without verified email
+	AccountStatusVerified         string = "verified"           // Verified account
+)
+
+type TotpStatus struct {
+	Status string `json:"status"`    // "signed"
+	Data   any    `json:"epoch_sec"` // uint64 epoch of signed status
+}
+
+func (Totp TotpStatus) MarshalJSON() ([]byte, error) {
+	if _, signed := Totp.Data.(uint64); Totp.Status == "" && signed {
+		Totp.Status = "signed"
+	}
+	return marshalUnion("status", Totp.Status, "epoch_sec", Totp.Data)
+}
+
+func (Totp *TotpStatus) UnmarshalJSON(body []byte) (err error) {
+	Totp.Status, Totp.Data, err = unmarshalUnion(body, "status", "epoch_sec", map[string]unionVariant{
+		"signed": variant[uint64],
+	})
+	return
+}
+
+type WebAuth struct {
+	UpdateVersion uint64     `json:"update_version"`
+	AccountID     uint64     `json:"account_id"`
+	Timestamp     uint64     `json:"timestamp"`
+	AccountStatus string     `json:"account_status"` // guest, email-not-verified or verified
+	TotpStatus    TotpStatus `json:"totp_status"`
+	AdminID       *uint64    `json:"admin_id,omitempty"`
+}
+
+type WebSession struct {
+	SessionKey string  `json:"session_key"`
+	Auth       WebAuth `json:"auth"`
+}
+
+// Create guest account to agent, require agent Secret
+func (w *Client) GuestLogin(ctx context.Context) (*WebSession, error) {
+	if w.Secret == "" {
+		return nil, fmt.Errorf("agent secret required")
+	}
+
+	var session WebSession
+	if _, err := w.requestToApi(ctx, "/login/guest", nil, &session, nil); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Copy of client authenticated with session key, requests use ApiKey scheme
+func (w *Client) WithSession(session *WebSession) *Client {
+	client := *w
+	client.Secret = ""
+	client.ApiKey = session.SessionKey
+	return &client
+}
@@ -0,0 +1,20 @@
+// Package openapi has types, enum values and union variants generated from openapi.yaml,
+// run go generate after update spec and review diff.
+//
+// Package api alias schemas without behavior, enums and unions are declared in api so fields of
+// enums are strings and fields of unions are raw JSON here, types with api enums or unions are tested against this package
+package openapi
+
+//go:generate go run ../internal/openapigen -spec ../../openapi.yaml -out openapi_gen.go -package openapi
+
+// Variant of tagged union
+type Variant struct {
+	Content string // Property with variant data, empty if variant only have tag
+	Data    any    // Zero value of variant data, nil if variant only have tag
+}
+
+// Tagged union of oneOf schema, key of Variants is tag value
+type Union struct {
+	Tag      string
+	Variants map[string]Variant
+}
@@ -0,0 +1,332 @@
+// Code generated by openapigen from openapi.yaml; DO NOT EDIT.
+
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"net"
+	"net/netip"
+	"time"
+)
+
+type AccountTunnel struct {
+	ID             uuid.UUID           `json:"id"`
+	TunnelType     *string             `json:"tunnel_type,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	Name           *string             `json:"name,omitempty"`
+	PortType       string              `json:"port_type"`
+	PortCount      uint16              `json:"port_count"`
+	Alloc          json.RawMessage     `json:"alloc"`
+	Origin         json.RawMessage     `json:"origin"`
+	Domain         *TunnelDomain       `json:"domain,omitempty"`
+	FirewallID     *uuid.UUID          `json:"firewall_id,omitempty"`
+	Ratelimit      Ratelimit           `json:"ratelimit"`
+	Active         bool                `json:"active"`
+	DisabledReason *string             `json:"disabled_reason,omitempty"`
+	Region         *string             `json:"region,omitempty"`
+	ExpireNotice   *TunnelExpireNotice `json:"expire_notice,omitempty"`
+}
+
+type AccountTunnels struct {
+	TcpAlloc AllocatedPorts  `json:"tcp_alloc"`
+	UdpAlloc AllocatedPorts  `json:"udp_alloc"`
+	Tunnels  []AccountTunnel `json:"tunnels"`
+}
+
+type AgentAccepted struct {
+	AgentID uuid.UUID `json:"agent_id"`
+}
+
+type AgentClaimDetails struct {
+	Name      string `json:"name"`
+	RemoteIp  string `json:"remote_ip"`
+	AgentType string `json:"agent_type"`
+	Version   string `json:"version"`
+}
+
+type AgentPendingTunnel struct {
+	ID         uuid.UUID `json:"id"`
+	Name       *string   `json:"name,omitempty"`
+	Proto      string    `json:"proto"`
+	PortCount  uint16    `json:"port_count"`
+	TunnelType *string   `json:"tunnel_type,omitempty"`
+	IsDisabled bool      `json:"is_disabled"`
+}
+
+type AgentRouting struct {
+	AgentID  uuid.UUID    `json:"agent_id"`
+	Targets4 []netip.Addr `json:"targets4"`
+	Targets6 []netip.Addr `json:"targets6"`
+}
+
+type AgentRunData struct {
+	AgentID       uuid.UUID            `json:"agent_id"`
+	AgentType     string               `json:"agent_type"`
+	AccountStatus string               `json:"account_status"`
+	Tunnels       []AgentTunnel        `json:"tunnels"`
+	Pending       []AgentPendingTunnel `json:"pending"`
+}
+
+type AgentSecretKey struct {
+	SecretKey string `json:"secret_key"`
+}
+
+type AgentTunnel struct {
+	ID             uuid.UUID `json:"id"`
+	Name           *string   `json:"name,omitempty"`
+	IpNum          uint16    `json:"ip_num"`
+	RegionNum      uint16    `json:"region_num"`
+	Port           PortRange `json:"port"`
+	Proto          string    `json:"proto"`
+	LocalIp        net.IP    `json:"local_ip"`
+	LocalPort      uint16    `json:"local_port"`
+	TunnelType     *string   `json:"tunnel_type,omitempty"`
+	AssignedDomain string    `json:"assigned_domain"`
+	CustomDomain   *string   `json:"custom_domain,omitempty"`
+	Disabled       *string   `json:"disabled,omitempty"`
+}
+
+type AgentVersion struct {
+	Platform string `json:"platform"`
+	Version  string `json:"version"`
+}
+
+type AllocatedPorts struct {
+	Allowed uint16 `json:"allowed"`
+	Claimed uint16 `json:"claimed"`
+	Desired uint16 `json:"desired"`
+}
+
+type AssignedAgent struct {
+	AgentID   uuid.UUID `json:"agent_id"`
+	AgentName string    `json:"agent_name"`
+	LocalIp   net.IP    `json:"local_ip"`
+	LocalPort *uint16   `json:"local_port,omitempty"`
+}
+
+type AssignedAgentCreate struct {
+	AgentID   uuid.UUID `json:"agent_id"`
+	LocalIp   net.IP    `json:"local_ip"`
+	LocalPort *uint16   `json:"local_port,omitempty"`
+}
+
+type AssignedDefault struct {
+	LocalIp   net.IP  `json:"local_ip"`
+	LocalPort *uint16 `json:"local_port,omitempty"`
+}
+
+type AssignedDefaultCreate struct {
+	LocalIp   net.IP  `json:"local_ip"`
+	LocalPort *uint16 `json:"local_port,omitempty"`
+}
+
+type AssignedManaged struct {
+	AgentID   uuid.UUID `json:"agent_id"`
+	AgentName string    `json:"agent_name"`
+}
+
+type AssignedManagedCreate struct {
+	AgentID *uuid.UUID `json:"agent_id,omitempty"`
+}
+
+type ObjectId struct {
+	ID uuid.UUID `json:"id"`
+}
+
+type PlayitAgentVersion struct {
+	Version        AgentVersion `json:"version"`
+	Official       bool         `json:"official"`
+	DetailsWebsite *string      `json:"details_website,omitempty"`
+}
+
+type PortRange struct {
+	From uint16 `json:"from"`
+	To   uint16 `json:"to"`
+}
+
+type Ratelimit struct {
+	BytesPerSecond   *uint64 `json:"bytes_per_second,omitempty"`
+	PacketsPerSecond *uint64 `json:"packets_per_second,omitempty"`
+}
+
+type ReqAgentsRoutingGet struct {
+	AgentID *uuid.UUID `json:"agent_id,omitempty"`
+}
+
+type ReqAgentsRundata struct {
+}
+
+type ReqClaimAccept struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	AgentType string `json:"agent_type"`
+}
+
+type ReqClaimDetails struct {
+	Code string `json:"code"`
+}
+
+type ReqClaimExchange struct {
+	Code string `json:"code"`
+}
+
+type ReqClaimReject struct {
+	Code string `json:"code"`
+}
+
+type ReqClaimSetup struct {
+	Code      string `json:"code"`
+	AgentType string `json:"agent_type"`
+	Version   string `json:"version"`
+}
+
+type ReqLoginGuest struct {
+}
+
+type ReqProtoRegister struct {
+	AgentVersion PlayitAgentVersion `json:"agent_version"`
+	ClientAddr   netip.AddrPort     `json:"client_addr"`
+	TunnelAddr   netip.AddrPort     `json:"tunnel_addr"`
+}
+
+type ReqTunnelsCreate struct {
+	Name       *string         `json:"name,omitempty"`
+	TunnelType *string         `json:"tunnel_type,omitempty"`
+	PortType   string          `json:"port_type"`
+	PortCount  uint16          `json:"port_count"`
+	Origin     json.RawMessage `json:"origin"`
+	Enabled    bool            `json:"enabled"`
+	Alloc      json.RawMessage `json:"alloc,omitempty"`
+	FirewallID *uuid.UUID      `json:"firewall_id,omitempty"`
+}
+
+type ReqTunnelsDelete struct {
+	TunnelID uuid.UUID `json:"tunnel_id"`
+}
+
+type ReqTunnelsList struct {
+	TunnelID *uuid.UUID `json:"tunnel_id,omitempty"`
+	AgentID  *uuid.UUID `json:"agent_id,omitempty"`
+}
+
+type SignedAgentKey struct {
+	Key string `json:"key"`
+}
+
+type SignedEpoch struct {
+	EpochSec uint64 `json:"epoch_sec"`
+}
+
+type SubscriptionId struct {
+	SubID string `json:"sub_id"`
+}
+
+type TunnelAllocated struct {
+	ID             uuid.UUID       `json:"id"`
+	IpHostname     string          `json:"ip_hostname"`
+	StaticIp4      net.IP          `json:"static_ip4,omitempty"`
+	AssignedDomain string          `json:"assigned_domain"`
+	AssignedSrv    *string         `json:"assigned_srv,omitempty"`
+	TunnelIp       net.IP          `json:"tunnel_ip"`
+	PortStart      uint16          `json:"port_start"`
+	PortEnd        uint16          `json:"port_end"`
+	Assignment     json.RawMessage `json:"assignment"`
+	IpType         string          `json:"ip_type"`
+	Region         string          `json:"region"`
+}
+
+type TunnelDedicatedIp struct {
+	SubID  string `json:"sub_id"`
+	Region string `json:"region"`
+}
+
+type TunnelDisabled struct {
+	Reason string `json:"reason"`
+}
+
+type TunnelDomain struct {
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	IsExternal bool      `json:"is_external"`
+	Parent     *string   `json:"parent,omitempty"`
+	Source     string    `json:"source"`
+}
+
+type TunnelExpireNotice struct {
+	DisableAt time.Time `json:"disable_at"`
+	RemoveAt  time.Time `json:"remove_at"`
+}
+
+type UseAllocDedicatedIp struct {
+	IpHostname string  `json:"ip_hostname"`
+	Port       *uint16 `json:"port,omitempty"`
+}
+
+type UseAllocPortAlloc struct {
+	AllocID uuid.UUID `json:"alloc_id"`
+}
+
+type UseRegion struct {
+	Region string `json:"region"`
+}
+
+type WebAuth struct {
+	UpdateVersion uint64          `json:"update_version"`
+	AccountID     uint64          `json:"account_id"`
+	Timestamp     uint64          `json:"timestamp"`
+	AccountStatus string          `json:"account_status"`
+	TotpStatus    json.RawMessage `json:"totp_status"`
+	AdminID       *uint64         `json:"admin_id,omitempty"`
+}
+
+type WebSession struct {
+	SessionKey string  `json:"session_key"`
+	Auth       WebAuth `json:"auth"`
+}
+
+// Variants of oneOf schemas, union types are declared in api package
+var Unions = map[string]Union{
+	"AccountTunnelAllocation": {Tag: "status", Variants: map[string]Variant{
+		"disabled":  {Content: "data", Data: TunnelDisabled{}},
+		"allocated": {Content: "data", Data: TunnelAllocated{}},
+	}},
+	"TotpStatus": {Tag: "status", Variants: map[string]Variant{
+		"signed": {Content: "epoch_sec", Data: *new(uint64)},
+	}},
+	"TunnelAssignment": {Tag: "type", Variants: map[string]Variant{
+		"dedicated-ip":   {Content: "subscription", Data: TunnelDedicatedIp{}},
+		"dedicated-port": {Content: "subscription", Data: SubscriptionId{}},
+	}},
+	"TunnelCreateUseAllocation": {Tag: "type", Variants: map[string]Variant{
+		"dedicated-ip":    {Content: "details", Data: UseAllocDedicatedIp{}},
+		"port-allocation": {Content: "details", Data: UseAllocPortAlloc{}},
+		"region":          {Content: "details", Data: UseRegion{}},
+	}},
+	"TunnelOrigin": {Tag: "type", Variants: map[string]Variant{
+		"default": {Content: "data", Data: AssignedDefault{}},
+		"agent":   {Content: "data", Data: AssignedAgent{}},
+		"managed": {Content: "data", Data: AssignedManaged{}},
+	}},
+	"TunnelOriginCreate": {Tag: "type", Variants: map[string]Variant{
+		"default": {Content: "data", Data: AssignedDefaultCreate{}},
+		"agent":   {Content: "data", Data: AssignedAgentCreate{}},
+		"managed": {Content: "data", Data: AssignedManagedCreate{}},
+	}},
+}
+
+// Values of enum schemas in spec order, enum types are declared in api package
+var Enums = map[string][]string{
+	"AccountStatus":        {"guest", "email-not-verified", "verified"},
+	"AgentAccountStatus":   {"account-delete-scheduled", "banned", "has-message", "email-not-verified", "guest", "ready", "agent-over-limit", "agent-disabled"},
+	"AgentTunnelDisabled":  {"ByUser", "BySystem"},
+	"AgentType":            {"default", "assignable", "self-managed"},
+	"AllocationRegion":     {"smart-global", "global", "north-america", "europe", "asia", "india", "south-america"},
+	"ClaimSetupResponse":   {"WaitingForUserVisit", "WaitingForUser", "UserAccepted", "UserRejected"},
+	"IpType":               {"both", "ip4", "ip6"},
+	"Platform":             {"linux", "freebsd", "windows", "macos", "android", "ios", "minecraft-plugin", "unknown"},
+	"PortType":             {"tcp", "udp", "both"},
+	"TunnelDisabledReason": {"requires-premium", "over-port-limit", "ip-used-in-gre"},
+	"TunnelDomainSource":   {"from-ip", "from-tunnel", "from-agent-ip"},
+	"TunnelType":           {"minecraft-java", "minecraft-bedrock", "valheim", "terraria", "starbound", "rust", "7days", "unturned"},
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Desired tunnel, tunnels are identified by Name
+type TunnelSpec struct {
+	Name       string     `json:"name"`
+	TunnelType TunnelType `json:"tunnel_type,omitempty"` // Tunnel type from TunnelTypes, empty to custom tunnel
+	PortType   PortType   `json:"port_type"`             // tcp, udp or both
+	PortCount  uint16     `json:"port_count"`
+	Region     Region     `json:"region,omitempty"`   // Region allocation, ignored if AllocID is set
+	AllocID    *uuid.UUID `json:"alloc_id,omitempty"` // Use port allocation
+	AgentID    *uuid.UUID `json:"agent_id,omitempty"` // Agent to assign tunnel, nil use default agent
+	LocalIp    net.IP     `json:"local_ip"`
+	LocalPort  *uint16    `json:"local_port,omitempty"`
+}
+
+func (spec *TunnelSpec) Check() error {
+	if spec.Name == "" {
+		return fmt.Errorf("tunnel spec without name")
+	} else if !spec.PortType.Valid() {
+		return fmt.Errorf("tunnel %q: invalid port type %q", spec.Name, spec.PortType)
+	} else if len(spec.TunnelType) > 0 && !spec.TunnelType.Valid() {
+		return fmt.Errorf("tunnel %q: invalid tunnel type %q", spec.Name, spec.TunnelType)
+	} else if len(spec.Region) > 0 && !spec.Region.Valid() {
+		return fmt.Errorf("tunnel %q: invalid region %q", spec.Name, spec.Region)
+	} else if spec.PortCount == 0 {
+		return fmt.Errorf("tunnel %q: port count must be bigger than 0", spec.Name)
+	}
+	return nil
+}
+
+// Request body to create tunnel
+func (spec *TunnelSpec) Tunnel() Tunnel {
+	tun := Tunnel{
+		Name:       spec.Name,
+		TunnelType: spec.TunnelType,
+		PortType:   spec.PortType,
+		PortCount:  spec.PortCount,
+		Enabled:    true,
+		Origin: TunnelOriginCreate{
+			Type:  "default",
+			Agent: AssignedDefaultCreate{LocalIp: spec.LocalIp, LocalPort: spec.LocalPort},
+		},
+	}
+	if spec.AgentID != nil {
+		tun.Origin = TunnelOriginCreate{
+			Type:  "agent",
+			Agent: AssignedAgentCreate{AgentID: *spec.AgentID, LocalIp: spec.LocalIp, LocalPort: spec.LocalPort},
+		}
+	}
+	if spec.AllocID != nil {
+		tun.Alloc = &TunnelCreateUseAllocation{Data: UseAllocPortAlloc{AllocID: *spec.AllocID}}
+	} else if spec.Region != "" {
+		tun.Alloc = &TunnelCreateUseAllocation{Data: UseRegion{Region: spec.Region}}
+	}
+	return tun
+}
+
+// Get local ip and port from tunnel origin
+func originLocal(origin TunnelOrigin) (net.IP, *uint16) {
+	switch data := origin.Agent.(type) {
+	case AssignedDefault:
+		return data.LocalIp, data.LocalPort
+	case AssignedAgent:
+		return data.LocalIp, data.LocalPort
+	}
+	return nil, nil
+}
+
+// Get origin type and assigned agent id, default origin not have agent id
+func originAgent(origin TunnelOrigin) (string, *uuid.UUID) {
+	switch data := origin.Agent.(type) {
+	case AssignedAgent:
+		return origin.Type, &data.AgentID
+	case AssignedManaged:
+		return origin.Type, &data.AgentID
+	}
+	return origin.Type, nil
+}
+
+// Allocation id of allocated tunnel, nil while pending or disabled
+func allocID(alloc AccountTunnelAllocation) *uuid.UUID {
+	if data, isAllocated := alloc.Data.(TunnelAllocated); isAllocated {
+		return &data.ID
+	}
+	return nil
+}
+
+// Reasons to recreate tunnel, empty if current tunnel is same of spec
+func (spec *TunnelSpec) diff(current AccountTunnel) []string {
+	var changes []string
+	if spec.TunnelType != current.TunnelType {
+		changes = append(changes, fmt.Sprintf("tunnel type %q -> %q", current.TunnelType, spec.TunnelType))
+	}
+	if spec.PortType != current.PortType {
+		changes = append(changes, fmt.Sprintf("port type %q -> %q", current.PortType, spec.PortType))
+	}
+	if spec.PortCount != current.PortCount {
+		changes = append(changes, fmt.Sprintf("port count %d -> %d", current.PortCount, spec.PortCount))
+	}
+	if spec.AllocID == nil && spec.Region != "" && spec.Region != current.Region {
+		changes = append(changes, fmt.Sprintf("region %q -> %q", current.Region, spec.Region))
+	}
+
+	originType, agentID := originAgent(current.Origin)
+	if spec.AgentID == nil && originType != "default" {
+		changes = append(changes, fmt.Sprintf("origin %s -> default", originType))
+	} else if spec.AgentID != nil && originType != "agent" {
+		changes = append(changes, fmt.Sprintf("origin %s -> agent", originType))
+	} else if spec.AgentID != nil && (agentID == nil || *agentID != *spec.AgentID) {
+		changes = append(changes, fmt.Sprintf("agent %s -> %s", agentID, spec.AgentID))
+	}
+	if id := allocID(current.Alloc); spec.AllocID != nil && id != nil && *id != *spec.AllocID {
+		changes = append(changes, fmt.Sprintf("alloc %s -> %s", id, spec.AllocID))
+	}
+
+	ip, port := originLocal(current.Origin)
+	if spec.LocalIp != nil && !spec.LocalIp.Equal(ip) {
+		changes = append(changes, fmt.Sprintf("local ip %s -> %s", ip, spec.LocalIp))
+	}
+	if spec.LocalPort != nil && (port == nil || *port != *spec.LocalPort) {
+		changes = append(changes, fmt.Sprintf("local port -> %d", *spec.LocalPort))
+	}
+	return changes
+}
+
+type ReconcileAction string
+
+const (
+	ReconcileKeep     ReconcileAction = "keep"
+	ReconcileCreate   ReconcileAction = "create"
+	ReconcileDelete   ReconcileAction = "delete"
+	ReconcileRecreate ReconcileAction = "recreate"
+)
+
+type ReconcileStep struct {
+	Action  ReconcileAction
+	Spec    *TunnelSpec    // Desired tunnel, nil on delete
+	Current *AccountTunnel // Tunnel in account, nil on create
+	Changes []string       // Why recreate
+}
+
+func (step ReconcileStep) String() string {
+	switch step.Action {
+	case ReconcileCreate:
+		return fmt.Sprintf("+ create %q (%s, %d ports)", step.Spec.Name, step.Spec.PortType, step.Spec.PortCount)
+	case ReconcileDelete:
+		return fmt.Sprintf("- delete %q (%s)", step.Current.Name, step.Current.ID.String())
+	case ReconcileRecreate:
+		return fmt.Sprintf("~ recreate %q (%s): %s", step.Spec.Name, step.Current.ID.String(), strings.Join(step.Changes, ", "))
+	}
+	return fmt.Sprintf("  keep %q (%s)", step.Spec.Name, step.Current.ID.String())
+}
+
+// Steps to make account tunnels match specs
+type ReconcilePlan struct {
+	Steps []ReconcileStep
+}
+
+// Steps that change account
+func (plan *ReconcilePlan) Changes() int {
+	count := 0
+	for _, step := range plan.Steps {
+		if step.Action != ReconcileKeep {
+			count++
+		}
+	}
+	return count
+}
+
+// Dry-run plan, one line per step
+func (plan *ReconcilePlan) String() string {
+	var lines []string
+	for _, step := range plan.Steps {
+		lines = append(lines, step.String())
+	}
+	lines = append(lines, fmt.Sprintf("%d to change, %d unchanged", plan.Changes(), len(plan.Steps)-plan.Changes()))
+	return strings.Join(lines, "\n")
+}
+
+// Compare specs with tunnels from ListTunnels, if AgentID is set only tunnels of agent are listed.
+//
+// Tunnels not in specs are deleted only with prune
+func (w *Client) PlanTunnels(ctx context.Context, specs []TunnelSpec, AgentID *uuid.UUID, prune bool) (*ReconcilePlan, error) {
+	names := map[string]bool{}
+	for index := range specs {
+		if err := specs[index].Check(); err != nil {
+			return nil, err
+		} else if names[specs[index].Name] {
+			return nil, fmt.Errorf("tunnel %q is duplicated in spec", specs[index].Name)
+		}
+		names[specs[index].Name] = true
+	}
+
+	tunnels, err := w.ListTunnels(ctx, nil, AgentID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ReconcilePlan{}
+	current := map[string]*AccountTunnel{}
+	for index := range tunnels.Tunnels {
+		tun := &tunnels.Tunnels[index]
+		if _, exists := current[tun.Name]; exists || !names[tun.Name] {
+			if prune {
+				plan.Steps = append(plan.Steps, ReconcileStep{Action: ReconcileDelete, Current: tun})
+			}
+			continue
+		}
+		current[tun.Name] = tun
+	}
+
+	for index := range specs {
+		spec := &specs[index]
+		tun, exists := current[spec.Name]
+		if !exists {
+			plan.Steps = append(plan.Steps, ReconcileStep{Action: ReconcileCreate, Spec: spec})
+		} else if changes := spec.diff(*tun); len(changes) > 0 {
+			plan.Steps = append(plan.Steps, ReconcileStep{Action: ReconcileRecreate, Spec: spec, Current: tun, Changes: changes})
+		} else {
+			plan.Steps = append(plan.Steps, ReconcileStep{Action: ReconcileKeep, Spec: spec, Current: tun})
+		}
+	}
+	return plan, nil
+}
+
+// ApplyPlan stopped in Step, account have changes of Applied steps
+type ApplyError struct {
+	Step    ReconcileStep   // Failed step
+	Deleted bool            // Recreate step deleted current tunnel before create fail
+	Applied []ReconcileStep // Steps completed before error
+	Err     error
+}
+
+func (err *ApplyError) Error() string {
+	var name string
+	if err.Step.Spec != nil {
+		name = err.Step.Spec.Name
+	} else {
+		name = err.Step.Current.Name
+	}
+	state := fmt.Sprintf("%d steps applied", len(err.Applied))
+	if err.Deleted {
+		state += ", tunnel deleted"
+	}
+	return fmt.Sprintf("%s %q: %s (%s)", err.Step.Action, name, err.Err.Error(), state)
+}
+
+func (err *ApplyError) Unwrap() error { return err.Err }
+
+// Run plan steps, creates run first so failed create not delete any tunnel,
+// recreates delete and create tunnel to release ports and deletes run last.
+// On error return *ApplyError with steps already applied
+func (w *Client) ApplyPlan(ctx context.Context, plan *ReconcilePlan) error {
+	var applied []ReconcileStep
+	for _, action := range []ReconcileAction{ReconcileCreate, ReconcileRecreate, ReconcileDelete} {
+		for _, step := range plan.Steps {
+			if step.Action != action {
+				continue
+			}
+
+			deleted := false
+			if action == ReconcileDelete || action == ReconcileRecreate {
+				if err := w.DeleteTunnel(ctx, &step.Current.ID); err != nil {
+					return &ApplyError{Step: step, Applied: applied, Err: err}
+				}
+				deleted = true
+			}
+			if action == ReconcileCreate || action == ReconcileRecreate {
+				if err := w.CreateTunnel(ctx, step.Spec.Tunnel()); err != nil {
+					return &ApplyError{Step: step, Deleted: deleted, Applied: applied, Err: err}
+				}
+			}
+			applied = append(applied, step)
+		}
+	}
+	return nil
+}
+
+// Plan and apply specs
+func (w *Client) ReconcileTunnels(ctx context.Context, specs []TunnelSpec, AgentID *uuid.UUID, prune bool) (*ReconcilePlan, error) {
+	plan, err := w.PlanTunnels(ctx, specs, AgentID, prune)
+	if err != nil {
+		return nil, err
+	}
+	return plan, w.ApplyPlan(ctx, plan)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTunnelSpecDiff(t *testing.T) {
+	agentID, otherAgent := uuid.New(), uuid.New()
+	allocID, otherAlloc := uuid.New(), uuid.New()
+	port := uint16(25565)
+	spec := TunnelSpec{Name: "mc", TunnelType: TunnelTypeMCJava, PortType: PortTypeTcp, PortCount: 1, LocalIp: net.IPv4(127, 0, 0, 1), LocalPort: &port}
+	current := AccountTunnel{
+		Name:       "mc",
+		TunnelType: TunnelTypeMCJava,
+		PortType:   PortTypeTcp,
+		PortCount:  1,
+		Origin:     TunnelOrigin{Type: "default", Agent: AssignedDefault{LocalIp: net.IPv4(127, 0, 0, 1), LocalPort: &port}},
+		Alloc:      AccountTunnelAllocation{Status: AllocationAllocated, Data: TunnelAllocated{ID: allocID}},
+	}
+	withAgent := func(id uuid.UUID) AccountTunnel {
+		tun := current
+		tun.Origin = TunnelOrigin{Type: "agent", Agent: AssignedAgent{AgentID: id, AgentName: "test", LocalIp: net.IPv4(127, 0, 0, 1), LocalPort: &port}}
+		return tun
+	}
+
+	for _, test := range []struct {
+		name    string
+		spec    func(*TunnelSpec)
+		current AccountTunnel
+		change  string
+	}{
+		{"same", func(*TunnelSpec) {}, current, ""},
+		{"same agent", func(spec *TunnelSpec) { spec.AgentID = &agentID }, withAgent(agentID), ""},
+		{"same alloc", func(spec *TunnelSpec) { spec.AllocID = &allocID }, current, ""},
+		{"default to agent", func(spec *TunnelSpec) { spec.AgentID = &agentID }, current, "origin default -> agent"},
+		{"agent to default", func(*TunnelSpec) {}, withAgent(agentID), "origin agent -> default"},
+		{"other agent", func(spec *TunnelSpec) { spec.AgentID = &otherAgent }, withAgent(agentID), "agent " + agentID.String() + " -> " + otherAgent.String()},
+		{"other alloc", func(spec *TunnelSpec) { spec.AllocID = &otherAlloc }, current, "alloc " + allocID.String() + " -> " + otherAlloc.String()},
+		{"port count", func(spec *TunnelSpec) { spec.PortCount = 2 }, current, "port count 1 -> 2"},
+		{"local port", func(spec *TunnelSpec) { spec.LocalPort = new(uint16) }, current, "local port -> 0"},
+	} {
+		spec := spec
+		test.spec(&spec)
+		changes := strings.Join(spec.diff(test.current), ", ")
+		if changes != test.change {
+			t.Errorf("%s: changes %q, want %q", test.name, changes, test.change)
+		}
+	}
+
+	// Pending allocation not have alloc id to compare
+	pending := current
+	pending.Alloc = AccountTunnelAllocation{Status: AllocationPending}
+	spec.AllocID = &otherAlloc
+	if changes := spec.diff(pending); len(changes) > 0 {
+		t.Errorf("pending allocation changes %q", changes)
+	}
+}
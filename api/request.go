@@ -1,10 +1,16 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func recodeJson(from, to any) error {
@@ -17,51 +23,127 @@ func recodeJson(from, to any) error {
 	return nil
 }
 
-func (w *Api) requestToApi(Path string, Body io.Reader, Response any, Headers map[string]string) (*http.Response, error) {
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s%s", PlayitAPI, Path), Body)
+// Endpoints without side effects, safe to send again after any failure
+var idempotentPaths = map[string]bool{
+	"/agents/routing/get": true,
+	"/agents/rundata":     true,
+	"/claim/details":      true,
+	"/claim/exchange":     true,
+	"/claim/setup":        true,
+	"/proto/register":     true,
+	"/tunnels/list":       true,
+}
+
+// Request failed before reach server, like dns and dial errors
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Check if request can be sent again, endpoints with side effects like /tunnels/create
+// only retry if request not reach server or server reject with 429 or 503
+func retryable(Path string, res *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+		return idempotentPaths[Path] || notSent(err)
+	} else if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+		return true
+	}
+	return idempotentPaths[Path] && res.StatusCode >= 500
+}
+
+// Delay to next retry, use Retry-After if server send it
+func retryDelay(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, MaxRetryDelay)
+		}
+	}
+	return min(DefaultRetryDelay<<attempt, MaxRetryDelay)
+}
+
+// Send request and retry failures accepted by retryable with exponential backoff,
+// timeout of one attempt is retried only while ctx is not done
+func (w *Client) do(ctx context.Context, Path string, Body []byte, Headers map[string]string) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		res, body, err := w.doOnce(ctx, Path, Body, Headers)
+		if attempt >= w.maxRetries() || ctx.Err() != nil || !retryable(Path, res, err) {
+			return res, body, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return res, body, ctx.Err()
+		case <-time.After(retryDelay(attempt, res)):
+		}
+	}
+}
+
+func (w *Client) doOnce(ctx context.Context, Path string, Body []byte, Headers map[string]string) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s%s", w.baseURL(), Path), bytes.NewReader(Body))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	req.Header = http.Header{}
 	for key, value := range Headers {
 		req.Header.Set(key, value)
 	}
+	req.Header.Set("User-Agent", w.userAgent())
+	if Body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
-	// Set agent token
-	if len(w.Secret) > 0 {
-		req.Header.Set("Authorization", fmt.Sprintf("Agent-Key %s", w.Secret))
+	// Set agent token or session key
+	if req.Header.Get("Authorization") == "" {
+		if len(w.Secret) > 0 {
+			req.Header.Set("Authorization", fmt.Sprintf("Agent-Key %s", w.Secret))
+		} else if len(w.ApiKey) > 0 {
+			req.Header.Set("Authorization", fmt.Sprintf("Api-Key %s", w.ApiKey))
+		}
 	}
 
-	res, err := (&http.Client{}).Do(req)
+	res, err := w.httpClient().Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return res, body, err
+}
+
+func (w *Client) requestToApi(ctx context.Context, Path string, Body io.Reader, Response any, Headers map[string]string) (*http.Response, error) {
+	var reqBody []byte
+	if Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(Body); err != nil {
+			return nil, err
+		}
+	}
+
+	res, body, err := w.do(ctx, Path, reqBody, Headers)
+	if err != nil {
+		return res, err
+	}
 
 	var ResBody struct {
 		Status string `json:"status"`
 		Data   any    `json:"data"`
 	}
-	if err = json.NewDecoder(res.Body).Decode(&ResBody); err != nil {
+	if err = json.Unmarshal(body, &ResBody); err != nil {
+		if res.StatusCode >= 300 {
+			return res, newError(Path, res, body, "", nil)
+		}
 		return res, err
 	}
 
-	if res.StatusCode >= 300 {
-		var errStatus struct {
-			Type    string `json:"type"`
-			Message string `json:"message"`
-		}
-
-		if data, is := ResBody.Data.(string); is {
-			return res, fmt.Errorf(data)
-		} else if err = recodeJson(&ResBody.Data, &errStatus); err != nil {
-			return res, err
-		}
-		if len(errStatus.Message) > 0 {
-			return res, fmt.Errorf("%s: %s", errStatus.Type, errStatus.Message)
-		}
-		return res, fmt.Errorf("%s", errStatus.Type)
+	if res.StatusCode >= 300 || ResBody.Status == "error" || ResBody.Status == "fail" {
+		return res, newError(Path, res, body, ResBody.Status, ResBody.Data)
 	}
 	if Response != nil {
 		if err = recodeJson(&ResBody.Data, Response); err != nil {
@@ -70,3 +152,17 @@ func (w *Api) requestToApi(Path string, Body io.Reader, Response any, Headers ma
 	}
 	return res, nil
 }
+
+// Send Request encoded as JSON to Path and decode data into Response, Request nil send no body
+func (w *Client) Call(ctx context.Context, Path string, Request, Response any) error {
+	var body io.Reader
+	if Request != nil {
+		reqBody, err := json.Marshal(Request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(reqBody)
+	}
+	_, err := w.requestToApi(ctx, Path, body, Response, nil)
+	return err
+}
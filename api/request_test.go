@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}
+	for _, test := range []struct {
+		path   string
+		status int
+		err    error
+		want   bool
+	}{
+		{"/tunnels/list", 500, nil, true},
+		{"/tunnels/list", 0, readErr, true},
+		{"/tunnels/list", 0, context.DeadlineExceeded, true},
+		{"/tunnels/list", 0, context.Canceled, false},
+		{"/tunnels/list", 400, nil, false},
+		{"/tunnels/create", 500, nil, false},
+		{"/tunnels/create", 502, nil, false},
+		{"/tunnels/create", 0, readErr, false},
+		{"/tunnels/create", 0, context.DeadlineExceeded, false},
+		{"/tunnels/create", 0, dialErr, true},
+		{"/tunnels/create", 429, nil, true},
+		{"/tunnels/create", 503, nil, true},
+		{"/claim/accept", 500, nil, false},
+	} {
+		var res *http.Response
+		if test.err == nil {
+			res = &http.Response{StatusCode: test.status}
+		}
+		if got := retryable(test.path, res, test.err); got != test.want {
+			t.Errorf("retryable(%s, %d, %v) = %v, want %v", test.path, test.status, test.err, got, test.want)
+		}
+	}
+}
+
+func TestRequestRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"status":"error","data":"internal"}`))
+	}))
+	defer server.Close()
+
+	client := Client{BaseURL: server.URL, MaxRetries: 2}
+	for path, want := range map[string]int32{"/tunnels/list": 3, "/tunnels/create": 1} {
+		requests.Store(0)
+		if err := client.Call(context.Background(), path, struct{}{}, nil); err == nil {
+			t.Errorf("%s: request not failed", path)
+		} else if got := requests.Load(); got != want {
+			t.Errorf("%s: %d requests, want %d", path, got, want)
+		}
+	}
+}
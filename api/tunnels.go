@@ -2,33 +2,52 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
-	"slices"
 	"time"
 
 	"github.com/google/uuid"
+	"sirherobrine23.org/playit-cloud/go-playit/api/openapi"
 )
 
-type AssignedDefaultCreate struct {
-	Ip   net.IP  `json:"local_ip"`
-	Port *uint16 `json:"local_port,omitempty"`
-}
+// Schemas without behavior in api are generated from openapi.yaml
+type (
+	AssignedDefaultCreate = openapi.AssignedDefaultCreate
+	AssignedAgentCreate   = openapi.AssignedAgentCreate
+	AssignedManagedCreate = openapi.AssignedManagedCreate
+	AssignedDefault       = openapi.AssignedDefault
+	AssignedAgent         = openapi.AssignedAgent
+	AssignedManaged       = openapi.AssignedManaged
+)
 
-type AssignedAgentCreate struct {
-	ID   uuid.UUID `json:"agent_id"`
-	Ip   net.IP    `json:"local_ip"`
-	Port *uint16   `json:"local_port,omitempty"`
+type TunnelOriginCreate struct {
+	Type  string `json:"type"` // Agent type: default, agent or managed
+	Agent any    `json:"data"` // Assingned agent: AssignedDefaultCreate, AssignedAgentCreate or AssignedManagedCreate
 }
 
-type AssignedManagedCreate struct {
-	ID *uuid.UUID `json:"agent_id,omitempty"`
+func (Origin TunnelOriginCreate) MarshalJSON() ([]byte, error) {
+	if Origin.Type == "" {
+		switch Origin.Agent.(type) {
+		case AssignedDefaultCreate:
+			Origin.Type = "default"
+		case AssignedAgentCreate:
+			Origin.Type = "agent"
+		case AssignedManagedCreate:
+			Origin.Type = "managed"
+		}
+	}
+	return marshalUnion("type", Origin.Type, "data", Origin.Agent)
 }
 
-type TunnelOriginCreate struct {
-	Type  string `json:"type"` // Agent type: default, agent or managed
-	Agent any    `json:"data"` // Assingned agent
+func (Origin *TunnelOriginCreate) UnmarshalJSON(body []byte) (err error) {
+	Origin.Type, Origin.Agent, err = unmarshalUnion(body, "type", "data", map[string]unionVariant{
+		"default": variant[AssignedDefaultCreate],
+		"agent":   variant[AssignedAgentCreate],
+		"managed": variant[AssignedManagedCreate],
+	})
+	return
 }
 
 func (Origin *TunnelOriginCreate) Check() error {
@@ -42,40 +61,69 @@ func (Origin *TunnelOriginCreate) Check() error {
 	return nil
 }
 
-type UseAllocDedicatedIp struct {
-	IpHost string  `json:"ip_hostname"`
-	Port   *uint16 `json:"port,omitempty"`
+// Origin of tunnel in list response
+type TunnelOrigin struct {
+	Type  string `json:"type"` // Agent type: default, agent or managed
+	Agent any    `json:"data"` // Assingned agent: AssignedDefault, AssignedAgent or AssignedManaged
 }
 
-type UseAllocPortAlloc struct {
-	ID uuid.UUID `json:"alloc_id"`
+func (Origin TunnelOrigin) MarshalJSON() ([]byte, error) {
+	if Origin.Type == "" {
+		switch Origin.Agent.(type) {
+		case AssignedDefault:
+			Origin.Type = "default"
+		case AssignedAgent:
+			Origin.Type = "agent"
+		case AssignedManaged:
+			Origin.Type = "managed"
+		}
+	}
+	return marshalUnion("type", Origin.Type, "data", Origin.Agent)
 }
 
-type UseRegion struct {
-	Region string `json:"region"`
+func (Origin *TunnelOrigin) UnmarshalJSON(body []byte) (err error) {
+	Origin.Type, Origin.Agent, err = unmarshalUnion(body, "type", "data", map[string]unionVariant{
+		"default": variant[AssignedDefault],
+		"agent":   variant[AssignedAgent],
+		"managed": variant[AssignedManaged],
+	})
+	return
 }
-/**
-"status": "allocated",
-"data": {
-	"assigned_domain": "going-scales.gl.at.ply.gg",
-	"assigned_srv": null,
-	"assignment": {
-		"type": "shared-ip"
-	},
-	"id": "f667b538-0294-4817-9332-5cba5e94d79e",
-	"ip_hostname": "19.ip.gl.ply.gg",
-	"ip_type": "both",
-	"port_end": 49913,
-	"port_start": 49912,
-	"region": "global",
-	"static_ip4": "147.185.221.19",
-	"tunnel_ip": "2602:fbaf:0:1::13"
+
+type (
+	UseAllocDedicatedIp = openapi.UseAllocDedicatedIp
+	UseAllocPortAlloc   = openapi.UseAllocPortAlloc
+)
+
+type UseRegion struct {
+	Region Region `json:"region"`
 }
-*/
 type TunnelCreateUseAllocation struct {
-	Status string `json:"status"`  // For tunnel list
-	Type   string `json:"type"`    // "dedicated-ip", "port-allocation" or "region"
-	Data   any    `json:"details"` // UseAllocDedicatedIp, UseAllocPortAlloc, UseRegion
+	Type string `json:"type"`    // "dedicated-ip", "port-allocation" or "region"
+	Data any    `json:"details"` // UseAllocDedicatedIp, UseAllocPortAlloc, UseRegion
+}
+
+func (Alloc TunnelCreateUseAllocation) MarshalJSON() ([]byte, error) {
+	if Alloc.Type == "" {
+		switch Alloc.Data.(type) {
+		case UseAllocDedicatedIp:
+			Alloc.Type = "dedicated-ip"
+		case UseAllocPortAlloc:
+			Alloc.Type = "port-allocation"
+		case UseRegion:
+			Alloc.Type = "region"
+		}
+	}
+	return marshalUnion("type", Alloc.Type, "details", Alloc.Data)
+}
+
+func (Alloc *TunnelCreateUseAllocation) UnmarshalJSON(body []byte) (err error) {
+	Alloc.Type, Alloc.Data, err = unmarshalUnion(body, "type", "details", map[string]unionVariant{
+		"dedicated-ip":    variant[UseAllocDedicatedIp],
+		"port-allocation": variant[UseAllocPortAlloc],
+		"region":          variant[UseRegion],
+	})
+	return
 }
 
 func (Alloc *TunnelCreateUseAllocation) Check() error {
@@ -86,7 +134,7 @@ func (Alloc *TunnelCreateUseAllocation) Check() error {
 		Alloc.Type = "port-allocation"
 		return nil
 	} else if Region, isRegion := Alloc.Data.(UseRegion); isRegion {
-		if slices.Contains(Regions, Region.Region) {
+		if Region.Region.Valid() {
 			Alloc.Type = "region"
 			return nil
 		}
@@ -96,10 +144,9 @@ func (Alloc *TunnelCreateUseAllocation) Check() error {
 }
 
 type Tunnel struct {
-	ID         *uuid.UUID                 `json:"tunnel_id,omitempty"`   // Tunnel UUID
 	Name       string                     `json:"name,omitempty"`        // Tunnel name
-	TunnelType string                     `json:"tunnel_type,omitempty"` // Tunnel type from TunnelType const's
-	PortType   string                     `json:"port_type"`             // tcp, udp or both
+	TunnelType TunnelType                 `json:"tunnel_type,omitempty"` // Tunnel type from TunnelType const's
+	PortType   PortType                   `json:"port_type"`             // tcp, udp or both
 	PortCount  uint16                     `json:"port_count"`            // Port count to assign to connect
 	Origin     TunnelOriginCreate         `json:"origin"`
 	Enabled    bool                       `json:"enabled"`
@@ -107,13 +154,18 @@ type Tunnel struct {
 	Firewall   *uuid.UUID                 `json:"firewall_id,omitempty"` // Firewall ID
 }
 
-func (w *Api) CreateTunnel(tun Tunnel) error {
+func (w *Client) CreateTunnel(ctx context.Context, tun Tunnel) error {
 	var err error
-	if err = tun.Alloc.Check(); err != nil {
-		return err
-	} else if err = tun.Origin.Check(); err != nil {
+	if tun.Alloc != nil {
+		if err = tun.Alloc.Check(); err != nil {
+			return err
+		}
+	}
+	if err = tun.Origin.Check(); err != nil {
 		return err
-	} else if len(tun.TunnelType) > 0 && !slices.Contains(TunnelType, tun.TunnelType) {
+	} else if !tun.PortType.Valid() {
+		return fmt.Errorf("invalid port type")
+	} else if len(tun.TunnelType) > 0 && !tun.TunnelType.Valid() {
 		return fmt.Errorf("invalid tunnel type")
 	}
 
@@ -126,23 +178,22 @@ func (w *Api) CreateTunnel(tun Tunnel) error {
 	var tunnelId struct {
 		ID uuid.UUID `json:"id"`
 	}
-	if _, err = w.requestToApi("/tunnels/create", bytes.NewReader(body), &tunnelId, nil); err != nil {
-		return err
-	}
-	tun.ID = &tunnelId.ID
-
-	info, err := w.AgentInfo()
-	if err != nil {
+	if _, err = w.requestToApi(ctx, "/tunnels/create", bytes.NewReader(body), &tunnelId, nil); err != nil {
 		return err
 	}
-
 	for {
-		tuns, err := w.ListTunnels(tun.ID, &info.ID)
+		tuns, err := w.ListTunnels(ctx, &tunnelId.ID, nil)
 		if err != nil {
 			return err
+		} else if len(tuns.Tunnels) == 0 {
+			return fmt.Errorf("tunnel %s not found after create", tunnelId.ID.String())
 		}
-		if tuns.Tunnels[0].Alloc.Status == "pending" {
-			time.Sleep(time.Second * 2)
+		if tuns.Tunnels[0].Alloc.Status == AllocationPending {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second * 2):
+			}
 			continue
 		}
 		break
@@ -151,7 +202,7 @@ func (w *Api) CreateTunnel(tun Tunnel) error {
 	return nil
 }
 
-func (w *Api) DeleteTunnel(TunnelID *uuid.UUID) error {
+func (w *Client) DeleteTunnel(ctx context.Context, TunnelID *uuid.UUID) error {
 	if TunnelID == nil {
 		return nil
 	}
@@ -162,53 +213,152 @@ func (w *Api) DeleteTunnel(TunnelID *uuid.UUID) error {
 		return err
 	}
 
-	_, err = w.requestToApi("/tunnels/delete", bytes.NewReader(body), nil, nil)
+	_, err = w.requestToApi(ctx, "/tunnels/delete", bytes.NewReader(body), nil, nil)
 	return err
 }
 
-type AccountTunnel struct {
+type (
+	SubscriptionId     = openapi.SubscriptionId
+	Ratelimit          = openapi.Ratelimit
+	TunnelExpireNotice = openapi.TunnelExpireNotice
+	AllocatedPorts     = openapi.AllocatedPorts
+)
+
+type TunnelDisabled struct {
+	Reason TunnelDisabledReason `json:"reason"`
+}
+
+type TunnelDomain struct {
 	ID         uuid.UUID          `json:"id"`
-	TunnelType string             `json:"tunnel_type"`
-	CreatedAt  time.Time          `json:"created_at"`
 	Name       string             `json:"name"`
-	PortType   string             `json:"port_type"`
-	PortCount  int32              `json:"port_count"`
-	Alloc      TunnelCreateUseAllocation                `json:"alloc"`
-	Origin     TunnelOriginCreate `json:"origin"`
-	Domain     *struct {
-		ID         uuid.UUID `json:"id"`
-		Name       string    `json:"name"`
-		IsExternal bool      `json:"is_external"`
-		Parent     string    `json:"parent"`
-		Source     string    `json:"source"`
-	} `json:"domain"`
-	FirewallID string `json:"firewall_id"`
-	Ratelimit  struct {
-		BytesSecs   uint64 `json:"bytes_per_second"`
-		PacketsSecs uint64 `json:"packets_per_second"`
-	} `json:"ratelimit"`
-	Active         bool   `json:"active"`
-	DisabledReason string `json:"disabled_reason"`
-	Region         string `json:"region"`
-	ExpireNotice   *struct {
-		Disable time.Time `json:"disable_at"`
-		Remove  time.Time `json:"remove_at"`
-	} `json:"expire_notice"`
-}
-
-type AlloctedPorts struct {
-	Allowed uint16 `json:"allowed"`
-	Claimed uint16 `json:"claimed"`
-	Desired uint16 `json:"desired"`
+	IsExternal bool               `json:"is_external"`
+	Parent     *string            `json:"parent,omitempty"`
+	Source     TunnelDomainSource `json:"source"`
+}
+
+type TunnelDedicatedIp struct {
+	SubID  string `json:"sub_id"`
+	Region Region `json:"region"`
+}
+
+type TunnelAssignment struct {
+	Type         string `json:"type"`         // "dedicated-ip", "dedicated-port" or "shared-ip"
+	Subscription any    `json:"subscription"` // TunnelDedicatedIp or SubscriptionId, nil to shared ip
+}
+
+func (Assign TunnelAssignment) MarshalJSON() ([]byte, error) {
+	if Assign.Type == "" {
+		switch Assign.Subscription.(type) {
+		case TunnelDedicatedIp:
+			Assign.Type = "dedicated-ip"
+		case SubscriptionId:
+			Assign.Type = "dedicated-port"
+		}
+	}
+	return marshalUnion("type", Assign.Type, "subscription", Assign.Subscription)
+}
+
+func (Assign *TunnelAssignment) UnmarshalJSON(body []byte) (err error) {
+	Assign.Type, Assign.Subscription, err = unmarshalUnion(body, "type", "subscription", map[string]unionVariant{
+		"dedicated-ip":   variant[TunnelDedicatedIp],
+		"dedicated-port": variant[SubscriptionId],
+	})
+	return
+}
+
+/**
+"status": "allocated",
+"data": {
+	"assigned_domain": "going-scales.gl.at.ply.gg",
+	"assigned_srv": null,
+	"assignment": {
+		"type": "shared-ip"
+	},
+	"id": "f667b538-0294-4817-9332-5cba5e94d79e",
+	"ip_hostname": "19.ip.gl.ply.gg",
+	"ip_type": "both",
+	"port_end": 49913,
+	"port_start": 49912,
+	"region": "global",
+	"static_ip4": "147.185.221.19",
+	"tunnel_ip": "2602:fbaf:0:1::13"
+}
+*/
+type TunnelAllocated struct {
+	ID             uuid.UUID        `json:"id"`
+	IpHostname     string           `json:"ip_hostname"`
+	StaticIp4      net.IP           `json:"static_ip4,omitempty"`
+	AssignedDomain string           `json:"assigned_domain"`
+	AssignedSrv    *string          `json:"assigned_srv,omitempty"`
+	TunnelIp       net.IP           `json:"tunnel_ip"`
+	PortStart      uint16           `json:"port_start"`
+	PortEnd        uint16           `json:"port_end"`
+	Assignment     TunnelAssignment `json:"assignment"`
+	IpType         string           `json:"ip_type"` // "both", "ip4" or "ip6"
+	Region         Region           `json:"region"`
+}
+
+// Allocation of tunnel in list
+type AccountTunnelAllocation struct {
+	Status AllocationStatus `json:"status"`
+	Data   any              `json:"data"` // TunnelDisabled or TunnelAllocated, nil while pending
+}
+
+func (Alloc AccountTunnelAllocation) MarshalJSON() ([]byte, error) {
+	if Alloc.Status == "" {
+		switch Alloc.Data.(type) {
+		case TunnelDisabled:
+			Alloc.Status = AllocationDisabled
+		case TunnelAllocated:
+			Alloc.Status = AllocationAllocated
+		default:
+			Alloc.Status = AllocationPending
+		}
+	}
+	text, err := marshalEnum("allocation status", Alloc.Status)
+	if err != nil {
+		return nil, err
+	}
+	return marshalUnion("status", string(text), "data", Alloc.Data)
+}
+
+func (Alloc *AccountTunnelAllocation) UnmarshalJSON(body []byte) error {
+	status, data, err := unmarshalUnion(body, "status", "data", map[string]unionVariant{
+		string(AllocationDisabled):  variant[TunnelDisabled],
+		string(AllocationAllocated): variant[TunnelAllocated],
+	})
+	if err != nil {
+		return err
+	}
+	Alloc.Status, Alloc.Data = AllocationStatus(status), data
+	return nil
+}
+
+type AccountTunnel struct {
+	ID             uuid.UUID               `json:"id"`
+	TunnelType     TunnelType              `json:"tunnel_type,omitempty"`
+	CreatedAt      time.Time               `json:"created_at"`
+	Name           string                  `json:"name"`
+	PortType       PortType                `json:"port_type"`
+	PortCount      uint16                  `json:"port_count"`
+	Alloc          AccountTunnelAllocation `json:"alloc"`
+	Origin         TunnelOrigin            `json:"origin"`
+	Domain         *TunnelDomain           `json:"domain"`
+	FirewallID     *uuid.UUID              `json:"firewall_id"`
+	Ratelimit      Ratelimit               `json:"ratelimit"`
+	Active         bool                    `json:"active"`
+	DisabledReason TunnelDisabledReason    `json:"disabled_reason,omitempty"`
+	Region         Region                  `json:"region,omitempty"`
+	ExpireNotice   *TunnelExpireNotice     `json:"expire_notice"`
 }
 
 type AccountTunnels struct {
-	Tcp     AlloctedPorts   `json:"tcp_alloc"`
-	Udp     AlloctedPorts   `json:"udp_alloc"`
+	Tcp     AllocatedPorts  `json:"tcp_alloc"`
+	Udp     AllocatedPorts  `json:"udp_alloc"`
 	Tunnels []AccountTunnel `json:"tunnels"`
 }
 
-func (w *Api) ListTunnels(TunnelID, AgentID *uuid.UUID) (*AccountTunnels, error) {
+func (w *Client) ListTunnels(ctx context.Context, TunnelID, AgentID *uuid.UUID) (*AccountTunnels, error) {
 	type TunList struct {
 		TunnelID *uuid.UUID `json:"tunnel_id,omitempty"`
 		AgentID  *uuid.UUID `json:"agent_id,omitempty"`
@@ -219,7 +369,7 @@ func (w *Api) ListTunnels(TunnelID, AgentID *uuid.UUID) (*AccountTunnels, error)
 	}
 
 	var Tuns AccountTunnels
-	if _, err := w.requestToApi("/tunnels/list", bytes.NewBuffer(body), &Tuns, nil); err != nil {
+	if _, err := w.requestToApi(ctx, "/tunnels/list", bytes.NewBuffer(body), &Tuns, nil); err != nil {
 		return nil, err
 	}
 	return &Tuns, nil
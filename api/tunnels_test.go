@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTunnelOriginJSON(t *testing.T) {
+	agentID := uuid.MustParse("5b0c5e49-5f3e-4c38-9d4b-2b1d2d1a8d6e")
+	port := uint16(25565)
+	for _, test := range []struct {
+		body   string
+		origin TunnelOrigin
+	}{
+		{`{"type":"default","data":{"local_ip":"127.0.0.1","local_port":25565}}`, TunnelOrigin{Type: "default", Agent: AssignedDefault{LocalIp: net.IPv4(127, 0, 0, 1), LocalPort: &port}}},
+		{`{"type":"agent","data":{"agent_id":"` + agentID.String() + `","agent_name":"home","local_ip":"127.0.0.1"}}`, TunnelOrigin{Type: "agent", Agent: AssignedAgent{AgentID: agentID, AgentName: "home", LocalIp: net.IPv4(127, 0, 0, 1)}}},
+		{`{"type":"managed","data":{"agent_id":"` + agentID.String() + `","agent_name":"cloud"}}`, TunnelOrigin{Type: "managed", Agent: AssignedManaged{AgentID: agentID, AgentName: "cloud"}}},
+	} {
+		var origin TunnelOrigin
+		if err := json.Unmarshal([]byte(test.body), &origin); err != nil {
+			t.Fatalf("%s: %s", test.body, err)
+		} else if !reflect.DeepEqual(origin, test.origin) {
+			t.Errorf("%s: decoded %+v, want %+v", test.body, origin, test.origin)
+		}
+
+		// Encode without Type use variant type
+		test.origin.Type = ""
+		body, err := json.Marshal(test.origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded TunnelOrigin
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(decoded, origin) {
+			t.Errorf("%s: round-trip %+v", body, decoded)
+		}
+	}
+}
+
+func TestDecodeUnknownEnums(t *testing.T) {
+	body := `{"tunnel_type":"hytale","port_type":"quic","port_count":1,"region":"mars","alloc":{"status":"migrating","data":{}},"origin":{"type":"default"}}`
+	var tun AccountTunnel
+	if err := json.Unmarshal([]byte(body), &tun); err != nil {
+		t.Fatal(err)
+	} else if tun.TunnelType != "hytale" || tun.PortType != "quic" || tun.Region != "mars" || tun.Alloc.Status != "migrating" {
+		t.Fatalf("tunnel %+v", tun)
+	} else if tun.TunnelType.Valid() || tun.PortType.Valid() || tun.Region.Valid() {
+		t.Fatal("unknown values reported as valid")
+	}
+
+	var info AgentRunData
+	if err := json.Unmarshal([]byte(`{"agent_type":"cloud"}`), &info); err != nil {
+		t.Fatal(err)
+	} else if info.Type != "cloud" || info.Type.Valid() {
+		t.Fatalf("agent type %q", info.Type)
+	}
+
+	// Encode and text decode keep strict check
+	if _, err := json.Marshal(tun); err == nil {
+		t.Fatal("tunnel with unknown enums encoded")
+	} else if _, err := json.Marshal(PortType("quic")); err == nil {
+		t.Fatal("unknown port type encoded")
+	} else if body, err := json.Marshal(struct{ Proto PortType }{PortTypeBoth}); err != nil || string(body) != `{"Proto":"both"}` {
+		t.Fatalf("encoded %s: %v", body, err)
+	}
+	var region Region
+	if err := region.UnmarshalText([]byte("mars")); err == nil {
+		t.Fatal("unknown region parsed from text")
+	} else if err := region.UnmarshalText([]byte("europe")); err != nil || region != RegionEurope {
+		t.Fatalf("region %q: %v", region, err)
+	}
+
+	// Values built by client are checked before request
+	client := &Client{}
+	err := client.CreateTunnel(context.Background(), Tunnel{PortType: "quic", PortCount: 1, Origin: TunnelOriginCreate{Agent: AssignedDefaultCreate{}}})
+	if err == nil || err.Error() != "invalid port type" {
+		t.Fatalf("expected invalid port type, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api/openapi"
+)
+
+// JSON fields of struct by name
+func jsonFields(typ reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for index := range typ.NumField() {
+		field := typ.Field(index)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		} else if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// Compare JSON shape of hand-written type with generated schema, optional fields can be pointer in one side
+func sameShape(t *testing.T, path string, handWritten, generated reflect.Type) {
+	t.Helper()
+	for handWritten.Kind() == reflect.Pointer {
+		handWritten = handWritten.Elem()
+	}
+	for generated.Kind() == reflect.Pointer {
+		generated = generated.Elem()
+	}
+	unmarshaler := reflect.TypeFor[json.Unmarshaler]()
+
+	switch {
+	case handWritten == generated:
+	case generated == reflect.TypeFor[json.RawMessage]() && reflect.PointerTo(handWritten).Implements(unmarshaler):
+		sameUnion(t, path, handWritten)
+	case handWritten.Kind() != generated.Kind():
+		t.Errorf("%s: %s is %s, spec is %s", path, handWritten, handWritten.Kind(), generated.Kind())
+	case handWritten.Kind() == reflect.Slice:
+		sameShape(t, path+"[]", handWritten.Elem(), generated.Elem())
+	case handWritten.Kind() == reflect.Struct && handWritten != reflect.TypeFor[time.Time]():
+		fields, specFields := jsonFields(handWritten), jsonFields(generated)
+		for name, field := range fields {
+			if specField, ok := specFields[name]; !ok {
+				t.Errorf("%s.%s: not in spec", path, name)
+			} else {
+				sameShape(t, path+"."+name, field, specField)
+			}
+		}
+		for name := range specFields {
+			if _, ok := fields[name]; !ok {
+				t.Errorf("%s.%s: missing in %s", path, name, handWritten)
+			}
+		}
+	}
+}
+
+// Decode every variant of spec union into hand-written union, variant data must have shape of spec
+func sameUnion(t *testing.T, path string, handWritten reflect.Type) {
+	t.Helper()
+	union, ok := openapi.Unions[handWritten.Name()]
+	if !ok {
+		t.Errorf("%s: %s is not union in spec", path, handWritten)
+		return
+	}
+	for tag, variant := range union.Variants {
+		body := map[string]any{union.Tag: tag}
+		if variant.Content != "" {
+			body[variant.Content] = variant.Data
+		}
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := reflect.New(handWritten)
+		if err := json.Unmarshal(raw, value.Interface()); err != nil {
+			t.Errorf("%s %q: %s", path, tag, err)
+			continue
+		}
+
+		// Union data is the only interface field
+		var data reflect.Value
+		for index := range handWritten.NumField() {
+			if field := value.Elem().Field(index); field.Kind() == reflect.Interface {
+				data = field.Elem()
+			}
+		}
+		switch {
+		case variant.Data == nil:
+			if data.IsValid() {
+				t.Errorf("%s %q: decoded %s, spec variant without data", path, tag, data.Type())
+			}
+		case !data.IsValid() || data.Type() == reflect.TypeFor[json.RawMessage]():
+			t.Errorf("%s %q: variant not decoded from %s", path, tag, raw)
+		default:
+			sameShape(t, path+"("+tag+")", data.Type(), reflect.TypeOf(variant.Data))
+		}
+	}
+}
+
+// Types with enums or unions of api are hand-written, must have same fields of generated schema
+func TestTypesMatchSpec(t *testing.T) {
+	for _, pair := range []struct{ handWritten, generated any }{
+		{AccountTunnels{}, openapi.AccountTunnels{}},
+		{AccountTunnel{}, openapi.AccountTunnel{}},
+		{TunnelAllocated{}, openapi.TunnelAllocated{}},
+		{TunnelDedicatedIp{}, openapi.TunnelDedicatedIp{}},
+		{UseRegion{}, openapi.UseRegion{}},
+		{Tunnel{}, openapi.ReqTunnelsCreate{}},
+		{AgentRunData{}, openapi.AgentRunData{}},
+		{AgentTunnel{}, openapi.AgentTunnel{}},
+		{AgentPendingTunnel{}, openapi.AgentPendingTunnel{}},
+		{AgentClaimDetails{}, openapi.AgentClaimDetails{}},
+		{WebSession{}, openapi.WebSession{}},
+		{TunnelDisabled{}, openapi.TunnelDisabled{}},
+		{TunnelDomain{}, openapi.TunnelDomain{}},
+	} {
+		handWritten, generated := reflect.TypeOf(pair.handWritten), reflect.TypeOf(pair.generated)
+		sameShape(t, handWritten.Name(), handWritten, generated)
+	}
+}
+
+func enumValues[T ~string](values []T) []string {
+	var texts []string
+	for _, value := range values {
+		texts = append(texts, string(value))
+	}
+	slices.Sort(texts)
+	return texts
+}
+
+// Enums declared in api must have same values of spec
+func TestEnumsMatchSpec(t *testing.T) {
+	for schema, values := range map[string][]string{
+		"PortType":             enumValues(PortTypes),
+		"TunnelType":           enumValues(TunnelTypes),
+		"AllocationRegion":     enumValues(Regions),
+		"AgentType":            enumValues(AgentTypes),
+		"TunnelDisabledReason": enumValues(TunnelDisabledReasons),
+		"TunnelDomainSource":   enumValues(TunnelDomainSources),
+		"AgentTunnelDisabled":  enumValues(AgentTunnelDisabledTypes),
+	} {
+		spec := slices.Clone(openapi.Enums[schema])
+		slices.Sort(spec)
+		if !slices.Equal(values, spec) {
+			t.Errorf("%s: %q, spec is %q", schema, values, spec)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Decode variant of tagged union to concrete struct
+type unionVariant func(raw json.RawMessage) (any, error)
+
+func variant[T any](raw json.RawMessage) (any, error) {
+	var value T
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Encode tagged union like {"type": "agent", "data": {...}}, content is omitted if data is nil
+func marshalUnion(tag, kind, content string, data any) ([]byte, error) {
+	values := map[string]any{tag: kind}
+	if data != nil {
+		values[content] = data
+	}
+	return json.Marshal(values)
+}
+
+// Decode tagged union and return kind and content decoded by variants,
+// unknown kinds keep content as json.RawMessage
+func unmarshalUnion(body []byte, tag, content string, variants map[string]unionVariant) (string, any, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(body, &values); err != nil {
+		return "", nil, err
+	}
+	var kind string
+	if raw, ok := values[tag]; ok {
+		if err := json.Unmarshal(raw, &kind); err != nil {
+			return "", nil, fmt.Errorf("%s: %w", tag, err)
+		}
+	}
+
+	raw, ok := values[content]
+	if !ok || string(raw) == "null" {
+		return kind, nil, nil
+	} else if decode, ok := variants[kind]; ok {
+		data, err := decode(raw)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s %q: %w", tag, kind, err)
+		}
+		return kind, data, nil
+	}
+	return kind, raw, nil
+}
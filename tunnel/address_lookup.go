@@ -1,63 +1,142 @@
 package tunnel
 
 import (
+	"encoding/binary"
 	"net/netip"
-	"slices"
 
 	"sirherobrine23.org/playit-cloud/go-playit/api"
 )
 
-type PortType struct {
-	Value string
-}
-func (w *PortType) IsValid() bool {
-	return slices.Contains(api.PortType, w.Value)
-}
-func (proto *PortProto) SetBoth() {
-	proto.Value = "both"
-}
-func (proto *PortProto) SetTcp() {
-	proto.Value = "tcp"
-}
-func (proto *PortProto) SetUdp() {
-	proto.Value = "udp"
-}
-
 type AddressValue[T any] struct {
 	Value            T
 	FromPort, ToPort uint16
+	ProxyProtocol    ProxyProtocol // Header to send to local server
+}
+
+// Port offset in range, if port is out of range return 0
+func (addr *AddressValue[T]) PortOffset(port uint16) uint16 {
+	if port < addr.FromPort || port >= addr.ToPort {
+		return 0
+	}
+	return port - addr.FromPort
+}
+
+// Local address to connect, tunnel port From+n go to local port+n
+func LocalAddrPort(found *AddressValue[netip.AddrPort], connect netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(found.Value.Addr(), found.Value.Port()+found.PortOffset(connect.Port()))
 }
 
 type AddressLookup[T any] interface {
 	// Resolve address if exist return value else return nil point
-	Lookup(IpPort netip.AddrPort, Proto PortType) *AddressValue[T]
+	Lookup(IpPort netip.AddrPort, Proto api.PortType) *AddressValue[T]
 }
 
+// Rule to match tunnel address, zero fields are ignored so empty MatchIp match any address
 type MatchIp struct {
-	IP netip.AddrPort
-	RegionID *uint16
+	IP       netip.AddrPort `json:"ip"`        // Exact address, if port is 0 match any port
+	Prefix   netip.Prefix   `json:"prefix"`    // Address in prefix, like 147.185.221.0/24
+	IpNumber *uint64        `json:"ip_number"` // Tunnel ip number, last octet in IPv4
+	RegionID *uint16        `json:"region_id"` // Tunnel region, IPv4 addresses not have region so never match
 }
+
+// Rule match only IPv6 addresses by IP or Prefix
+func (mat *MatchIp) ipv6Only() bool {
+	if mat.IP.IsValid() {
+		return !mat.IP.Addr().Unmap().Is4()
+	}
+	return mat.Prefix.IsValid() && mat.Prefix.Addr().Is6() && !mat.Prefix.Addr().Is4In6()
+}
+
 func (mat *MatchIp) Matches(ip netip.AddrPort) bool {
-	return mat.IP.Compare(ip) == 0
+	addr := ip.Addr().Unmap()
+	if mat.IP.IsValid() {
+		if mat.IP.Addr().Unmap() != addr || (mat.IP.Port() != 0 && mat.IP.Port() != ip.Port()) {
+			return false
+		}
+	}
+	if mat.Prefix.IsValid() && !mat.Prefix.Contains(addr) {
+		return false
+	}
+
+	if mat.IpNumber != nil || mat.RegionID != nil {
+		ipNum, region := tunnelIpNumber(addr)
+		if mat.IpNumber != nil && *mat.IpNumber != ipNum {
+			return false
+		} else if mat.RegionID != nil && (region == nil || *mat.RegionID != *region) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rule precedence, bigger is more specific:
+// exact address, longest prefix, ip number, region and wildcard
+func (mat *MatchIp) Specificity() int {
+	score := 0
+	if mat.IP.IsValid() {
+		score = 400
+		if mat.IP.Port() != 0 {
+			score++
+		}
+	} else if mat.Prefix.IsValid() {
+		score = 200 + mat.Prefix.Bits()
+	} else if mat.IpNumber != nil {
+		score = 100
+		if mat.RegionID != nil {
+			score++
+		}
+	} else if mat.RegionID != nil {
+		score = 50
+	}
+	return score
 }
 
 type MappingOverride struct {
-	MatchIP   MatchIp
-	Proto     PortType
-	Port      api.PortRange
-	LocalAddr netip.AddrPort
+	MatchIP       MatchIp        `json:"match"`
+	Proto         api.PortType   `json:"proto"` // tcp, udp or both
+	Port          api.PortRange  `json:"port"`  // Tunnel ports [From, To) mapped to LocalAddr port+n, empty match any port
+	LocalAddr     netip.AddrPort `json:"local_addr"`
+	ProxyProtocol ProxyProtocol  `json:"proxy_protocol"` // Send PROXY header with real client address, UDP flows always use v2
 }
 
 type LookupWithOverrides []MappingOverride
 
-func (Look *LookupWithOverrides) Lookup(IpPort netip.AddrPort, Proto PortType) *AddressValue[netip.AddrPort] {
-	for _, Over := range *Look {
-		if Over.Proto.Value == Proto.Value && Over.MatchIP.Matches(IpPort) {
-			return &AddressValue[netip.AddrPort]{
-				Value: Over.LocalAddr,
-				FromPort: Over.Port.From,
-				ToPort: Over.Port.To,
-			}
+// Override match any port if Port is empty
+func (Over *MappingOverride) anyPort() bool {
+	return Over.Port.From == 0 && Over.Port.To == 0
+}
+
+// Return most specific override matched by MatchIp.Specificity, override with Port is more specific than
+// same match to any port, if same specificity use first in list.
+// Without override connect to 127.0.0.1 with same port
+func (Look *LookupWithOverrides) Lookup(IpPort netip.AddrPort, Proto api.PortType) *AddressValue[netip.AddrPort] {
+	var found *MappingOverride
+	bestScore := -1
+	for index := range *Look {
+		Over := &(*Look)[index]
+		if !Over.Proto.Has(Proto) {
+			continue
+		} else if !Over.anyPort() && (IpPort.Port() < Over.Port.From || IpPort.Port() >= Over.Port.To) {
+			continue
+		} else if !Over.MatchIP.Matches(IpPort) {
+			continue
+		}
+
+		score := Over.MatchIP.Specificity() * 2
+		if !Over.anyPort() {
+			score++
+		}
+		if score > bestScore {
+			found, bestScore = Over, score
+		}
+	}
+
+	if found != nil {
+		return &AddressValue[netip.AddrPort]{
+			Value:         found.LocalAddr,
+			FromPort:      found.Port.From,
+			ToPort:        found.Port.To,
+			ProxyProtocol: found.ProxyProtocol,
 		}
 	}
 	return &AddressValue[netip.AddrPort]{
@@ -66,3 +145,28 @@ func (Look *LookupWithOverrides) Lookup(IpPort netip.AddrPort, Proto PortType) *
 		ToPort:   IpPort.Port() + 1,
 	}
 }
+
+// Get tunnel ip number and region from address, IPv4 address not have region
+func tunnelIpNumber(addr netip.Addr) (uint64, *uint16) {
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return uint64(addr.As4()[3]), nil
+	}
+	parts := addr.As16()
+	region := binary.BigEndian.Uint16(parts[6:8])
+	return binary.BigEndian.Uint64([]byte{0, 0, parts[10], parts[11], parts[12], parts[13], parts[14], parts[15]}), &region
+}
+
+// Check if address and proto is to agent tunnel
+func agentTunnelMatches(tun api.AgentTunnel, addr netip.AddrPort, proto api.PortType) bool {
+	if !tun.Proto.Has(proto) {
+		return false
+	} else if addr.Port() < tun.Port.From || addr.Port() >= tun.Port.To {
+		return false
+	}
+	ipNum, region := tunnelIpNumber(addr.Addr())
+	if ipNum != uint64(tun.IpNum) {
+		return false
+	}
+	return region == nil || *region == tun.RegionNum
+}
@@ -0,0 +1,146 @@
+package tunnel
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+func TestMatchIp(t *testing.T) {
+	region, otherRegion, ipNum := uint16(5), uint16(6), uint64(42)
+	v4 := netip.MustParseAddrPort("147.185.221.42:25565")
+	v6 := netip.MustParseAddrPort("[2602:fbaf:0:5::2a]:25565")
+
+	for _, test := range []struct {
+		name   string
+		rule   MatchIp
+		v4, v6 bool
+	}{
+		{"empty", MatchIp{}, true, true},
+		{"ip", MatchIp{IP: netip.AddrPortFrom(v4.Addr(), 0)}, true, false},
+		{"ip and port", MatchIp{IP: netip.MustParseAddrPort("147.185.221.42:25566")}, false, false},
+		{"mapped ip", MatchIp{IP: netip.MustParseAddrPort("[::ffff:147.185.221.42]:25565")}, true, false},
+		{"prefix", MatchIp{Prefix: netip.MustParsePrefix("2602:fbaf::/32")}, false, true},
+		{"ip number", MatchIp{IpNumber: &ipNum}, true, true},
+		{"region", MatchIp{RegionID: &region}, false, true},
+		{"other region", MatchIp{RegionID: &otherRegion}, false, false},
+		{"ip number and region", MatchIp{IpNumber: &ipNum, RegionID: &region}, false, true},
+	} {
+		if got := test.rule.Matches(v4); got != test.v4 {
+			t.Errorf("%s: match %s = %v, want %v", test.name, v4, got, test.v4)
+		}
+		if got := test.rule.Matches(v6); got != test.v6 {
+			t.Errorf("%s: match %s = %v, want %v", test.name, v6, got, test.v6)
+		}
+	}
+}
+
+func TestLookupWithOverrides(t *testing.T) {
+	region, ipNum := uint16(5), uint64(42)
+	local := func(port uint16) netip.AddrPort { return netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), port) }
+	lookup := LookupWithOverrides{
+		{MatchIP: MatchIp{}, Proto: api.PortTypeBoth, Port: api.PortRange{From: 25565, To: 25566}, LocalAddr: local(1)},
+		{MatchIP: MatchIp{RegionID: &region}, Proto: api.PortTypeBoth, Port: api.PortRange{From: 25565, To: 25566}, LocalAddr: local(2)},
+		{MatchIP: MatchIp{IpNumber: &ipNum}, Proto: api.PortTypeTcp, Port: api.PortRange{From: 25565, To: 25566}, LocalAddr: local(3)},
+	}
+
+	for _, test := range []struct {
+		addr  string
+		proto api.PortType
+		want  uint16
+	}{
+		{"147.185.221.42:25565", api.PortTypeTcp, 3},
+		{"147.185.221.42:25565", api.PortTypeUdp, 1}, // region rule don't match ipv4
+		{"[2602:fbaf:0:5::2a]:25565", api.PortTypeTcp, 3},
+		{"[2602:fbaf:0:5::2a]:25565", api.PortTypeUdp, 2},
+		{"[2602:fbaf:0:6::2a]:25565", api.PortTypeUdp, 1},
+	} {
+		if found := lookup.Lookup(netip.MustParseAddrPort(test.addr), test.proto); found.Value != local(test.want) {
+			t.Errorf("lookup %s %s = %s, want %s", test.proto, test.addr, found.Value, local(test.want))
+		}
+	}
+
+	empty := LookupWithOverrides{}
+	if found := empty.Lookup(netip.MustParseAddrPort("147.185.221.42:25565"), api.PortTypeTcp); found.Value != local(25565) || found.PortOffset(25565) != 0 {
+		t.Errorf("default lookup to %s", found.Value)
+	}
+}
+
+func TestLookupPortRanges(t *testing.T) {
+	ip := netip.MustParseAddrPort("147.185.221.42:0")
+	local := func(port uint16) netip.AddrPort { return netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), port) }
+	lookup := LookupWithOverrides{
+		{MatchIP: MatchIp{}, Proto: api.PortTypeBoth, LocalAddr: local(1)},
+		{MatchIP: MatchIp{IP: ip}, Proto: api.PortTypeTcp, LocalAddr: local(50000)},
+		{MatchIP: MatchIp{IP: ip}, Proto: api.PortTypeTcp, Port: api.PortRange{From: 25565, To: 25567}, LocalAddr: local(30000)},
+		{MatchIP: MatchIp{IP: ip}, Proto: api.PortTypeTcp, Port: api.PortRange{From: 25600, To: 25601}, LocalAddr: local(40000)},
+	}
+
+	for _, test := range []struct {
+		addr string
+		want uint16
+	}{
+		{"147.185.221.42:25565", 30000},
+		{"147.185.221.42:25566", 30001},
+		{"147.185.221.42:25567", 50000}, // Out of range, same ip to any port
+		{"147.185.221.42:25600", 40000},
+		{"147.185.221.43:25565", 1},
+	} {
+		connect := netip.MustParseAddrPort(test.addr)
+		if found := lookup.Lookup(connect, api.PortTypeTcp); LocalAddrPort(found, connect) != local(test.want) {
+			t.Errorf("lookup %s = %s, want %s", test.addr, LocalAddrPort(found, connect), local(test.want))
+		}
+	}
+}
+
+func TestLocalAddrPort(t *testing.T) {
+	local := netip.MustParseAddrPort("127.0.0.1:30000")
+	for _, test := range []struct {
+		from, to, port, want uint16
+	}{
+		{25565, 25570, 25565, 30000}, // From
+		{25565, 25570, 25569, 30004}, // To-1
+		{25565, 25570, 25570, 30000}, // To is exclusive, out of range use local port
+		{25565, 25570, 25564, 30000},
+		{25565, 25566, 25565, 30000}, // Single port
+		{0, 5, 0, 30000},
+		{0, 5, 4, 30004},
+		{65530, 65535, 65534, 30004},
+		{65530, 65535, 65535, 30000},
+		{0, 0, 25565, 30000}, // Empty range
+	} {
+		found := &AddressValue[netip.AddrPort]{Value: local, FromPort: test.from, ToPort: test.to}
+		connect := netip.AddrPortFrom(netip.MustParseAddr("147.185.221.42"), test.port)
+		if got := LocalAddrPort(found, connect); got.Addr() != local.Addr() || got.Port() != test.want {
+			t.Errorf("[%d, %d) port %d = %s, want port %d", test.from, test.to, test.port, got, test.want)
+		}
+	}
+}
+
+func TestLoadMappingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	for _, test := range []struct {
+		proto, match string
+		valid        bool
+	}{
+		{"tcp", `{}`, true},
+		{"both", `{}`, true},
+		{"quic", `{}`, false},
+		{"tcp", `{"region_id":5}`, false}, // Region rule would never match ipv4
+		{"tcp", `{"region_id":5,"ip_number":42}`, false},
+		{"tcp", `{"region_id":5,"prefix":"147.185.221.0/24"}`, false},
+		{"tcp", `{"region_id":5,"prefix":"2602:fbaf::/32"}`, true},
+		{"tcp", `{"region_id":5,"ip":"[2602:fbaf:0:5::2a]:0"}`, true},
+	} {
+		body := `[{"match":` + test.match + `,"proto":"` + test.proto + `","port":{"from":25565,"to":25566},"local_addr":"127.0.0.1:25565"}]`
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadMappingFile(path); (err == nil) != test.valid {
+			t.Errorf("%s %s: error %v", test.proto, test.match, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package tunnel
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+	"sirherobrine23.org/playit-cloud/go-playit/tunnel/rwlock"
+)
+
+const DefaultAgentLookupRefresh time.Duration = time.Minute // Default interval to reload tunnels from API
+
+// Lookup local address from agent tunnels configured in playit.gg
+type AgentLookup struct {
+	Api      api.Client
+	Fallback AddressLookup[netip.AddrPort] // Used if no tunnel matches, nil return nil
+	Refresh  time.Duration                 // Interval to reload tunnels, default is DefaultAgentLookupRefresh
+	tunnels  rwlock.Rwlock[[]api.AgentTunnel]
+}
+
+func (look *AgentLookup) refresh() time.Duration {
+	if look.Refresh <= 0 {
+		return DefaultAgentLookupRefresh
+	}
+	return look.Refresh
+}
+
+// Current agent tunnels
+func (look *AgentLookup) Tunnels() []api.AgentTunnel {
+	tunnels, unlock := look.tunnels.Read()
+	defer unlock()
+	return tunnels
+}
+
+// Replace tunnels used by lookup
+func (look *AgentLookup) SetTunnels(tunnels []api.AgentTunnel) {
+	current, unlock := look.tunnels.Write()
+	defer unlock()
+	*current = tunnels
+}
+
+// Load tunnels from Api.AgentInfo
+func (look *AgentLookup) Reload(ctx context.Context) error {
+	agent, err := look.Api.AgentInfo(ctx)
+	if err != nil {
+		return err
+	}
+	look.SetTunnels(agent.Tunnels)
+	return nil
+}
+
+// Reload tunnels every Refresh until ctx is done, errors are logged and keep last tunnels
+func (look *AgentLookup) Run(ctx context.Context) error {
+	ticker := time.NewTicker(look.refresh())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := look.Reload(ctx); err != nil {
+				LogDebug.Printf("failed to reload agent tunnels: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+func (look *AgentLookup) Lookup(IpPort netip.AddrPort, Proto api.PortType) *AddressValue[netip.AddrPort] {
+	for _, tun := range look.Tunnels() {
+		if tun.Disabled != nil || !agentTunnelMatches(tun, IpPort, Proto) {
+			continue
+		}
+		return &AddressValue[netip.AddrPort]{
+			Value:    netip.AddrPortFrom(agentTunnelLocalIp(tun), tun.LocalPort),
+			FromPort: tun.Port.From,
+			ToPort:   tun.Port.To,
+		}
+	}
+	if look.Fallback == nil {
+		return nil
+	}
+	return look.Fallback.Lookup(IpPort, Proto)
+}
+
+// Local ip of tunnel, 127.0.0.1 if not set
+func agentTunnelLocalIp(tun api.AgentTunnel) netip.Addr {
+	if ip, ok := netip.AddrFromSlice(tun.LocalIp); ok {
+		return ip.Unmap()
+	}
+	return netip.AddrFrom4([4]byte{127, 0, 0, 1})
+}
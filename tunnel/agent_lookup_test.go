@@ -0,0 +1,57 @@
+package tunnel
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+func TestAgentLookup(t *testing.T) {
+	disabled := api.AgentTunnelDisabledByUser
+	fallback := &LookupWithOverrides{}
+	look := &AgentLookup{Fallback: fallback}
+	look.SetTunnels([]api.AgentTunnel{
+		{IpNum: 42, RegionNum: 5, Port: api.PortRange{From: 25565, To: 25568}, Proto: api.PortTypeTcp, LocalPort: 30000},
+		{IpNum: 42, RegionNum: 5, Port: api.PortRange{From: 25565, To: 25566}, Proto: api.PortTypeUdp, LocalIp: net.ParseIP("10.0.0.2"), LocalPort: 19132},
+		{IpNum: 43, RegionNum: 5, Port: api.PortRange{From: 25565, To: 25566}, Proto: api.PortTypeBoth, LocalPort: 40000, Disabled: &disabled},
+	})
+
+	for _, test := range []struct {
+		addr  string
+		proto api.PortType
+		want  string // Empty to fallback
+	}{
+		{"147.185.221.42:25565", api.PortTypeTcp, "127.0.0.1:30000"},
+		{"147.185.221.42:25567", api.PortTypeTcp, "127.0.0.1:30002"}, // Last port of range
+		{"147.185.221.42:25568", api.PortTypeTcp, ""},                // To is exclusive
+		{"147.185.221.42:25564", api.PortTypeTcp, ""},
+		{"147.185.221.42:25565", api.PortTypeUdp, "10.0.0.2:19132"},
+		{"147.185.221.42:25566", api.PortTypeUdp, ""},
+		{"147.185.221.41:25565", api.PortTypeTcp, ""}, // Other ip number
+		{"147.185.221.43:25565", api.PortTypeTcp, ""}, // Disabled tunnel
+		{"[2602:fbaf:0:5::2a]:25566", api.PortTypeTcp, "127.0.0.1:30001"},
+		{"[2602:fbaf:0:6::2a]:25566", api.PortTypeTcp, ""}, // Other region
+		{"[::ffff:147.185.221.42]:25566", api.PortTypeTcp, "127.0.0.1:30001"},
+	} {
+		connect := netip.MustParseAddrPort(test.addr)
+		found := look.Lookup(connect, test.proto)
+		if found == nil {
+			t.Fatalf("lookup %s %s returned nil with fallback", test.proto, test.addr)
+		}
+
+		want := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), connect.Port()) // fallback without overrides
+		if test.want != "" {
+			want = netip.MustParseAddrPort(test.want)
+		}
+		if got := LocalAddrPort(found, connect); got != want {
+			t.Errorf("lookup %s %s = %s, want %s", test.proto, test.addr, got, want)
+		}
+	}
+
+	look.Fallback = nil
+	if found := look.Lookup(netip.MustParseAddrPort("147.185.221.41:25565"), api.PortTypeTcp); found != nil {
+		t.Errorf("lookup without fallback = %+v", found)
+	}
+}
@@ -16,44 +16,45 @@ func (w *RawSlice) WriteTo(I io.Writer) error {
 	return err
 }
 func (w *RawSlice) ReadFrom(I io.Reader) error {
-	_, err := I.Read(w.Buff)
-	return err
+	dec := NewDecoder(I)
+	w.Buff = dec.Bytes(len(w.Buff))
+	return dec.Err()
 }
 
-func ReadU8(w io.Reader) uint8 {
+func ReadU8(w io.Reader) (uint8, error) {
 	var value uint8
-	binary.Read(w, binary.BigEndian, &value)
-	return value
+	err := binary.Read(w, binary.BigEndian, &value)
+	return value, err
 }
 
 func WriteU8(w io.Writer, value uint8) error {
 	return binary.Write(w, binary.BigEndian, value)
 }
 
-func ReadU16(w io.Reader) uint16 {
+func ReadU16(w io.Reader) (uint16, error) {
 	var value uint16
-	binary.Read(w, binary.BigEndian, &value)
-	return value
+	err := binary.Read(w, binary.BigEndian, &value)
+	return value, err
 }
 
 func WriteU16(w io.Writer, value uint16) error {
 	return binary.Write(w, binary.BigEndian, value)
 }
 
-func ReadU32(w io.Reader) uint32 {
+func ReadU32(w io.Reader) (uint32, error) {
 	var value uint32
-	binary.Read(w, binary.BigEndian, &value)
-	return value
+	err := binary.Read(w, binary.BigEndian, &value)
+	return value, err
 }
 
 func WriteU32(w io.Writer, value uint32) error {
 	return binary.Write(w, binary.BigEndian, value)
 }
 
-func ReadU64(w io.Reader) uint64 {
+func ReadU64(w io.Reader) (uint64, error) {
 	var value uint64
-	binary.Read(w, binary.BigEndian, &value)
-	return value
+	err := binary.Read(w, binary.BigEndian, &value)
+	return value, err
 }
 
 func WriteU64(w io.Writer, value uint64) error {
@@ -115,15 +116,14 @@ func ReadU64Buff(w io.Reader, buff []uint64) error {
 }
 
 func ReadOption(w io.Reader, callback func(reader io.Reader) error) error {
-	code := ReadU8(w)
-	if code == 1 {
-		return callback(w)
+	dec := NewDecoder(w)
+	if dec.Option() {
+		return callback(dec)
 	}
-	return nil
+	return dec.Err()
 }
 
 func WriteOption(w io.Writer, value MessageEncoding) error {
-	fmt.Printf("%+v\n", value)
 	if value != nil {
 		if err := binary.Write(w, binary.BigEndian, uint8(1)); err != nil {
 			return err
@@ -177,43 +177,36 @@ type AddressPort struct {
 	netip.AddrPort
 }
 
-func (sock *AddressPort) WriteTo(w io.Writer) error {
-	addr := sock.Addr()
-	ip, _ := addr.MarshalBinary()
+// Write ip with version prefix, 4 or 6
+func WriteIp(w io.Writer, addr netip.Addr) error {
+	if !addr.IsValid() {
+		return fmt.Errorf("invalid ip")
+	}
+	version := uint8(4)
 	if addr.Is6() {
-		if err := WriteU8(w, uint8(6)); err != nil {
-			return err
-		} else if _, err = w.Write(ip); err != nil {
-			return err
-		}
-	} else {
-		if err := WriteU8(w, uint8(4)); err != nil {
-			return err
-		} else if _, err = w.Write(ip); err != nil {
-			return err
-		}
+		version = 6
 	}
-	if err := WriteU16(w, sock.Port()); err != nil {
+	if err := WriteU8(w, version); err != nil {
+		return err
+	}
+	_, err := w.Write(addr.AsSlice())
+	return err
+}
+
+func (sock *AddressPort) WriteTo(w io.Writer) error {
+	if err := WriteIp(w, sock.Addr()); err != nil {
+		return err
+	} else if err := WriteU16(w, sock.Port()); err != nil {
 		return err
 	}
 	return nil
 }
 func (sock *AddressPort) ReadFrom(w io.Reader) error {
-	switch ReadU8(w) {
-	case 4:
-		buff, err := ReadBuffN(w, 4)
-		if err != nil {
-			return err
-		}
-		sock.AddrPort = netip.AddrPortFrom(netip.AddrFrom4([4]byte(buff)), ReadU16(w))
-		return nil
-	case 6:
-		buff, err := ReadBuffN(w, 16)
-		if err != nil {
-			return err
-		}
-		sock.AddrPort = netip.AddrPortFrom(netip.AddrFrom16([16]byte(buff)), ReadU16(w))
-		return nil
+	dec := NewDecoder(w)
+	ip := dec.Ip()
+	port := dec.U16()
+	if dec.Err() == nil {
+		sock.AddrPort = netip.AddrPortFrom(ip, port)
 	}
-	return fmt.Errorf("cannot get IP type")
+	return dec.Err()
 }
@@ -2,81 +2,300 @@ package tunnel
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net/netip"
+	"sync/atomic"
 	"time"
 
 	"sirherobrine23.org/playit-cloud/go-playit/api"
+	"sirherobrine23.org/playit-cloud/go-playit/tunnel/rwlock"
 )
 
-type AuthenticatedControl struct {
-	ApiClient   api.Api
-	Conn        ConnectedControl
+const DefaultRpcTimeout time.Duration = time.Second * 5 // Default time to wait control response
+
+var (
+	ErrControlClosed = errors.New("control channel closed")
+	ErrUnauthorized  = errors.New("unauthorized, check token or reload agent")
+)
+
+// Values updated by control reader
+type ControlState struct {
 	CurrentPing *uint32
 	LastPong    Pong
-	ForceEpired bool
+	LastPongAt  time.Time // Time of last pong received, zero if not received any
 	Registered  AgentRegistered
-	Buff        []byte
+}
+
+type AuthenticatedControl struct {
+	ApiClient   api.Client
+	Conn        ConnectedControl
+	ForceEpired atomic.Bool
+	State       rwlock.Rwlock[ControlState]
+	RpcTimeout  time.Duration // Time to wait response, default is DefaultRpcTimeout
+
+	requestID  atomic.Uint64
+	pending    rwlock.Rwlock[map[uint64]chan *ControlResponse]
+	newClients chan *NewClient
+	done       chan struct{}
+	readErr    error
+}
+
+// Pending response for one request
+type RpcFuture struct {
+	RequestID uint64
+	control   *AuthenticatedControl
+	response  chan *ControlResponse
+}
+
+// Wait response or ctx done, if ctx not have deadline wait by RpcTimeout
+func (future *RpcFuture) Wait(ctx context.Context) (*ControlResponse, error) {
+	defer future.control.forget(future.RequestID)
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, future.control.rpcTimeout())
+		defer cancel()
+	}
+
+	select {
+	case res := <-future.response:
+		return res, nil
+	case <-future.control.done:
+		return nil, future.control.Err()
+	case <-ctx.Done():
+		return nil, fmt.Errorf("request %d: %w", future.RequestID, ctx.Err())
+	}
+}
+
+func (Auth *AuthenticatedControl) rpcTimeout() time.Duration {
+	if Auth.RpcTimeout <= 0 {
+		return DefaultRpcTimeout
+	}
+	return Auth.RpcTimeout
+}
+
+// Start control reader, call only one time after authenticated
+func (Auth *AuthenticatedControl) start() {
+	Auth.pending.Value = map[uint64]chan *ControlResponse{}
+	Auth.newClients = make(chan *NewClient, 64)
+	Auth.done = make(chan struct{})
+	Auth.requestID.Store(100)
+	Auth.Conn.Udp.SetReadDeadline(time.Time{})
+	go Auth.readLoop()
+}
+
+// Read every control feed, send NewClient to channel and resolve pending requests
+func (Auth *AuthenticatedControl) readLoop() {
+	buff := make([]byte, 2048)
+	defer close(Auth.done)
+	for {
+		size, remote, err := Auth.Conn.Udp.ReadFromUDPAddrPort(buff)
+		if err != nil {
+			Auth.readErr = errors.Join(ErrControlClosed, err)
+			return
+		} else if remote.Addr().Unmap() != Auth.Conn.ControlAddr.Addr().Unmap() || remote.Port() != Auth.Conn.ControlAddr.Port() {
+			LogDebug.Println(InvalidRemote{Expected: Auth.Conn.ControlAddr, Got: remote})
+			continue
+		}
+
+		var feed ControlFeed
+		if err := DecodeMessage(buff[:size], &feed); err != nil {
+			LogDebug.Printf("failed to parse control feed: %s\n", err.Error())
+			continue
+		}
+
+		if feed.NewClient != nil {
+			select {
+			case Auth.newClients <- feed.NewClient:
+			default:
+				LogDebug.Printf("new client queue full, dropping %s\n", feed.NewClient.PeerAddr.AddrPort.String())
+			}
+			continue
+		} else if feed.Response == nil || feed.Response.Content == nil {
+			continue
+		}
+
+		Auth.updateState(feed.Response.Content)
+		pending, unlock := Auth.pending.Read()
+		response, ok := pending[feed.Response.RequestID]
+		unlock()
+		if !ok {
+			LogDebug.Printf("response to unknown request %d\n", feed.Response.RequestID)
+			continue
+		}
+		select {
+		case response <- feed.Response.Content:
+		default:
+		}
+	}
+}
+
+func (Auth *AuthenticatedControl) updateState(res *ControlResponse) {
+	state, unlock := Auth.State.Write()
+	defer unlock()
+	if res.AgentRegistered != nil {
+		LogDebug.Printf("agent registred %+v\n", res.AgentRegistered)
+		state.Registered = *res.AgentRegistered
+	} else if res.Pong != nil {
+		currentPing := uint32(max(uint64(time.Now().UnixMilli()), res.Pong.RequestNow) - res.Pong.RequestNow)
+		state.CurrentPing = &currentPing
+		state.LastPong = *res.Pong
+		state.LastPongAt = time.Now()
+		if res.Pong.SessionExpireAt != nil {
+			state.Registered.ExpiresAt = time.UnixMilli(int64(*res.Pong.SessionExpireAt))
+		}
+	} else if res.Unauthorized {
+		LogDebug.Println("control unauthorized, channel expired")
+		Auth.ForceEpired.Store(true)
+	}
+}
+
+func (Auth *AuthenticatedControl) forget(requestID uint64) {
+	pending, unlock := Auth.pending.Write()
+	delete(*pending, requestID)
+	unlock()
+}
+
+// Send request with new request id and return future to wait response
+func (Auth *AuthenticatedControl) Request(Content MessageEncoding) (*RpcFuture, error) {
+	select {
+	case <-Auth.done:
+		return nil, Auth.Err()
+	default:
+	}
+
+	future := &RpcFuture{
+		RequestID: Auth.requestID.Add(1),
+		control:   Auth,
+		response:  make(chan *ControlResponse, 1),
+	}
+	pending, unlock := Auth.pending.Write()
+	(*pending)[future.RequestID] = future.response
+	unlock()
+
+	if err := Auth.Send(ControlRpcMessage[MessageEncoding]{RequestID: future.RequestID, Content: Content}); err != nil {
+		Auth.forget(future.RequestID)
+		return nil, err
+	}
+	return future, nil
+}
+
+// Send request and wait response
+func (Auth *AuthenticatedControl) Call(ctx context.Context, Content MessageEncoding) (*ControlResponse, error) {
+	future, err := Auth.Request(Content)
+	if err != nil {
+		return nil, err
+	}
+	return future.Wait(ctx)
 }
 
 func (Auth *AuthenticatedControl) Send(Req ControlRpcMessage[MessageEncoding]) error {
-	Auth.Buff = []byte{}
-	bufio := bytes.NewBuffer(Auth.Buff)
-	if err := Req.WriteTo(bufio); err != nil {
+	buff := bytes.NewBuffer([]byte{})
+	if err := Req.WriteTo(buff); err != nil {
 		return err
 	}
-	Auth.Buff = bufio.Bytes()
-	_, err := Auth.Conn.Udp.WriteToUDPAddrPort(Auth.Buff, Auth.Conn.ControlAddr)
+	_, err := Auth.Conn.Udp.WriteToUDPAddrPort(buff.Bytes(), Auth.Conn.ControlAddr)
+	return err
+}
+
+func (Auth *AuthenticatedControl) session() AgentSessionId {
+	state, unlock := Auth.State.Read()
+	defer unlock()
+	return state.Registered.ID
+}
+
+func (Auth *AuthenticatedControl) KeepAlive(ctx context.Context) error {
+	session := Auth.session()
+	res, err := Auth.Call(ctx, &ControlRequest{AgentKeepAlive: &session})
 	if err != nil {
 		return err
+	} else if res.Unauthorized {
+		return ErrUnauthorized
 	}
 	return nil
 }
 
-func (Auth *AuthenticatedControl) SendKeepAlive(RequestID uint64) error {
-	return Auth.Send(ControlRpcMessage[MessageEncoding]{
-		RequestID: RequestID,
-		Content: &ControlRequest{
-			AgentKeepAlive: &Auth.Registered.ID,
-		},
-	})
+func (Auth *AuthenticatedControl) SetupUdpChannel(ctx context.Context) (*UdpChannelDetails, error) {
+	session := Auth.session()
+	res, err := Auth.Call(ctx, &ControlRequest{SetupUdpChannel: &session})
+	if err != nil {
+		return nil, err
+	} else if res.Unauthorized {
+		return nil, ErrUnauthorized
+	} else if res.UdpChannelDetails == nil {
+		return nil, fmt.Errorf("expected udp channel details")
+	}
+	return res.UdpChannelDetails, nil
 }
 
-func (Auth *AuthenticatedControl) SendSetupUDPChannel(RequestID uint64) error {
-	return Auth.Send(ControlRpcMessage[MessageEncoding]{
-		RequestID: RequestID,
-		Content: &ControlRequest{
-			SetupUdpChannel: &Auth.Registered.ID,
+func (Auth *AuthenticatedControl) Ping(ctx context.Context) (*Pong, error) {
+	state, unlock := Auth.State.Read()
+	currentPing, session := state.CurrentPing, state.Registered.ID
+	unlock()
+
+	res, err := Auth.Call(ctx, &ControlRequest{
+		Ping: &Ping{
+			Now:         time.Now(),
+			CurrentPing: currentPing,
+			SessionID:   &session,
 		},
 	})
+	if err != nil {
+		return nil, err
+	} else if res.Unauthorized {
+		return nil, ErrUnauthorized
+	} else if res.Pong == nil {
+		return nil, fmt.Errorf("expected pong")
+	}
+	return res.Pong, nil
 }
 
-func (Auth *AuthenticatedControl) SendPing(RequestID uint64, Now time.Time) error {
-	return Auth.Send(ControlRpcMessage[MessageEncoding]{
-		RequestID: RequestID,
-		Content: &ControlRequest{
-			Ping: &Ping{
-				Now:         Now,
-				CurrentPing: Auth.CurrentPing,
-				SessionID:   &Auth.Registered.ID,
-			},
-		},
-	})
+// NewClient events from control
+func (Auth *AuthenticatedControl) NewClients() <-chan *NewClient {
+	return Auth.newClients
+}
+
+// Closed when control reader stop
+func (Auth *AuthenticatedControl) Done() <-chan struct{} {
+	return Auth.done
+}
+
+// Error that stopped control reader
+func (Auth *AuthenticatedControl) Err() error {
+	select {
+	case <-Auth.done:
+		return Auth.readErr
+	default:
+		return nil
+	}
+}
+
+// Close control socket and stop reader
+func (Auth *AuthenticatedControl) Close() error {
+	return Auth.Conn.Udp.Close()
 }
 
 func (Auth *AuthenticatedControl) FlowChanged() bool {
-	return Auth.LastPong.ClientAddr.Compare(Auth.LastPong.ClientAddr.AddrPort) != 0
+	state, unlock := Auth.State.Read()
+	defer unlock()
+	return state.LastPong.ClientAddr.Compare(Auth.Conn.Pong.ClientAddr.AddrPort) != 0
 }
 
 func (Auth *AuthenticatedControl) IsIspired() bool {
-	return Auth.ForceEpired || Auth.LastPong.SessionExpireAt == nil || Auth.FlowChanged()
+	state, unlock := Auth.State.Read()
+	noExpire := state.LastPong.SessionExpireAt == nil
+	unlock()
+	return Auth.ForceEpired.Load() || noExpire || Auth.FlowChanged()
 }
 
 func (Auth *AuthenticatedControl) IntoRequiresAuth() *ConnectedControl {
+	state, unlock := Auth.State.Read()
+	defer unlock()
 	return &ConnectedControl{
 		ControlAddr: Auth.Conn.ControlAddr,
 		Udp:         Auth.Conn.Udp,
-		Pong:        &Auth.LastPong,
+		Pong:        &state.LastPong,
 	}
 }
 
@@ -88,54 +307,39 @@ func (a InvalidRemote) Error() string {
 	return fmt.Sprintf("expected %s, got %s", a.Expected.String(), a.Got.String())
 }
 
-func (Auth *AuthenticatedControl) RecFeedMsg() (*ControlFeed, error) {
-	Auth.Buff = append(Auth.Buff, make([]byte, 1024)...)
-	size, remote, err := Auth.Conn.Udp.ReadFromUDP(Auth.Buff)
-	LogDebug.Println(size, remote, err)
-	if err != nil {
-		return nil, err
-	} else if remote.AddrPort().Compare(Auth.Conn.ControlAddr) != 0 {
-		return nil, InvalidRemote{Expected: Auth.Conn.ControlAddr, Got: remote.AddrPort()}
-	}
+// Register agent again in same control channel
+func (Auth *AuthenticatedControl) Authenticate(ctx context.Context) error {
+	state, unlock := Auth.State.Read()
+	lastPong := state.LastPong
+	unlock()
 
-	var feed ControlFeed
-	if err := feed.ReadFrom(bytes.NewBuffer(Auth.Buff[size:])); err != nil {
-		return nil, err
+	tkBytes, err := registerKey(ctx, Auth.ApiClient, &lastPong)
+	if err != nil {
+		return err
 	}
 
-	if feed.Response != nil {
-		if feed.Response.Content != nil {
-			if feed.Response.Content.AgentRegistered != nil {
-				LogDebug.Println("agent registred")
-				LogDebug.Printf("%+v\n", feed.Response.Content.AgentRegistered)
-				Auth.Registered = *feed.Response.Content.AgentRegistered
-			} else if feed.Response.Content.Pong != nil {
-				CurrentPing := uint32(feed.Response.Content.Pong.RequestNow - uint64(time.Now().UnixMilli()))
-				Auth.CurrentPing = &CurrentPing
-				Auth.LastPong = *feed.Response.Content.Pong
-				if feed.Response.Content.Pong.SessionExpireAt != nil {
-					Auth.Registered.ExpiresAt = time.UnixMilli(int64(*feed.Response.Content.Pong.SessionExpireAt))
-				}
+	for range 5 {
+		res, err := Auth.Call(ctx, &RawSlice{Buff: tkBytes})
+		if err != nil {
+			return err
+		} else if res.RequestQueued {
+			LogDebug.Println("register queued, waiting 1s")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
 			}
+			continue
+		} else if res.InvalidSignature {
+			return fmt.Errorf("register return invalid signature")
+		} else if res.Unauthorized {
+			return ErrUnauthorized
+		} else if res.AgentRegistered != nil {
+			Auth.Conn.Pong = &lastPong
+			Auth.ForceEpired.Store(false)
+			return nil
 		}
-
+		LogDebug.Println("expected AgentRegistered but got something else")
 	}
-	return &feed, nil
-}
-
-func (Auth *AuthenticatedControl) Authenticate() error {
-	conn, err := (&ConnectedControl{
-		ControlAddr: Auth.Conn.ControlAddr,
-		Udp:         Auth.Conn.Udp,
-		Pong:        &Auth.LastPong,
-	}).Authenticate(Auth.ApiClient)
-	if err != nil {
-		return err
-	}
-	Auth.Buff = conn.Buff
-	Auth.Conn = conn.Conn
-	Auth.CurrentPing = conn.CurrentPing
-	Auth.LastPong = conn.LastPong
-	Auth.Registered = conn.Registered
-	return nil
+	return fmt.Errorf("failed to register agent")
 }
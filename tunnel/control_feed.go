@@ -23,15 +23,11 @@ func (w *ClaimInstructions) WriteTo(I io.Writer) error {
 	return nil
 }
 func (w *ClaimInstructions) ReadFrom(I io.Reader) error {
+	dec := NewDecoder(I)
 	w.Address = AddressPort{}
-	if err := w.Address.ReadFrom(I); err != nil {
-		return err
-	}
-	w.Token = make([]byte, ReadU64(I))
-	if err := ReadBuff(I, w.Token); err != nil {
-		return err
-	}
-	return nil
+	dec.Decode(&w.Address)
+	w.Token = dec.VarBytes(MaxTokenLen)
+	return dec.Err()
 }
 
 type NewClient struct {
@@ -45,9 +41,9 @@ type NewClient struct {
 func (w *NewClient) WriteTo(I io.Writer) error {
 	if err := w.ConnectAddr.WriteTo(I); err != nil {
 		return err
-	} else if w.PeerAddr.WriteTo(I); err != nil {
+	} else if err := w.PeerAddr.WriteTo(I); err != nil {
 		return err
-	} else if w.ClaimInstructions.WriteTo(I); err != nil {
+	} else if err := w.ClaimInstructions.WriteTo(I); err != nil {
 		return err
 	} else if err := WriteU64(I, w.TunnelServerId); err != nil {
 		return err
@@ -57,16 +53,13 @@ func (w *NewClient) WriteTo(I io.Writer) error {
 	return nil
 }
 func (w *NewClient) ReadFrom(I io.Reader) error {
+	dec := NewDecoder(I)
 	w.ConnectAddr, w.PeerAddr = AddressPort{}, AddressPort{}
-	if err := w.ConnectAddr.ReadFrom(I); err != nil {
-		return err
-	} else if err := w.PeerAddr.ReadFrom(I); err != nil {
-		return err
-	} else if err := w.ClaimInstructions.ReadFrom(I); err != nil {
-		return err
-	}
-	w.TunnelServerId, w.DataCenterId = ReadU64(I), ReadU32(I)
-	return nil
+	dec.Decode(&w.ConnectAddr)
+	dec.Decode(&w.PeerAddr)
+	dec.Decode(&w.ClaimInstructions)
+	w.TunnelServerId, w.DataCenterId = dec.U64(), dec.U32()
+	return dec.Err()
 }
 
 type ControlFeed struct {
@@ -97,14 +90,20 @@ func (w *ControlFeed) ReadFrom(I io.Reader) error {
 		d, _ := json.MarshalIndent(w, "", "  ")
 		LogDebug.Printf("Read Feed: %s\n", string(d))
 	}()
-	switch ReadU32(I) {
+	dec := NewDecoder(I)
+	switch dec.U32() {
 	case 1:
 		w.Response = &ControlRpcMessage[*ControlResponse]{}
 		w.Response.Content = &ControlResponse{}
-		return w.Response.ReadFrom(I)
+		dec.Decode(w.Response)
+		return dec.Err()
 	case 2:
 		w.NewClient = &NewClient{}
-		return w.NewClient.ReadFrom(I)
+		dec.Decode(w.NewClient)
+		return dec.Err()
+	}
+	if dec.Err() != nil {
+		return dec.Err()
 	}
 	return fmt.Errorf("invalid ControlFeed id")
 }
@@ -0,0 +1,92 @@
+package tunnel
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+func responseFeed(id uint64, res ControlResponse) *ControlFeed {
+	return &ControlFeed{Response: &ControlRpcMessage[*ControlResponse]{RequestID: id, Content: &res}}
+}
+
+var testNewClient = NewClient{
+	ConnectAddr: AddressPort{netip.MustParseAddrPort("147.185.221.1:10000")},
+	PeerAddr:    AddressPort{netip.MustParseAddrPort("198.51.100.20:53211")},
+	ClaimInstructions: ClaimInstructions{
+		Address: AddressPort{netip.MustParseAddrPort("147.185.221.1:5530")},
+		Token:   []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77},
+	},
+	TunnelServerId: 9,
+	DataCenterId:   3,
+}
+
+func TestControlFeedGolden(t *testing.T) {
+	session := testSession
+	checkGolden(t, "feed_pong", responseFeed(1, ControlResponse{Pong: &Pong{
+		RequestNow:      1700000000000,
+		ServerNow:       1700000000050,
+		ServerId:        9,
+		DataCenterId:    3,
+		ClientAddr:      AddressPort{netip.MustParseAddrPort("[2001:db8::7]:40000")},
+		TunnelAddr:      testTunnelAddr,
+		SessionExpireAt: u64(1700000060000),
+	}}), newFeed)
+	checkGolden(t, "feed_pong_unregistered", responseFeed(1, ControlResponse{Pong: &Pong{
+		RequestNow:   1700000000000,
+		ServerNow:    1700000000050,
+		ServerId:     9,
+		DataCenterId: 3,
+		ClientAddr:   testClientAddr,
+		TunnelAddr:   testTunnelAddr,
+	}}), newFeed)
+	checkGolden(t, "feed_agent_registered", responseFeed(3, ControlResponse{AgentRegistered: &AgentRegistered{ID: session, ExpiresAt: time.UnixMilli(1700000060000)}}), newFeed)
+	checkGolden(t, "feed_port_mapping", responseFeed(6, ControlResponse{AgentPortMapping: &AgentPortMapping{
+		Range: PortRange{IP: testPortRange.IP, PortStart: 10000, PortEnd: 10010, PortProto: api.PortTypeUdp},
+		Found: &AgentPortMappingFound{ToAgent: &session},
+	}}), newFeed)
+	checkGolden(t, "feed_udp_channel_details", responseFeed(5, ControlResponse{UdpChannelDetails: &UdpChannelDetails{
+		TunnelAddr: testTunnelAddr,
+		Token:      []byte{0xa0, 0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf},
+	}}), newFeed)
+	checkGolden(t, "feed_unauthorized", responseFeed(4, ControlResponse{Unauthorized: true}), newFeed)
+
+	client := testNewClient
+	checkGolden(t, "feed_new_client", &ControlFeed{NewClient: &client}, newFeed)
+}
+
+func TestControlFeedRoundTrip(t *testing.T) {
+	client := testNewClient
+	client.PeerAddr = AddressPort{netip.MustParseAddrPort("[2001:db8::20]:53211")}
+	roundTrip(t, &ControlFeed{NewClient: &client}, &ControlFeed{})
+	roundTrip(t, responseFeed(1<<40, ControlResponse{TryAgainLater: true}), &ControlFeed{})
+	roundTrip(t, &client.ClaimInstructions, &ClaimInstructions{})
+
+	if err := (&ControlFeed{}).WriteTo(&discard{}); err == nil {
+		t.Error("empty ControlFeed encoded")
+	}
+	if err := DecodeMessage([]byte{0, 0, 0, 3}, &ControlFeed{}); err == nil {
+		t.Error("decoded unknown ControlFeed id")
+	}
+
+	// Claim token length bigger than MaxTokenLen
+	golden := readGolden(t, "feed_new_client")
+	golden[4+7+7+7+6] = 0x10
+	if err := DecodeMessage(golden, &ControlFeed{}); err == nil {
+		t.Error("decoded claim token with 4096 bytes length")
+	}
+}
+
+func FuzzControlFeed(f *testing.F) {
+	var seeds [][]byte
+	for _, name := range []string{"feed_pong", "feed_pong_unregistered", "feed_agent_registered", "feed_port_mapping", "feed_udp_channel_details", "feed_unauthorized", "feed_new_client"} {
+		seeds = append(seeds, readGolden(f, name))
+	}
+	fuzzMessage(f, newFeed, seeds...)
+}
+
+func FuzzNewClient(f *testing.F) {
+	fuzzMessage(f, func() MessageEncoding { return &NewClient{} }, readGolden(f, "feed_new_client")[4:])
+}
@@ -38,14 +38,21 @@ func (w *Ping) WriteTo(I io.Writer) error {
 	return w.SessionID.WriteTo(I)
 }
 func (w *Ping) ReadFrom(I io.Reader) error {
-	w.Now = time.UnixMilli(int64(ReadU64(I)))
+	dec := NewDecoder(I)
+	w.Now = time.UnixMilli(int64(dec.U64()))
 
-	CurrentPing := ReadU32(I)
-	w.CurrentPing = &CurrentPing
+	w.CurrentPing = nil
+	if dec.Option() {
+		CurrentPing := dec.U32()
+		w.CurrentPing = &CurrentPing
+	}
 
-	w.SessionID = &AgentSessionId{}
-	w.SessionID.ReadFrom(I)
-	return nil
+	w.SessionID = nil
+	if dec.Option() {
+		w.SessionID = &AgentSessionId{}
+		dec.Decode(w.SessionID)
+	}
+	return dec.Err()
 }
 
 type Pong struct {
@@ -77,20 +84,20 @@ func (w *Pong) WriteTo(I io.Writer) error {
 	return nil
 }
 func (w *Pong) ReadFrom(I io.Reader) error {
-	w.RequestNow, w.ServerNow, w.ServerId = ReadU64(I), ReadU64(I), ReadU64(I)
-	w.DataCenterId = ReadU32(I)
+	dec := NewDecoder(I)
+	w.RequestNow, w.ServerNow, w.ServerId = dec.U64(), dec.U64(), dec.U64()
+	w.DataCenterId = dec.U32()
 	w.ClientAddr = AddressPort{}
 	w.TunnelAddr = AddressPort{}
+	dec.Decode(&w.ClientAddr)
+	dec.Decode(&w.TunnelAddr)
 
-	if err := w.ClientAddr.ReadFrom(I); err != nil {
-		return err
-	} else if err := w.TunnelAddr.ReadFrom(I); err != nil {
-		return err
+	w.SessionExpireAt = nil
+	if dec.Option() {
+		Sess := dec.U64()
+		w.SessionExpireAt = &Sess
 	}
-
-	Sess := ReadU64(I)
-	w.SessionExpireAt = &Sess
-	return nil
+	return dec.Err()
 }
 
 type AgentRegister struct {
@@ -128,27 +135,21 @@ func (w *AgentRegister) WriteTo(I io.Writer) error {
 		return err
 	} else if err := w.TunnelAddr.WriteTo(I); err != nil {
 		return err
+	} else if len(w.Signature) != MaxSignatureLen {
+		return fmt.Errorf("signature require %d bytes", MaxSignatureLen)
 	} else if err := binary.Write(I, binary.BigEndian, w.Signature); err != nil {
 		return err
 	}
 	return nil
 }
 func (w *AgentRegister) ReadFrom(I io.Reader) error {
-	w.AccountID = ReadU64(I)
-	w.AgentId = ReadU64(I)
-	w.AgentVersion = ReadU64(I)
-	w.Timestamp = ReadU64(I)
+	dec := NewDecoder(I)
+	w.AccountID, w.AgentId, w.AgentVersion, w.Timestamp = dec.U64(), dec.U64(), dec.U64(), dec.U64()
 	w.ClientAddr, w.TunnelAddr = AddressPort{}, AddressPort{}
-	if err := w.ClientAddr.ReadFrom(I); err != nil {
-		return err
-	} else if err := w.TunnelAddr.ReadFrom(I); err != nil {
-		return err
-	}
-	w.Signature = make([]byte, 32)
-	if err := ReadBuff(I, w.Signature); err != nil {
-		return err
-	}
-	return nil
+	dec.Decode(&w.ClientAddr)
+	dec.Decode(&w.TunnelAddr)
+	w.Signature = dec.Bytes(MaxSignatureLen)
+	return dec.Err()
 }
 
 type AgentCheckPortMapping struct {
@@ -165,13 +166,11 @@ func (w *AgentCheckPortMapping) WriteTo(I io.Writer) error {
 	return nil
 }
 func (w *AgentCheckPortMapping) ReadFrom(I io.Reader) error {
+	dec := NewDecoder(I)
 	w.AgentSessionId, w.PortRange = AgentSessionId{}, PortRange{}
-	if err := w.AgentSessionId.ReadFrom(I); err != nil {
-		return err
-	} else if err := w.PortRange.ReadFrom(I); err != nil {
-		return err
-	}
-	return nil
+	dec.Decode(&w.AgentSessionId)
+	dec.Decode(&w.PortRange)
+	return dec.Err()
 }
 
 type ControlRequest struct {
@@ -212,24 +211,27 @@ func (w *ControlRequest) WriteTo(I io.Writer) error {
 	return fmt.Errorf("set ControlRequest")
 }
 func (w *ControlRequest) ReadFrom(I io.Reader) error {
-	switch ReadU32(I) {
-	case 1:
+	dec := NewDecoder(I)
+	switch dec.U32() {
+	case 1, 6:
 		w.Ping = &Ping{}
-		return w.Ping.ReadFrom(I)
+		dec.Decode(w.Ping)
 	case 2:
 		w.AgentRegister = &AgentRegister{}
-		return w.AgentRegister.ReadFrom(I)
+		dec.Decode(w.AgentRegister)
 	case 3:
 		w.AgentKeepAlive = &AgentSessionId{}
-		return w.AgentKeepAlive.ReadFrom(I)
+		dec.Decode(w.AgentKeepAlive)
 	case 4:
 		w.SetupUdpChannel = &AgentSessionId{}
-		return w.SetupUdpChannel.ReadFrom(I)
+		dec.Decode(w.SetupUdpChannel)
 	case 5:
 		w.AgentCheckPortMapping = &AgentCheckPortMapping{}
-		return w.AgentCheckPortMapping.ReadFrom(I)
+		dec.Decode(w.AgentCheckPortMapping)
+	default:
+		dec.Fail(fmt.Errorf("invalid ControlRequest id"))
 	}
-	return fmt.Errorf("invalid ControlRequest id")
+	return dec.Err()
 }
 
 type AgentRegistered struct {
@@ -246,12 +248,11 @@ func (w *AgentRegistered) WriteTo(I io.Writer) error {
 	return nil
 }
 func (w *AgentRegistered) ReadFrom(I io.Reader) error {
+	dec := NewDecoder(I)
 	w.ID = AgentSessionId{}
-	if err := w.ID.ReadFrom(I); err != nil {
-		return err
-	}
-	w.ExpiresAt = time.UnixMilli(int64(ReadU64(I)))
-	return nil
+	dec.Decode(&w.ID)
+	w.ExpiresAt = time.UnixMilli(int64(dec.U64()))
+	return dec.Err()
 }
 
 type AgentPortMappingFound struct {
@@ -259,19 +260,21 @@ type AgentPortMappingFound struct {
 }
 
 func (agentPort *AgentPortMappingFound) WriteTo(I io.Writer) error {
-	if agentPort.ToAgent != nil {
-		if err := WriteU32(I, 1); err != nil {
-			return err
-		} else if err := agentPort.ToAgent.WriteTo(I); err != nil {
-			return err
-		}
+	if agentPort.ToAgent == nil {
+		return fmt.Errorf("set ToAgent")
+	} else if err := WriteU32(I, 1); err != nil {
+		return err
 	}
-	return nil
+	return agentPort.ToAgent.WriteTo(I)
 }
 func (agentPort *AgentPortMappingFound) ReadFrom(I io.Reader) error {
-	if ReadU32(I) == 1 {
+	dec := NewDecoder(I)
+	if dec.U32() == 1 {
 		agentPort.ToAgent = &AgentSessionId{}
-		return agentPort.ToAgent.ReadFrom(I)
+		dec.Decode(agentPort.ToAgent)
+		return dec.Err()
+	} else if dec.Err() != nil {
+		return dec.Err()
 	}
 	return fmt.Errorf("unknown AgentPortMappingFound id")
 }
@@ -284,18 +287,20 @@ type AgentPortMapping struct {
 func (w *AgentPortMapping) WriteTo(I io.Writer) error {
 	if err := w.Range.WriteTo(I); err != nil {
 		return err
-	} else if err := w.Found.WriteTo(I); err != nil {
-		return err
+	} else if w.Found == nil {
+		return WriteOption(I, nil)
 	}
-	return nil
+	return WriteOption(I, w.Found)
 }
 func (w *AgentPortMapping) ReadFrom(I io.Reader) error {
-	if err := w.Range.ReadFrom(I); err != nil {
-		return err
-	} else if err := w.Found.ReadFrom(I); err != nil {
-		return err
+	dec := NewDecoder(I)
+	dec.Decode(&w.Range)
+	w.Found = nil
+	if dec.Option() {
+		w.Found = &AgentPortMappingFound{}
+		dec.Decode(w.Found)
 	}
-	return nil
+	return dec.Err()
 }
 
 type UdpChannelDetails struct {
@@ -314,15 +319,11 @@ func (w *UdpChannelDetails) WriteTo(I io.Writer) error {
 	return nil
 }
 func (w *UdpChannelDetails) ReadFrom(I io.Reader) error {
+	dec := NewDecoder(I)
 	w.TunnelAddr = AddressPort{}
-	if err := w.TunnelAddr.ReadFrom(I); err != nil {
-		return err
-	}
-	w.Token = make([]byte, ReadU64(I))
-	if err := ReadBuff(I, w.Token); err != nil {
-		return err
-	}
-	return nil
+	dec.Decode(&w.TunnelAddr)
+	w.Token = dec.VarBytes(MaxTokenLen)
+	return dec.Err()
 }
 
 type ControlResponse struct {
@@ -381,31 +382,30 @@ func (w *ControlResponse) WriteTo(I io.Writer) error {
 	return fmt.Errorf("set one option to write")
 }
 func (w *ControlResponse) ReadFrom(I io.Reader) error {
-	switch ReadU32(I) {
+	dec := NewDecoder(I)
+	switch dec.U32() {
 	case 1:
 		w.Pong = &Pong{}
-		return w.Pong.ReadFrom(I)
+		dec.Decode(w.Pong)
 	case 2:
 		w.InvalidSignature = true
-		return nil
 	case 3:
 		w.Unauthorized = true
-		return nil
 	case 4:
 		w.RequestQueued = true
-		return nil
 	case 5:
 		w.TryAgainLater = true
-		return nil
 	case 6:
 		w.AgentRegistered = &AgentRegistered{}
-		return w.AgentRegistered.ReadFrom(I)
+		dec.Decode(w.AgentRegistered)
 	case 7:
 		w.AgentPortMapping = &AgentPortMapping{}
-		return w.AgentPortMapping.ReadFrom(I)
+		dec.Decode(w.AgentPortMapping)
 	case 8:
 		w.UdpChannelDetails = &UdpChannelDetails{}
-		return w.UdpChannelDetails.ReadFrom(I)
+		dec.Decode(w.UdpChannelDetails)
+	default:
+		dec.Fail(fmt.Errorf("invalid ControlResponse id"))
 	}
-	return fmt.Errorf("invalid ControlResponse id")
+	return dec.Err()
 }
@@ -0,0 +1,177 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+var (
+	testSession    = AgentSessionId{SessionID: 7, AccountID: 1234, AgentID: 5678}
+	testTunnelAddr = AddressPort{netip.MustParseAddrPort("147.185.221.1:5525")}
+	testClientAddr = AddressPort{netip.MustParseAddrPort("203.0.113.7:49152")}
+	testPortRange  = PortRange{IP: net.IPv4(147, 185, 221, 1).To4(), PortStart: 10000, PortEnd: 10010, PortProto: api.PortTypeTcp}
+)
+
+func u32(value uint32) *uint32 { return &value }
+func u64(value uint64) *uint64 { return &value }
+
+func testSignature() []byte {
+	signature := make([]byte, MaxSignatureLen)
+	for i := range signature {
+		signature[i] = byte(i)
+	}
+	return signature
+}
+
+func requestRpc(id uint64, req ControlRequest) *ControlRpcMessage[*ControlRequest] {
+	return &ControlRpcMessage[*ControlRequest]{RequestID: id, Content: &req}
+}
+
+func TestControlRequestGolden(t *testing.T) {
+	session := testSession
+	checkGolden(t, "ping", requestRpc(1, ControlRequest{Ping: &Ping{Now: time.UnixMilli(1700000000000)}}), newRequestRpc)
+	checkGolden(t, "ping_session", requestRpc(2, ControlRequest{Ping: &Ping{Now: time.UnixMilli(1700000000123), CurrentPing: u32(35), SessionID: &session}}), newRequestRpc)
+	checkGolden(t, "agent_register", requestRpc(3, ControlRequest{AgentRegister: &AgentRegister{
+		AccountID:    1234,
+		AgentId:      5678,
+		AgentVersion: 17001,
+		Timestamp:    1700000000000,
+		ClientAddr:   testClientAddr,
+		TunnelAddr:   testTunnelAddr,
+		Signature:    testSignature(),
+	}}), newRequestRpc)
+	checkGolden(t, "keep_alive", requestRpc(4, ControlRequest{AgentKeepAlive: &session}), newRequestRpc)
+	checkGolden(t, "setup_udp_channel", requestRpc(5, ControlRequest{SetupUdpChannel: &session}), newRequestRpc)
+	checkGolden(t, "check_port_mapping", requestRpc(6, ControlRequest{AgentCheckPortMapping: &AgentCheckPortMapping{
+		AgentSessionId: session,
+		PortRange:      testPortRange,
+	}}), newRequestRpc)
+}
+
+func TestControlRequestRoundTrip(t *testing.T) {
+	session := testSession
+	v6Client := AddressPort{netip.MustParseAddrPort("[2001:db8::7]:40000")}
+	for _, req := range []ControlRequest{
+		{Ping: &Ping{Now: time.UnixMilli(0)}},
+		{Ping: &Ping{Now: time.UnixMilli(1700000000000), CurrentPing: u32(0)}},
+		{Ping: &Ping{Now: time.UnixMilli(1700000000000), SessionID: &session}},
+		{AgentRegister: &AgentRegister{ClientAddr: v6Client, TunnelAddr: testTunnelAddr, Signature: testSignature()}},
+		{AgentKeepAlive: &AgentSessionId{}},
+		{SetupUdpChannel: &session},
+		{AgentCheckPortMapping: &AgentCheckPortMapping{AgentSessionId: session, PortRange: PortRange{IP: net.ParseIP("2602:fbaf::1"), PortStart: 1, PortEnd: 65535, PortProto: api.PortTypeBoth}}},
+	} {
+		roundTrip(t, &req, &ControlRequest{})
+	}
+
+	if err := (&ControlRequest{}).WriteTo(&discard{}); err == nil {
+		t.Error("empty ControlRequest encoded")
+	}
+	if err := (&AgentRegister{ClientAddr: testClientAddr, TunnelAddr: testTunnelAddr, Signature: []byte{1}}).WriteTo(&discard{}); err == nil {
+		t.Error("AgentRegister encoded with short signature")
+	}
+}
+
+func TestPingDecode(t *testing.T) {
+	// Old agents send ping with id 1
+	golden := readGolden(t, "ping_session")
+	golden[11] = 1
+	msg := newRequestRpc().(*ControlRpcMessage[*ControlRequest])
+	if err := DecodeMessage(golden, msg); err != nil {
+		t.Fatal(err)
+	} else if ping := msg.Content.Ping; ping == nil || ping.CurrentPing == nil || *ping.CurrentPing != 35 || ping.SessionID == nil || *ping.SessionID != testSession {
+		t.Fatalf("decoded ping id 1 as %s", dump(msg))
+	}
+
+	// Option flag only accept 0 and 1
+	for _, offset := range []int{20, 25} {
+		golden := readGolden(t, "ping_session")
+		golden[offset] = 2
+		if err := DecodeMessage(golden, newRequestRpc()); err == nil {
+			t.Errorf("decoded option flag 2 at byte %d", offset)
+		}
+	}
+
+	golden = readGolden(t, "ping")
+	golden[11] = 7
+	if err := DecodeMessage(golden, newRequestRpc()); err == nil {
+		t.Error("decoded unknown ControlRequest id")
+	}
+}
+
+func TestControlResponseRoundTrip(t *testing.T) {
+	session := testSession
+	for _, res := range []ControlResponse{
+		{Pong: &Pong{RequestNow: 1, ServerNow: 2, ServerId: 3, DataCenterId: 4, ClientAddr: testClientAddr, TunnelAddr: testTunnelAddr}},
+		{Pong: &Pong{ClientAddr: testClientAddr, TunnelAddr: testTunnelAddr, SessionExpireAt: u64(1700000060000)}},
+		{InvalidSignature: true},
+		{Unauthorized: true},
+		{RequestQueued: true},
+		{TryAgainLater: true},
+		{AgentRegistered: &AgentRegistered{ID: session, ExpiresAt: time.UnixMilli(1700000060000)}},
+		{AgentPortMapping: &AgentPortMapping{Range: testPortRange}},
+		{AgentPortMapping: &AgentPortMapping{Range: testPortRange, Found: &AgentPortMappingFound{ToAgent: &session}}},
+		{UdpChannelDetails: &UdpChannelDetails{TunnelAddr: testTunnelAddr, Token: []byte("token")}},
+	} {
+		roundTrip(t, &res, &ControlResponse{})
+	}
+
+	if err := (&ControlResponse{}).WriteTo(&discard{}); err == nil {
+		t.Error("empty ControlResponse encoded")
+	}
+	if err := DecodeMessage([]byte{0, 0, 0, 9}, &ControlResponse{}); err == nil {
+		t.Error("decoded unknown ControlResponse id")
+	}
+	if err := DecodeMessage([]byte{0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 1}, &AgentPortMappingFound{}); err == nil {
+		t.Error("decoded unknown AgentPortMappingFound id")
+	}
+
+	token := (&UdpChannelDetails{TunnelAddr: testTunnelAddr, Token: make([]byte, MaxTokenLen+1)})
+	if err := DecodeMessage(encode(t, token), &UdpChannelDetails{}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge to token, got %v", err)
+	}
+}
+
+func FuzzControlRequest(f *testing.F) {
+	var seeds [][]byte
+	for _, name := range []string{"ping", "ping_session", "agent_register", "keep_alive", "setup_udp_channel", "check_port_mapping"} {
+		seeds = append(seeds, readGolden(f, name))
+	}
+	fuzzMessage(f, newRequestRpc, seeds...)
+}
+
+func FuzzControlResponse(f *testing.F) {
+	var seeds [][]byte
+	for _, name := range []string{"feed_pong", "feed_pong_unregistered", "feed_agent_registered", "feed_port_mapping", "feed_udp_channel_details", "feed_unauthorized"} {
+		seeds = append(seeds, readGolden(f, name)[4+8:]) // skip feed id and request id
+	}
+	fuzzMessage(f, func() MessageEncoding { return &ControlResponse{} }, seeds...)
+}
+
+func FuzzPing(f *testing.F) {
+	fuzzMessage(f, func() MessageEncoding { return &Ping{} }, readGolden(f, "ping")[12:], readGolden(f, "ping_session")[12:])
+}
+
+func FuzzPong(f *testing.F) {
+	fuzzMessage(f, func() MessageEncoding { return &Pong{} }, readGolden(f, "feed_pong")[16:], readGolden(f, "feed_pong_unregistered")[16:])
+}
+
+func FuzzAgentRegister(f *testing.F) {
+	fuzzMessage(f, func() MessageEncoding { return &AgentRegister{} }, readGolden(f, "agent_register")[12:])
+}
+
+func FuzzAgentPortMapping(f *testing.F) {
+	fuzzMessage(f, func() MessageEncoding { return &AgentPortMapping{} }, readGolden(f, "feed_port_mapping")[16:])
+}
+
+func FuzzUdpChannelDetails(f *testing.F) {
+	fuzzMessage(f, func() MessageEncoding { return &UdpChannelDetails{} }, readGolden(f, "feed_udp_channel_details")[16:])
+}
+
+type discard struct{}
+
+func (*discard) Write(p []byte) (int, error) { return len(p), nil }
@@ -0,0 +1,157 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+const (
+	MaxTokenLen     int = 1024 // Max bytes of claim and udp channel tokens
+	MaxSignatureLen int = 32   // AgentRegister signature size
+	MaxMessageLen   int = 2048 // Max control message size
+)
+
+var (
+	ErrTrailingData = errors.New("trailing data after message")
+	ErrTooLarge     = errors.New("length exceeds limit")
+)
+
+// Decoder read big endian values from reader and keep first error,
+// after error every read return zero value
+type Decoder struct {
+	Reader io.Reader
+	err    error
+}
+
+// Create decoder to reader, if reader already is Decoder return it
+func NewDecoder(r io.Reader) *Decoder {
+	if dec, isDecoder := r.(*Decoder); isDecoder {
+		return dec
+	}
+	return &Decoder{Reader: r}
+}
+
+// Decode full buffer to msg, fail if buffer is short or have bytes after message
+func DecodeMessage(buff []byte, msg MessageEncoding) error {
+	if len(buff) > MaxMessageLen {
+		return fmt.Errorf("message with %d bytes: %w", len(buff), ErrTooLarge)
+	}
+	reader := bytes.NewReader(buff)
+	if err := msg.ReadFrom(reader); err != nil {
+		return err
+	} else if reader.Len() > 0 {
+		return fmt.Errorf("%w: %d bytes", ErrTrailingData, reader.Len())
+	}
+	return nil
+}
+
+func (dec *Decoder) Read(p []byte) (int, error) {
+	if dec.err != nil {
+		return 0, dec.err
+	}
+	return dec.Reader.Read(p)
+}
+
+// First error in decoder
+func (dec *Decoder) Err() error {
+	return dec.err
+}
+
+// Set decoder error if not have error, io.EOF is changed to io.ErrUnexpectedEOF
+func (dec *Decoder) Fail(err error) {
+	if dec.err == nil && err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		dec.err = err
+	}
+}
+
+// Read exactly size bytes
+func (dec *Decoder) Bytes(size int) []byte {
+	if dec.err != nil {
+		return nil
+	}
+	buff := make([]byte, size)
+	if _, err := io.ReadFull(dec.Reader, buff); err != nil {
+		dec.Fail(err)
+		return nil
+	}
+	return buff
+}
+
+// Read bytes with u64 length prefix, fail if length is bigger than max
+func (dec *Decoder) VarBytes(max int) []byte {
+	size := dec.U64()
+	if dec.err != nil {
+		return nil
+	} else if size > uint64(max) {
+		dec.Fail(fmt.Errorf("%d bytes, max %d: %w", size, max, ErrTooLarge))
+		return nil
+	}
+	return dec.Bytes(int(size))
+}
+
+func (dec *Decoder) U8() uint8 {
+	value, err := ReadU8(dec)
+	dec.Fail(err)
+	return value
+}
+
+func (dec *Decoder) U16() uint16 {
+	value, err := ReadU16(dec)
+	dec.Fail(err)
+	return value
+}
+
+func (dec *Decoder) U32() uint32 {
+	value, err := ReadU32(dec)
+	dec.Fail(err)
+	return value
+}
+
+func (dec *Decoder) U64() uint64 {
+	value, err := ReadU64(dec)
+	dec.Fail(err)
+	return value
+}
+
+// Read ip with version prefix
+func (dec *Decoder) Ip() netip.Addr {
+	switch dec.U8() {
+	case 4:
+		if ip := dec.Bytes(4); ip != nil {
+			return netip.AddrFrom4([4]byte(ip))
+		}
+	case 6:
+		if ip := dec.Bytes(16); ip != nil {
+			return netip.AddrFrom16([16]byte(ip))
+		}
+	default:
+		dec.Fail(fmt.Errorf("cannot get IP type"))
+	}
+	return netip.Addr{}
+}
+
+// Read option flag, return true if value is present
+func (dec *Decoder) Option() bool {
+	switch dec.U8() {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	dec.Fail(fmt.Errorf("invalid option flag"))
+	return false
+}
+
+// Read message with same decoder
+func (dec *Decoder) Decode(msg MessageEncoding) {
+	if dec.err != nil {
+		return
+	}
+	dec.Fail(msg.ReadFrom(dec))
+}
@@ -1,11 +1,22 @@
 package tunnel
 
 import (
+	"context"
 	"encoding/binary"
+	"encoding/json"
 	"net"
+	"net/http"
 	"net/netip"
+	"time"
+
+	"sirherobrine23.org/playit-cloud/go-playit/tunnel/rwlock"
 )
 
+const DefaultLanEntryTTL time.Duration = time.Hour * 24 // Time to keep special lan addresses without new connections
+
+// Local 127.x.y.z address to real peer address, filled by TcpSocket and UdpSocket with special lan
+var SpecialLanTable = &LanTable{}
+
 func shuffle(v uint32) uint32 {
 	v = ((v >> 16) ^ v) * 0x45d9f3
 	v = ((v >> 16) ^ v) * 0x45d9f3
@@ -21,38 +32,140 @@ func asLocalMasked(ip uint32) uint32 {
 	return ip | 0x7F000000
 }
 
-func mapToLocalIP4(ip net.IP) net.IP {
+func mapToLocalIP4(ip netip.Addr) netip.Addr {
 	var ipUint32 uint32
-	if ip.To4() != nil { // Check if it's already IPv4
-		ipUint32 = binary.BigEndian.Uint32(ip.To4())
+	if ip = ip.Unmap(); ip.Is4() { // Check if it's already IPv4
+		ipUint32 = binary.BigEndian.Uint32(ip.AsSlice())
 	} else { // Handle IPv6
-		bytes := ip.To16() // Convert to IPv6 bytes
+		bytes := ip.As16()
 		ipUint32 = shuffle(binary.BigEndian.Uint32(bytes[0:4])) ^
 			shuffle(binary.BigEndian.Uint32(bytes[4:8])) ^
 			shuffle(binary.BigEndian.Uint32(bytes[8:12])) ^
 			shuffle(binary.BigEndian.Uint32(bytes[12:16]))
 	}
 
-	return net.IPv4(
-		byte(asLocalMasked(ipUint32)>>24),
-		byte(asLocalMasked(ipUint32)>>16),
-		byte(asLocalMasked(ipUint32)>>8),
-		byte(asLocalMasked(ipUint32)),
-	)
+	var local [4]byte
+	binary.BigEndian.PutUint32(local[:], asLocalMasked(ipUint32))
+	return netip.AddrFrom4(local)
+}
+
+// Loopback address used to connect to local server for peer with special lan
+func SpecialLanAddr(Peer netip.Addr) netip.Addr {
+	return mapToLocalIP4(Peer)
+}
+
+type LanEntry struct {
+	Local    netip.Addr     `json:"local"`
+	Peer     netip.AddrPort `json:"peer"`
+	LastSeen time.Time      `json:"last_seen"`
+}
+
+// Reverse table of special lan addresses
+type LanTable struct {
+	TTL       time.Duration // Remove entries without connection after this time, default is DefaultLanEntryTTL
+	entries   rwlock.Rwlock[map[netip.Addr]LanEntry]
+	lastPrune time.Time
+}
+
+func (table *LanTable) ttl() time.Duration {
+	if table.TTL <= 0 {
+		return DefaultLanEntryTTL
+	}
+	return table.TTL
+}
+
+// Register peer and return local address
+func (table *LanTable) Add(Peer netip.AddrPort) netip.Addr {
+	local := mapToLocalIP4(Peer.Addr())
+	entries, unlock := table.entries.Write()
+	defer unlock()
+	if *entries == nil {
+		*entries = map[netip.Addr]LanEntry{}
+	}
+	(*entries)[local] = LanEntry{Local: local, Peer: Peer, LastSeen: time.Now()}
+
+	if time.Since(table.lastPrune) > time.Minute {
+		table.lastPrune = time.Now()
+		for addr, entry := range *entries {
+			if time.Since(entry.LastSeen) > table.ttl() {
+				delete(*entries, addr)
+			}
+		}
+	}
+	return local
+}
+
+// Get real peer address from local 127.x.y.z address
+func (table *LanTable) Lookup(Local netip.Addr) (netip.AddrPort, bool) {
+	entry, ok := table.entry(Local)
+	return entry.Peer, ok
+}
+
+func (table *LanTable) entry(Local netip.Addr) (LanEntry, bool) {
+	entries, unlock := table.entries.Read()
+	defer unlock()
+	entry, ok := entries[Local.Unmap()]
+	return entry, ok
+}
+
+// Copy of all entries
+func (table *LanTable) Entries() []LanEntry {
+	entries, unlock := table.entries.Read()
+	defer unlock()
+	list := make([]LanEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	return list
+}
+
+// Return entry of ?ip=127.x.y.z as JSON, without ip return all entries
+func (table *LanTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query().Get("ip")
+	if query == "" {
+		json.NewEncoder(w).Encode(table.Entries())
+		return
+	}
+
+	local, err := netip.ParseAddr(query)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	entry, ok := table.entry(local)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "address not found"})
+		return
+	}
+	json.NewEncoder(w).Encode(entry)
+}
+
+// Serve SpecialLanTable in local http server until ctx is done, addr should be loopback like 127.0.0.1:8080
+func ServeLanTable(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: SpecialLanTable}
+	stop := context.AfterFunc(ctx, func() { server.Close() })
+	defer stop()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return ctx.Err()
 }
 
 func TcpSocket(SpecialLan bool, Peer, Host netip.AddrPort) (*net.TCPConn, error) {
 	isLoopback := Host.Addr().IsLoopback()
 	if isLoopback && SpecialLan {
-		local_ip := mapToLocalIP4(Peer.Addr().AsSlice());
-		stream, err := net.DialTCP("tcp4", net.TCPAddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom4([4]byte(local_ip.To4())), 0)), net.TCPAddrFromAddrPort(Host))
-		if err != nil {
-			LogDebug.Printf("Failed to establish connection using special lan %s for flow %s -> %s\n", local_ip, Peer.String(), Host.String())
-			return nil, err
+		local_ip := mapToLocalIP4(Peer.Addr())
+		stream, err := net.DialTCP("tcp4", net.TCPAddrFromAddrPort(netip.AddrPortFrom(local_ip, 0)), net.TCPAddrFromAddrPort(Host))
+		if err == nil {
+			SpecialLanTable.Add(Peer)
+			return stream, nil
 		}
-		return stream, nil
+		LogDebug.Printf("Failed to establish connection using special lan %s for flow %s -> %s\n", local_ip, Peer.String(), Host.String())
+		LogDebug.Printf("Failed to bind connection to special local address to support IP based banning")
 	}
-	LogDebug.Printf("Failed to bind connection to special local address to support IP based banning")
 	stream, err := net.DialTCP("tcp", nil, net.TCPAddrFromAddrPort(Host))
 	if err != nil {
 		LogDebug.Printf("Failed to establish connection for flow %s -> %s. Is your server running? %q", Peer.String(), Host.String(), err.Error())
@@ -64,21 +177,19 @@ func TcpSocket(SpecialLan bool, Peer, Host netip.AddrPort) (*net.TCPConn, error)
 func UdpSocket(SpecialLan bool, Peer, Host netip.AddrPort) (*net.UDPConn, error) {
 	isLoopback := Host.Addr().IsLoopback()
 	if isLoopback && SpecialLan {
-		local_ip := mapToLocalIP4(Peer.Addr().AsSlice());
-		local_port := 40000 + (Peer.Port() % 24000);
-		stream, err := net.DialUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom16([16]byte(local_ip)), local_port)), net.UDPAddrFromAddrPort(Host))
+		local_ip := mapToLocalIP4(Peer.Addr())
+		local_port := 40000 + (Peer.Port() % 24000)
+		stream, err := net.DialUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(local_ip, local_port)), net.UDPAddrFromAddrPort(Host))
 		if err != nil {
 			LogDebug.Printf("Failed to bind UDP port to %d to have connections survive agent restart: %s", local_port, err.Error())
-			stream, err = net.DialUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom16([16]byte(local_ip)), 0)), net.UDPAddrFromAddrPort(Host))
+			stream, err = net.DialUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(local_ip, 0)), net.UDPAddrFromAddrPort(Host))
 			if err != nil {
-				stream, err = net.DialUDP("udp4", nil, nil)
-				if err != nil {
-					return nil, err
-				}
 				LogDebug.Printf("Failed to bind UDP to special local address, in-game ip banning will not work: %s", err.Error())
+				return net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(Host))
 			}
 		}
+		SpecialLanTable.Add(Peer)
 		return stream, nil
 	}
 	return net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(Host))
-}
\ No newline at end of file
+}
@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestLanTableServeHTTP(t *testing.T) {
+	table := &LanTable{}
+	peer4, peer6 := netip.MustParseAddrPort("1.2.3.4:5000"), netip.MustParseAddrPort("[2001:db8::1]:6000")
+	local4, local6 := table.Add(peer4), table.Add(peer6)
+	if local4 != SpecialLanAddr(peer4.Addr()) || !local4.IsLoopback() || !local6.IsLoopback() {
+		t.Fatalf("local addresses %s and %s", local4, local6)
+	}
+
+	get := func(t *testing.T, query string, status int, body any) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		table.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+query, nil))
+		if rec.Code != status {
+			t.Fatalf("status %d, want %d: %s", rec.Code, status, rec.Body)
+		} else if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Fatalf("content type %q", contentType)
+		} else if err := json.NewDecoder(rec.Body).Decode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("all", func(t *testing.T) {
+		var entries []LanEntry
+		get(t, "", http.StatusOK, &entries)
+		if len(entries) != 2 {
+			t.Fatalf("%d entries", len(entries))
+		}
+		for _, entry := range entries {
+			if peer, ok := table.Lookup(entry.Local); !ok || peer != entry.Peer || entry.LastSeen.IsZero() {
+				t.Fatalf("entry %+v", entry)
+			}
+		}
+	})
+
+	t.Run("ip", func(t *testing.T) {
+		var entry LanEntry
+		get(t, "?ip="+local6.String(), http.StatusOK, &entry)
+		if entry.Local != local6 || entry.Peer != peer6 {
+			t.Fatalf("entry %+v", entry)
+		}
+		get(t, "?ip=::ffff:"+local4.String(), http.StatusOK, &entry)
+		if entry.Local != local4 || entry.Peer != peer4 {
+			t.Fatalf("mapped entry %+v", entry)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		var body map[string]string
+		get(t, "?ip=invalid", http.StatusBadRequest, &body)
+		if body["error"] == "" {
+			t.Fatalf("body %v", body)
+		}
+		body = nil
+		get(t, "?ip=127.0.0.1", http.StatusNotFound, &body)
+		if body["error"] != "address not found" {
+			t.Fatalf("body %v", body)
+		}
+	})
+}
+
+func TestServeLanTable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	peer := netip.MustParseAddrPort("198.51.100.7:25565")
+	local := SpecialLanTable.Add(peer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ServeLanTable(ctx, addr) }()
+
+	var entry LanEntry
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		res, err := http.Get("http://" + addr + "/?ip=" + local.String())
+		if err != nil {
+			if time.Since(start) > 5*time.Second {
+				t.Fatal(err)
+			}
+			continue
+		}
+		err = json.NewDecoder(res.Body).Decode(&entry)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	if entry.Local != local || entry.Peer != peer {
+		t.Fatalf("entry %+v", entry)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeLanTable not stopped after cancel")
+	}
+
+	// Listen error is returned as is
+	if err := ServeLanTable(context.Background(), "invalid address"); err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("expected listen error, got %v", err)
+	}
+}
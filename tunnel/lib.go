@@ -1,42 +1,34 @@
 package tunnel
 
 import (
-	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
+	"slices"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
 )
 
-type PortProto struct {
-	// 1 => "tcp"
-	//
-	// 2 => "udp"
-	//
-	// 3 => "both"
-	Value string
-}
+// Port type by wire id, 1 => "tcp", 2 => "udp" and 3 => "both"
+var portProtos = []api.PortType{1: api.PortTypeTcp, 2: api.PortTypeUdp, 3: api.PortTypeBoth}
 
-func (w *PortProto) WriteTo(I io.Writer) error {
-	switch w.Value {
-	case "tcp": return WriteU8(I, 1)
-	case "udp": return WriteU8(I, 2)
-	case "both": return WriteU8(I, 3)
+func WritePortProto(I io.Writer, proto api.PortType) error {
+	if id := slices.Index(portProtos, proto); id > 0 {
+		return WriteU8(I, uint8(id))
 	}
 	return fmt.Errorf("set valid proto")
 }
-func (w *PortProto) ReadFrom(I io.Reader) error {
-	switch ReadU8(I) {
-	case 1:
-		w.Value = "tcp"
-		return nil
-	case 2:
-		w.Value = "udp"
-		return nil
-	case 3:
-		w.Value = "both"
-		return nil
+
+func (dec *Decoder) PortProto() api.PortType {
+	id := dec.U8()
+	if dec.err != nil {
+		return ""
+	} else if int(id) >= len(portProtos) || portProtos[id] == "" {
+		dec.Fail(fmt.Errorf("invalid proto"))
+		return ""
 	}
-	return fmt.Errorf("invalid proto")
+	return portProtos[id]
 }
 
 type AgentSessionId struct {
@@ -56,30 +48,39 @@ func (w *AgentSessionId) WriteTo(I io.Writer) error {
 	return nil
 }
 func (w *AgentSessionId) ReadFrom(I io.Reader) error {
-	w.SessionID, w.AccountID, w.AgentID = ReadU64(I), ReadU64(I), ReadU64(I)
-	return nil
+	dec := NewDecoder(I)
+	w.SessionID, w.AccountID, w.AgentID = dec.U64(), dec.U64(), dec.U64()
+	return dec.Err()
 }
 
 type PortRange struct {
 	IP        net.IP
 	PortStart uint16
 	PortEnd   uint16
-	PortProto PortProto
+	PortProto api.PortType
 }
 
 func (w *PortRange) WriteTo(I io.Writer) error {
-	if err := binary.Write(I, binary.BigEndian, w.IP); err != nil {
+	ip, ok := netip.AddrFromSlice(w.IP)
+	if !ok {
+		return fmt.Errorf("invalid port range ip")
+	} else if err := WriteIp(I, ip.Unmap()); err != nil {
 		return err
 	} else if err := WriteU16(I, w.PortStart); err != nil {
 		return err
 	} else if err := WriteU16(I, w.PortEnd); err != nil {
 		return err
-	} else if err := w.PortProto.WriteTo(I); err != nil {
+	} else if err := WritePortProto(I, w.PortProto); err != nil {
 		return err
 	}
 	return nil
 }
 func (w *PortRange) ReadFrom(I io.Reader) error {
-
-	return nil
+	dec := NewDecoder(I)
+	ip := dec.Ip()
+	w.PortStart, w.PortEnd, w.PortProto = dec.U16(), dec.U16(), dec.PortProto()
+	if dec.Err() == nil {
+		w.IP = net.IP(ip.AsSlice())
+	}
+	return dec.Err()
 }
\ No newline at end of file
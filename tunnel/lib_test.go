@@ -0,0 +1,75 @@
+package tunnel
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+func TestPortRange(t *testing.T) {
+	for _, proto := range api.PortTypes {
+		roundTrip(t, &PortRange{IP: testPortRange.IP, PortStart: 25565, PortEnd: 25565, PortProto: proto}, &PortRange{})
+		roundTrip(t, &PortRange{IP: net.ParseIP("2602:fbaf::1"), PortStart: 1, PortEnd: 2, PortProto: proto}, &PortRange{})
+	}
+
+	// 16 bytes ipv4 is written as ipv4
+	mapped := testPortRange
+	mapped.IP = net.IPv4(147, 185, 221, 1)
+	if body, want := encode(t, &mapped), encode(t, &testPortRange); string(body) != string(want) {
+		t.Errorf("mapped ipv4 encoded to %x, want %x", body, want)
+	}
+
+	// Proto byte is last byte of port range
+	body := encode(t, &testPortRange)
+	for _, proto := range []byte{0, 4, 0xff} {
+		body[len(body)-1] = proto
+		if err := DecodeMessage(body, &PortRange{}); err == nil {
+			t.Errorf("decoded port range with proto %d", proto)
+		}
+	}
+
+	// Failed decode don't change ip
+	var decoded PortRange
+	if err := DecodeMessage(body, &decoded); err == nil || decoded.IP != nil {
+		t.Errorf("set ip %s with error %v", decoded.IP, err)
+	}
+
+	for _, invalid := range []PortRange{
+		{IP: testPortRange.IP, PortProto: "icmp"},
+		{IP: testPortRange.IP},
+		{IP: net.IP{1, 2, 3}, PortProto: api.PortTypeTcp},
+	} {
+		if err := invalid.WriteTo(&discard{}); err == nil {
+			t.Errorf("encoded invalid port range %s", dump(invalid))
+		}
+	}
+}
+
+func TestAgentSessionId(t *testing.T) {
+	roundTrip(t, &AgentSessionId{SessionID: 1<<64 - 1, AccountID: 1, AgentID: 1 << 32}, &AgentSessionId{})
+	checkGolden(t, "keep_alive", requestRpc(4, ControlRequest{AgentKeepAlive: &testSession}), newRequestRpc)
+}
+
+func TestAddressPort(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", "203.0.113.7:49152", "[::]:1", "[2001:db8::7]:65535"} {
+		roundTrip(t, &AddressPort{netip.MustParseAddrPort(addr)}, &AddressPort{})
+	}
+	if err := (&AddressPort{}).WriteTo(&discard{}); err == nil {
+		t.Error("encoded invalid address")
+	}
+	for _, body := range [][]byte{{5, 1, 2, 3, 4, 0, 1}, {0}, {4, 1, 2, 3, 4, 0}} {
+		if err := DecodeMessage(body, &AddressPort{}); err == nil {
+			t.Errorf("decoded address from %x", body)
+		}
+	}
+}
+
+func FuzzPortRange(f *testing.F) {
+	fuzzMessage(f, func() MessageEncoding { return &PortRange{} }, encode(f, &testPortRange), readGolden(f, "feed_port_mapping")[16:26])
+}
+
+func FuzzAddressPort(f *testing.F) {
+	fuzzMessage(f, func() MessageEncoding { return &AddressPort{} }, encode(f, &testClientAddr), readGolden(f, "feed_pong")[44:63])
+}
@@ -0,0 +1,143 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+// Public address of tunnel
+type TunnelAddr struct {
+	Proto  string // tcp or udp
+	Domain string
+	Port   uint16
+}
+
+func (addr *TunnelAddr) Network() string { return addr.Proto }
+func (addr *TunnelAddr) String() string {
+	return net.JoinHostPort(addr.Domain, strconv.Itoa(int(addr.Port)))
+}
+
+// Connection claimed from tunnel server with real client address
+type TunnelConn struct {
+	*net.TCPConn
+	Client NewClient
+}
+
+func (conn *TunnelConn) RemoteAddr() net.Addr {
+	return net.TCPAddrFromAddrPort(conn.Client.PeerAddr.AddrPort)
+}
+func (conn *TunnelConn) LocalAddr() net.Addr {
+	return net.TCPAddrFromAddrPort(conn.Client.ConnectAddr.AddrPort)
+}
+
+// net.Listener to TCP clients of one agent tunnel
+type TcpListener struct {
+	Tunnel      SimplesTunnel
+	AgentTunnel api.AgentTunnel
+
+	conns     chan net.Conn
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	closeOnce sync.Once
+}
+
+// Find agent tunnel by ID and check if support proto
+func findAgentTunnel(ctx context.Context, Api api.Client, TunnelID uuid.UUID, proto api.PortType) (*api.AgentTunnel, error) {
+	agent, err := Api.AgentInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index := slices.IndexFunc(agent.Tunnels, func(tun api.AgentTunnel) bool { return tun.ID == TunnelID })
+	if index == -1 {
+		return nil, fmt.Errorf("tunnel %s not found in agent", TunnelID.String())
+	} else if tun := agent.Tunnels[index]; !tun.Proto.Has(proto) {
+		return nil, fmt.Errorf("tunnel %s not support %s", TunnelID.String(), proto)
+	}
+	return &agent.Tunnels[index], nil
+}
+
+func tunnelAddr(tun api.AgentTunnel, proto api.PortType) *TunnelAddr {
+	domain := tun.AssignedDomain
+	if tun.CustomDomain != "" {
+		domain = tun.CustomDomain
+	}
+	return &TunnelAddr{Proto: proto.String(), Domain: domain, Port: tun.Port.From}
+}
+
+// Listen TCP clients from agent tunnel, connections are returned by Accept
+// until ctx is done or Listener closed
+func Listen(ctx context.Context, Api api.Client, TunnelID uuid.UUID) (net.Listener, error) {
+	agentTunnel, err := findAgentTunnel(ctx, Api, TunnelID, api.PortTypeTcp)
+	if err != nil {
+		return nil, err
+	}
+
+	ln := &TcpListener{
+		Tunnel:      SimplesTunnel{ApiClaim: Api},
+		AgentTunnel: *agentTunnel,
+		conns:       make(chan net.Conn),
+	}
+	if err := ln.Tunnel.Setup(ctx); err != nil {
+		return nil, err
+	}
+
+	ln.ctx, ln.cancel = context.WithCancelCause(ctx)
+	go func() {
+		err := ln.Tunnel.Serve(ln.ctx, ln.claim)
+		ln.cancel(err)
+		ln.Tunnel.Close()
+	}()
+	return ln, nil
+}
+
+func (ln *TcpListener) claim(client NewClient) {
+	if !agentTunnelMatches(ln.AgentTunnel, client.ConnectAddr.AddrPort, api.PortTypeTcp) {
+		LogDebug.Printf("ignoring client %s to %s, not from tunnel %s\n", client.PeerAddr.AddrPort.String(), client.ConnectAddr.AddrPort.String(), ln.AgentTunnel.ID.String())
+		return
+	}
+
+	go func() {
+		stream, err := (&TcpTunnel{client.ClaimInstructions}).Connect()
+		if err != nil {
+			LogDebug.Printf("tcp client %s: %s\n", client.PeerAddr.AddrPort.String(), err.Error())
+			return
+		}
+		select {
+		case ln.conns <- &TunnelConn{TCPConn: stream, Client: client}:
+		case <-ln.ctx.Done():
+			stream.Close()
+		}
+	}()
+}
+
+func (ln *TcpListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case <-ln.ctx.Done():
+		if err := context.Cause(ln.ctx); !errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+func (ln *TcpListener) Close() error {
+	ln.closeOnce.Do(func() {
+		ln.cancel(nil)
+		ln.Tunnel.Close()
+	})
+	return nil
+}
+
+func (ln *TcpListener) Addr() net.Addr {
+	return tunnelAddr(ln.AgentTunnel, api.PortTypeTcp)
+}
@@ -0,0 +1,122 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// HAProxy PROXY protocol header sent to local server
+type ProxyProtocol uint8
+
+const (
+	ProxyProtocolNone ProxyProtocol = iota // Not send header
+	ProxyProtocolV1                        // Text header, only TCP, UDP flows use V2
+	ProxyProtocolV2                        // Binary header
+)
+
+const (
+	proxyV2Stream byte = 0x1
+	proxyV2Dgram  byte = 0x2
+)
+
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+func (proxy ProxyProtocol) String() string {
+	switch proxy {
+	case ProxyProtocolNone:
+		return "none"
+	case ProxyProtocolV1:
+		return "v1"
+	case ProxyProtocolV2:
+		return "v2"
+	}
+	return fmt.Sprintf("ProxyProtocol(%d)", uint8(proxy))
+}
+
+func (proxy ProxyProtocol) MarshalText() ([]byte, error) {
+	return []byte(proxy.String()), nil
+}
+
+func (proxy *ProxyProtocol) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "", "none":
+		*proxy = ProxyProtocolNone
+	case "v1":
+		*proxy = ProxyProtocolV1
+	case "v2":
+		*proxy = ProxyProtocolV2
+	default:
+		return fmt.Errorf("invalid proxy protocol %q", string(text))
+	}
+	return nil
+}
+
+// Convert both address to same family, if one is IPv6 use IPv4-mapped IPv6
+func proxyAddrs(src, dst netip.AddrPort) (netip.AddrPort, netip.AddrPort, bool) {
+	srcIp, dstIp := src.Addr().Unmap(), dst.Addr().Unmap()
+	if srcIp.Is4() && dstIp.Is4() {
+		return netip.AddrPortFrom(srcIp, src.Port()), netip.AddrPortFrom(dstIp, dst.Port()), true
+	}
+	return netip.AddrPortFrom(netip.AddrFrom16(srcIp.As16()), src.Port()), netip.AddrPortFrom(netip.AddrFrom16(dstIp.As16()), dst.Port()), false
+}
+
+// Text header "PROXY TCP4 src dst srcPort dstPort\r\n"
+func ProxyV1Header(src, dst netip.AddrPort) []byte {
+	src, dst, is4 := proxyAddrs(src, dst)
+	family := "TCP6"
+	if is4 {
+		family = "TCP4"
+	}
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, src.Addr().String(), dst.Addr().String(), src.Port(), dst.Port())
+}
+
+// Binary header with PROXY command, stream is TCP else UDP
+func ProxyV2Header(stream bool, src, dst netip.AddrPort) []byte {
+	src, dst, is4 := proxyAddrs(src, dst)
+	transport := proxyV2Dgram
+	if stream {
+		transport = proxyV2Stream
+	}
+
+	family, addrLen := byte(0x20), 36
+	if is4 {
+		family, addrLen = 0x10, 12
+	}
+
+	header := make([]byte, 0, 16+addrLen)
+	header = append(header, proxyV2Signature...)
+	header = append(header, 0x21, family|transport)
+	header = binary.BigEndian.AppendUint16(header, uint16(addrLen))
+	header = append(header, src.Addr().AsSlice()...)
+	header = append(header, dst.Addr().AsSlice()...)
+	header = binary.BigEndian.AppendUint16(header, src.Port())
+	header = binary.BigEndian.AppendUint16(header, dst.Port())
+	return header
+}
+
+// Write TCP header to local server, src is peer address and dst the tunnel address
+func (proxy ProxyProtocol) WriteHeader(w io.Writer, src, dst netip.AddrPort) error {
+	var header []byte
+	switch proxy {
+	case ProxyProtocolNone:
+		return nil
+	case ProxyProtocolV1:
+		header = ProxyV1Header(src, dst)
+	case ProxyProtocolV2:
+		header = ProxyV2Header(true, src, dst)
+	default:
+		return fmt.Errorf("invalid proxy protocol %d", uint8(proxy))
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+// Header to prepend in every datagram of flow, nil if proxy is disabled
+func (proxy ProxyProtocol) DgramHeader(src, dst netip.AddrPort) []byte {
+	if proxy == ProxyProtocolNone {
+		return nil
+	}
+	return ProxyV2Header(false, src, dst)
+}
@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"testing"
+)
+
+var (
+	testProxy4Src = netip.MustParseAddrPort("198.51.100.20:53211")
+	testProxy4Dst = netip.MustParseAddrPort("147.185.221.16:25565")
+	testProxy6Src = netip.MustParseAddrPort("[2001:db8::7]:40000")
+	testProxy6Dst = netip.MustParseAddrPort("[2602:fbaf:0:5::2a]:25565")
+)
+
+func TestProxyV1Header(t *testing.T) {
+	for _, test := range []struct {
+		src, dst netip.AddrPort
+		want     string
+	}{
+		{testProxy4Src, testProxy4Dst, "PROXY TCP4 198.51.100.20 147.185.221.16 53211 25565\r\n"},
+		{testProxy6Src, testProxy6Dst, "PROXY TCP6 2001:db8::7 2602:fbaf:0:5::2a 40000 25565\r\n"},
+		{testProxy4Src, testProxy6Dst, "PROXY TCP6 ::ffff:198.51.100.20 2602:fbaf:0:5::2a 53211 25565\r\n"},
+		{netip.MustParseAddrPort("[::ffff:198.51.100.20]:53211"), testProxy4Dst, "PROXY TCP4 198.51.100.20 147.185.221.16 53211 25565\r\n"},
+	} {
+		if header := string(ProxyV1Header(test.src, test.dst)); header != test.want {
+			t.Errorf("%s -> %s: %q, want %q", test.src, test.dst, header, test.want)
+		}
+	}
+}
+
+func TestProxyV2Header(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		stream   bool
+		src, dst netip.AddrPort
+	}{
+		{"proxy_v2_stream_v4", true, testProxy4Src, testProxy4Dst},
+		{"proxy_v2_dgram_v4", false, testProxy4Src, testProxy4Dst},
+		{"proxy_v2_stream_v6", true, testProxy6Src, testProxy6Dst},
+		{"proxy_v2_dgram_v6", false, testProxy6Src, testProxy6Dst},
+		{"proxy_v2_stream_mixed", true, testProxy4Src, testProxy6Dst},
+	} {
+		if header, golden := ProxyV2Header(test.stream, test.src, test.dst), readGolden(t, test.name); !bytes.Equal(header, golden) {
+			t.Errorf("%s: header\n%x\nwant\n%x", test.name, header, golden)
+		}
+	}
+}
+
+func TestProxyProtocolWriteHeader(t *testing.T) {
+	for proxy, want := range map[ProxyProtocol][]byte{
+		ProxyProtocolNone: nil,
+		ProxyProtocolV1:   ProxyV1Header(testProxy4Src, testProxy4Dst),
+		ProxyProtocolV2:   readGolden(t, "proxy_v2_stream_v4"),
+	} {
+		var buff bytes.Buffer
+		if err := proxy.WriteHeader(&buff, testProxy4Src, testProxy4Dst); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buff.Bytes(), want) {
+			t.Errorf("%s: wrote %x, want %x", proxy, buff.Bytes(), want)
+		}
+	}
+	if header := ProxyProtocolV1.DgramHeader(testProxy4Src, testProxy4Dst); !bytes.Equal(header, readGolden(t, "proxy_v2_dgram_v4")) {
+		t.Errorf("v1 dgram header %x, udp flows always use v2", header)
+	} else if ProxyProtocolNone.DgramHeader(testProxy4Src, testProxy4Dst) != nil {
+		t.Error("dgram header without proxy protocol")
+	}
+
+	var override MappingOverride
+	if err := json.Unmarshal([]byte(`{"proxy_protocol":"v2"}`), &override); err != nil || override.ProxyProtocol != ProxyProtocolV2 {
+		t.Fatalf("decoded %s: %v", override.ProxyProtocol, err)
+	} else if err := json.Unmarshal([]byte(`{"proxy_protocol":"v3"}`), &override); err == nil {
+		t.Fatal("decoded invalid proxy protocol")
+	}
+}
@@ -0,0 +1,117 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+const DefaultMappingPoll time.Duration = time.Second * 5 // Default interval to check mapping file changes
+
+// Lookup that can be replaced while tunnel is running,
+// new connections use new lookup and open connections keep their local address
+type ReloadableLookup struct {
+	current atomic.Pointer[AddressLookup[netip.AddrPort]]
+}
+
+// Replace lookup
+func (look *ReloadableLookup) Set(lookup AddressLookup[netip.AddrPort]) {
+	look.current.Store(&lookup)
+}
+
+// Current lookup, nil if not set
+func (look *ReloadableLookup) Get() AddressLookup[netip.AddrPort] {
+	if current := look.current.Load(); current != nil {
+		return *current
+	}
+	return nil
+}
+
+func (look *ReloadableLookup) Lookup(IpPort netip.AddrPort, Proto api.PortType) *AddressValue[netip.AddrPort] {
+	current := look.Get()
+	if current == nil {
+		return nil
+	}
+	return current.Lookup(IpPort, Proto)
+}
+
+// Load overrides from JSON file with array of MappingOverride,
+// rules with region_id must set IPv6 IP or Prefix because IPv4 addresses not have region
+func LoadMappingFile(path string) (*LookupWithOverrides, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var overrides LookupWithOverrides
+	if err := json.NewDecoder(file).Decode(&overrides); err != nil {
+		return nil, err
+	}
+	for index, override := range overrides {
+		if !override.Proto.Valid() {
+			return nil, fmt.Errorf("%s: mapping %d: invalid proto %q", path, index, override.Proto)
+		} else if override.MatchIP.RegionID != nil && !override.MatchIP.ipv6Only() {
+			// Agent tunnels match IPv4 in any region, rule would be ignored to most traffic
+			return nil, fmt.Errorf("%s: mapping %d: region_id never match IPv4 addresses, set IPv6 ip or prefix", path, index)
+		}
+	}
+	return &overrides, nil
+}
+
+// Check mapping file every interval and call set when file changed, invalid files are logged and ignored.
+// Return when ctx is done
+func WatchMappingFile(ctx context.Context, path string, interval time.Duration, set func(*LookupWithOverrides)) error {
+	if interval <= 0 {
+		interval = DefaultMappingPoll
+	}
+
+	var lastMod time.Time
+	var lastSize int64 = -1
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if info, err := os.Stat(path); err != nil {
+			LogDebug.Printf("mapping file %s: %s\n", path, err.Error())
+		} else if !info.ModTime().Equal(lastMod) || info.Size() != lastSize {
+			lastMod, lastSize = info.ModTime(), info.Size()
+			if overrides, err := LoadMappingFile(path); err != nil {
+				LogDebug.Printf("mapping file %s: %s\n", path, err.Error())
+			} else {
+				LogDebug.Printf("mapping file %s loaded with %d overrides\n", path, len(*overrides))
+				set(overrides)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Call reload on every SIGHUP until ctx is done, errors are logged
+func ReloadOnSignal(ctx context.Context, reload func() error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := reload(); err != nil {
+				LogDebug.Printf("reload failed: %s\n", err.Error())
+			}
+		}
+	}
+}
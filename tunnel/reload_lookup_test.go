@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+)
+
+func TestWatchMappingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mapping := func(local string) string {
+		return `[{"match":{},"proto":"tcp","port":{"from":25565,"to":25566},"local_addr":"` + local + `"}]`
+	}
+
+	var look ReloadableLookup
+	loaded := make(chan *LookupWithOverrides, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	write(mapping("127.0.0.1:1000"))
+	go func() {
+		done <- WatchMappingFile(ctx, path, 10*time.Millisecond, func(overrides *LookupWithOverrides) {
+			look.Set(overrides)
+			loaded <- overrides
+		})
+	}()
+
+	connect := netip.MustParseAddrPort("147.185.221.42:25565")
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case <-loaded:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("mapping file with %s not loaded", want)
+		}
+		if got := look.Lookup(connect, api.PortTypeTcp).Value; got != netip.MustParseAddrPort(want) {
+			t.Fatalf("lookup to %s, want %s", got, want)
+		}
+	}
+	expect("127.0.0.1:1000")
+
+	write(mapping("127.0.0.1:20000"))
+	expect("127.0.0.1:20000")
+
+	// Invalid files keep last table
+	for _, body := range []string{`[{"match":`, `[{"match":{},"proto":"quic","local_addr":"127.0.0.1:4000"}]`} {
+		write(body)
+		select {
+		case overrides := <-loaded:
+			t.Fatalf("invalid file %s loaded: %+v", body, overrides)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if got := look.Lookup(connect, api.PortTypeTcp).Value; got != netip.MustParseAddrPort("127.0.0.1:20000") {
+			t.Fatalf("lookup to %s after invalid file", got)
+		}
+	}
+
+	write(mapping("127.0.0.1:3000"))
+	expect("127.0.0.1:3000")
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	// Keep SIGHUP from stopping test before ReloadOnSignal is listening
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reloads := make(chan struct{}, 8)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ReloadOnSignal(ctx, func() error {
+			reloads <- struct{}{}
+			return errors.New("logged and ignored")
+		})
+	}()
+
+	for reloaded := 0; reloaded < 2; {
+		if err := process.Signal(syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-reloads:
+			reloaded++
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReloadOnSignal not stopped after cancel")
+	}
+}
@@ -27,10 +27,12 @@ func (w *ControlRpcMessage[T]) WriteTo(I io.Writer) error {
 }
 
 func (w *ControlRpcMessage[T]) ReadFrom(I io.Reader) error {
-	w.RequestID = ReadU64(I)
+	dec := NewDecoder(I)
+	w.RequestID = dec.U64()
 	defer func() {
 		d, _ := json.MarshalIndent(w, "", "  ")
 		LogDebug.Printf("Read RPC: %s\n", string(d))
 	}()
-	return w.Content.ReadFrom(I)
+	dec.Decode(w.Content)
+	return dec.Err()
 }
@@ -0,0 +1,133 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Golden files are hex bytes in rust agent wire format, text after "#" is comment
+func readGolden(t testing.TB, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name+".hex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	for _, line := range strings.Split(string(body), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		text.WriteString(strings.Join(strings.Fields(line), ""))
+	}
+	data, err := hex.DecodeString(text.String())
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	return data
+}
+
+func encode(t testing.TB, msg MessageEncoding) []byte {
+	t.Helper()
+	var buff bytes.Buffer
+	if err := msg.WriteTo(&buff); err != nil {
+		t.Fatalf("encode %T: %s", msg, err)
+	}
+	return buff.Bytes()
+}
+
+func dump(msg any) string {
+	body, _ := json.Marshal(msg)
+	return string(body)
+}
+
+// Encode msg, decode into out and compare with msg
+func roundTrip(t *testing.T, msg, out MessageEncoding) {
+	t.Helper()
+	body := encode(t, msg)
+	if err := DecodeMessage(body, out); err != nil {
+		t.Fatalf("decode %T from %x: %s", out, body, err)
+	} else if !reflect.DeepEqual(msg, out) {
+		t.Fatalf("%T round trip\n got: %s\nwant: %s", msg, dump(out), dump(msg))
+	}
+}
+
+// Check msg encode to golden bytes and golden bytes decode to msg,
+// every truncated golden must fail to decode
+func checkGolden(t *testing.T, name string, msg MessageEncoding, newMsg func() MessageEncoding) {
+	t.Helper()
+	golden := readGolden(t, name)
+	if body := encode(t, msg); !bytes.Equal(body, golden) {
+		t.Errorf("%s: encoded\n%x\nwant\n%x", name, body, golden)
+	}
+
+	out := newMsg()
+	if err := DecodeMessage(golden, out); err != nil {
+		t.Fatalf("%s: decode: %s", name, err)
+	} else if !reflect.DeepEqual(msg, out) {
+		t.Fatalf("%s: decoded\n got: %s\nwant: %s", name, dump(out), dump(msg))
+	}
+
+	for size := range golden {
+		if err := DecodeMessage(golden[:size], newMsg()); err == nil {
+			t.Fatalf("%s: decoded truncated message with %d of %d bytes", name, size, len(golden))
+		}
+	}
+}
+
+// Decode fuzz data, decoded message must encode and decode again to same bytes
+func fuzzMessage(f *testing.F, newMsg func() MessageEncoding, seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := newMsg()
+		if DecodeMessage(data, msg) != nil {
+			return
+		}
+		first := encode(t, msg)
+		again := newMsg()
+		if err := DecodeMessage(first, again); err != nil {
+			t.Fatalf("decode re-encoded %x: %s", first, err)
+		} else if second := encode(t, again); !bytes.Equal(first, second) {
+			t.Fatalf("encoding not stable\nfirst:  %x\nsecond: %x", first, second)
+		}
+	})
+}
+
+func newRequestRpc() MessageEncoding {
+	return &ControlRpcMessage[*ControlRequest]{Content: &ControlRequest{}}
+}
+
+func newFeed() MessageEncoding {
+	return &ControlFeed{}
+}
+
+func TestControlRpcMessage(t *testing.T) {
+	roundTrip(t, &ControlRpcMessage[*ControlRequest]{RequestID: 1 << 63, Content: &ControlRequest{AgentKeepAlive: &testSession}}, newRequestRpc())
+	roundTrip(t, &ControlRpcMessage[*RawSlice]{RequestID: 10, Content: &RawSlice{Buff: []byte{1, 2, 3}}}, &ControlRpcMessage[*RawSlice]{Content: &RawSlice{Buff: make([]byte, 3)}})
+}
+
+func TestDecodeMessage(t *testing.T) {
+	for _, name := range []string{"ping", "agent_register", "check_port_mapping"} {
+		if err := DecodeMessage(append(readGolden(t, name), 0), newRequestRpc()); !errors.Is(err, ErrTrailingData) {
+			t.Errorf("%s: expected ErrTrailingData, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"feed_pong", "feed_new_client", "feed_udp_channel_details"} {
+		if err := DecodeMessage(append(readGolden(t, name), 0), newFeed()); !errors.Is(err, ErrTrailingData) {
+			t.Errorf("%s: expected ErrTrailingData, got %v", name, err)
+		}
+	}
+	if err := DecodeMessage(make([]byte, MaxMessageLen+1), newFeed()); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	if err := DecodeMessage(nil, newFeed()); !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
@@ -1,94 +1,219 @@
 package tunnel
 
 import (
+	"context"
+	"fmt"
 	"net/netip"
-	"os"
-	"os/signal"
-	"sync/atomic"
+	"sync"
 	"time"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
 )
 
+const DefaultGracePeriod time.Duration = time.Second * 10 // Default time to wait relays end after stop runner
+
 type TunnelRunner struct {
-	Lookup      AddressLookup[netip.AddrPort]
-	Tunnel      SimplesTunnel
-	KeepRunning atomic.Bool
+	Lookup         AddressLookup[netip.AddrPort] // Local address resolver, if nil Run use AgentLookup with tunnels from API
+	Tunnel         SimplesTunnel
+	UdpFlowTimeout time.Duration // Close idle udp flows after this time, default is DefaultUdpFlowTimeout
+	UdpMaxFlows    int           // Max udp flows open, default is DefaultUdpMaxFlows
+	GracePeriod    time.Duration // Time to wait in-flight relays after stop, default is DefaultGracePeriod
+	SpecialLan     bool          // Connect to loopback servers from 127.x.y.z mapped from peer ip, see SpecialLanTable
+
+	relays sync.WaitGroup
+	lookup ReloadableLookup
 }
 
-func (tun *TunnelRunner) UseSpecialLan(set bool) {
-	panic("no implemented UseSpecialLan")
+// Replace lookup while running, new connections use new lookup and open relays keep their local server
+func (tun *TunnelRunner) SetLookup(lookup AddressLookup[netip.AddrPort]) {
+	tun.lookup.Set(lookup)
+}
+
+// Load mapping file and replace lookup, can be used with ReloadOnSignal
+func (tun *TunnelRunner) LoadMappingFile(path string) error {
+	overrides, err := LoadMappingFile(path)
+	if err != nil {
+		return err
+	}
+	tun.SetLookup(overrides)
+	return nil
 }
 
-func (tun *TunnelRunner) Run() error {
-	channel := make(chan error)
-	// udp := tun.Tunnel.UdpTunnel
+// Lookup in use, SetLookup value or Lookup field
+func (tun *TunnelRunner) currentLookup() AddressLookup[netip.AddrPort] {
+	if lookup := tun.lookup.Get(); lookup != nil {
+		return lookup
+	} else if tun.Lookup != nil {
+		return tun.Lookup
+	}
+	return &LookupWithOverrides{}
+}
 
+// Bind local connections to 127.x.y.z address of peer, so servers can ban by ip
+func (tun *TunnelRunner) UseSpecialLan(set bool) {
+	tun.SpecialLan = set
+}
+
+// Run tunnel until ctx is done or control channel fail.
+//
+// After stop close control channel and wait in-flight tcp relays and udp flows by GracePeriod,
+// return error that stopped runner, if ctx is done return context cause
+func (tun *TunnelRunner) Run(ctx context.Context) error {
 	// Setup Tunnel
-	if err := tun.Tunnel.Setup(); err != nil {
+	if err := tun.Tunnel.Setup(ctx); err != nil {
 		return err
 	}
 	LogDebug.Println("Success Tunnel setup")
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Close connections after grace period
+	relayCtx, forceClose := context.WithCancel(context.Background())
+	defer forceClose()
+
+	if tun.lookup.Get() == nil {
+		if tun.Lookup != nil {
+			tun.SetLookup(tun.Lookup)
+		} else {
+			look := &AgentLookup{Api: tun.Tunnel.ApiClaim, Fallback: &LookupWithOverrides{}}
+			if err := look.Reload(ctx); err != nil {
+				LogDebug.Printf("failed to load agent tunnels: %s\n", err.Error())
+			}
+			go look.Run(ctx)
+			tun.SetLookup(look)
+		}
+	}
+
+	udpClients := &UdpClients{
+		Tunnel:     &tun.Tunnel.UdpTunnel,
+		Lookup:     &tun.lookup,
+		Timeout:    tun.UdpFlowTimeout,
+		MaxFlows:   tun.UdpMaxFlows,
+		SpecialLan: tun.SpecialLan,
+	}
+
+	var loops sync.WaitGroup
+	loops.Add(2)
 	go func() {
-		<-c
-		LogDebug.Println("Cleaning process")
-		tun.KeepRunning.Store(false)
-		tun.Tunnel.ControlChannel.Conn.Udp.Close()
-		os.Exit(1)
+		defer loops.Done()
+		if err := tun.controlLoop(ctx, relayCtx); err != nil {
+			cancel(err)
+		}
+	}()
+	go func() {
+		defer loops.Done()
+		tun.udpLoop(ctx, udpClients)
 	}()
 
-	// TCP Clients
+	<-ctx.Done()
+	LogDebug.Println("Stopping tunnel")
+
+	// Stop udp reader without close socket, open flows still reply to tunnel while draining
+	tun.Tunnel.UdpTunnel.SetReadDeadline(time.Now())
+	loops.Wait()
+	if tun.Tunnel.ControlChannel != nil {
+		tun.Tunnel.ControlChannel.Close()
+	}
+
+	gracePeriod := tun.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultGracePeriod
+	}
+	drained := make(chan struct{})
 	go func() {
-		lastControlUpdate := time.Now().UnixMilli()
-		defer os.Exit(2)
-		for tun.KeepRunning.Load() {
-			now := time.Now().UnixMilli()
-			if 30_000 < now-lastControlUpdate {
-				lastControlUpdate = now
-				LogDebug.Println("Reloading control addr")
-				if _, err := tun.Tunnel.ReloadControlAddr(); err != nil {
-					LogDebug.Println("failed to reload control addr")
-					LogDebug.Println(err)
-					return
-				}
+		tun.relays.Wait()
+		udpClients.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(gracePeriod):
+		LogDebug.Println("grace period ended, closing tcp relays and udp flows")
+		forceClose()
+		udpClients.Close()
+		<-drained
+	}
+	tun.Tunnel.UdpTunnel.Close()
+
+	return context.Cause(ctx)
+}
+
+// Process control messages and start TCP clients
+func (tun *TunnelRunner) controlLoop(ctx, relayCtx context.Context) error {
+	return tun.Tunnel.Serve(ctx, func(newClient NewClient) {
+		tun.relays.Add(1)
+		go func() {
+			defer tun.relays.Done()
+			if err := tun.TcpClient(relayCtx, newClient); err != nil {
+				LogDebug.Printf("tcp client %s: %s\n", newClient.PeerAddr.AddrPort.String(), err.Error())
 			}
+		}()
+	})
+}
 
-			newClient, err := tun.Tunnel.Update()
-			if err != nil {
-				LogDebug.Println(err.Error())
-				channel <- err
-				return
-			} else if newClient == nil {
+// Read packets from udp tunnel and forward to local servers
+func (tun *TunnelRunner) udpLoop(ctx context.Context, clients *UdpClients) {
+	udp := &tun.Tunnel.UdpTunnel
+	buffer := make([]byte, 2048)
+	for ctx.Err() == nil {
+		rx, err := udp.ReceiveFrom(buffer)
+		if err != nil {
+			if !udp.IsSetup() {
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
 				continue
+			} else if ctx.Err() == nil {
+				LogDebug.Println(err)
 			}
-			LogDebug.Println("tcp client")
-			LogDebug.Printf("%#v\n", newClient)
-			LogDebug.Panic(newClient)
+			continue
+		} else if rx.ConfirmerdConnection {
+			continue
 		}
-	}()
 
-	// go func() {
-	// 	buffer := make([]byte, 2048)
-	// 	had_success := false
-	// 	for tun.KeepRunning.Load() {
-	// 		LogDebug.Println("rec udp tun")
-	// 		rx, err := udp.ReceiveFrom(buffer)
-	// 		if err != nil {
-	// 			LogDebug.Println(err)
-	// 			if had_success {
-	// 				LogDebug.Panicln("got error")
-	// 			}
-	// 			time.Sleep(time.Second)
-	// 			continue
-	// 		}
-	// 		LogDebug.Println("success")
-	// 		had_success = true
-	// 		if rx.ConfirmerdConnection {
-	// 			continue
-	// 		}
-	// 		LogDebug.Printf("%#v\n", rx.ReceivedPacket)
-	// 	}
-	// }()
-	return <-channel
+		packet := rx.ReceivedPacket
+		if err := clients.ForwardPacket(packet.Flow, buffer[:packet.Bytes]); err != nil {
+			LogDebug.Println(err)
+		}
+	}
+}
+
+// Resolve local server, claim client from tunnel server and relay bytes to local server
+//
+// if ctx is done connections are closed
+func (tun *TunnelRunner) TcpClient(ctx context.Context, client NewClient) error {
+	found := tun.currentLookup().Lookup(client.ConnectAddr.AddrPort, api.PortTypeTcp)
+	if found == nil {
+		return fmt.Errorf("could not find local address for %s", client.ConnectAddr.AddrPort.String())
+	}
+
+	localAddr := LocalAddrPort(found, client.ConnectAddr.AddrPort)
+	tunnelConn, err := (&TcpTunnel{client.ClaimInstructions}).Connect()
+	if err != nil {
+		return err
+	}
+
+	localConn, err := TcpSocket(tun.SpecialLan, client.PeerAddr.AddrPort, localAddr)
+	if err != nil {
+		tunnelConn.Close()
+		return err
+	}
+
+	if err := found.ProxyProtocol.WriteHeader(localConn, client.PeerAddr.AddrPort, client.ConnectAddr.AddrPort); err != nil {
+		tunnelConn.Close()
+		localConn.Close()
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		tunnelConn.Close()
+		localConn.Close()
+	})
+	defer stop()
+
+	LogDebug.Printf("tcp client %s -> %s connected\n", client.PeerAddr.AddrPort.String(), localAddr.String())
+	defer LogDebug.Printf("tcp client %s -> %s closed\n", client.PeerAddr.AddrPort.String(), localAddr.String())
+	return TcpRelay(tunnelConn, localConn)
 }
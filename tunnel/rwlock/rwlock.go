@@ -10,9 +10,9 @@ type Rwlock[T any] struct {
 // Get writer value and return unlocker function
 //
 // if call this function before end call function
-func (rw *Rwlock[T]) Write() (T, func()) {
+func (rw *Rwlock[T]) Write() (*T, func()) {
 	rw.Lock()
-	return rw.Value, rw.Unlock
+	return &rw.Value, rw.Unlock
 }
 
 // Get reader value and unlocker function
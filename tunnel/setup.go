@@ -2,6 +2,7 @@ package tunnel
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"net"
@@ -9,14 +10,18 @@ import (
 	"time"
 
 	"sirherobrine23.org/playit-cloud/go-playit/api"
+	"sirherobrine23.org/playit-cloud/go-playit/tunnel/rwlock"
 )
 
 type SetupFindSuitableChannel struct {
 	Address []netip.AddrPort
 }
 
-func (Setup *SetupFindSuitableChannel) Setup() (*ConnectedControl, error) {
+func (Setup *SetupFindSuitableChannel) Setup(ctx context.Context) (*ConnectedControl, error) {
 	for _, Addr := range Setup.Address {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		network := "udp6"
 		if Addr.Addr().Is4() && !Addr.Addr().Is4In6() {
 			network = "udp4"
@@ -28,6 +33,11 @@ func (Setup *SetupFindSuitableChannel) Setup() (*ConnectedControl, error) {
 		}
 
 		for range 3 {
+			if err := ctx.Err(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+
 			// Make initial ping
 			buffer := bytes.NewBuffer([]byte{})
 			if err = (&ControlRpcMessage[*ControlRequest]{
@@ -53,7 +63,7 @@ func (Setup *SetupFindSuitableChannel) Setup() (*ConnectedControl, error) {
 
 			for range 5 {
 				buff := make([]byte, 2048)
-				if err = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 5)); err != nil {
+				if err = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500)); err != nil {
 					return nil, err
 				}
 				bytesSize, peer, err := conn.ReadFrom(buff)
@@ -70,7 +80,7 @@ func (Setup *SetupFindSuitableChannel) Setup() (*ConnectedControl, error) {
 
 				buff = buff[:bytesSize]
 				var feed ControlFeed
-				if err := feed.ReadFrom(bytes.NewReader(buff)); err != nil {
+				if err := DecodeMessage(buff, &feed); err != nil {
 					return nil, err
 				} else if feed.Response == nil {
 					return nil, fmt.Errorf("unexpected control feed")
@@ -100,26 +110,22 @@ type ConnectedControl struct {
 	Pong        *Pong
 }
 
-func (Control *ConnectedControl) Authenticate(Api api.Api) (*AuthenticatedControl, error) {
+func (Control *ConnectedControl) Authenticate(ctx context.Context, Api api.Client) (*AuthenticatedControl, error) {
 	if !Control.Pong.ClientAddr.AddrPort.IsValid() {
 		return nil, fmt.Errorf("invalid pong Client address")
 	} else if !Control.Pong.TunnelAddr.AddrPort.IsValid() {
 		return nil, fmt.Errorf("invalid pong Tunnel address")
 	}
 
-	LogDebug.Println("Registring agent proto")
-	tk, err := Api.ProtoRegisterRegister(Control.Pong.ClientAddr.AddrPort, Control.Pong.TunnelAddr.AddrPort)
-	if err != nil {
-		LogDebug.Println("failed to sign and register")
-		return nil, err
-	}
-
-	tkBytes, err := hex.DecodeString(tk)
+	tkBytes, err := registerKey(ctx, Api, Control.Pong)
 	if err != nil {
 		return nil, err
 	}
 
 	for range 5 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		buffer := bytes.NewBuffer([]byte{})
 		if err := (&ControlRpcMessage[*RawSlice]{
 			RequestID: 10,
@@ -136,7 +142,7 @@ func (Control *ConnectedControl) Authenticate(Api api.Api) (*AuthenticatedContro
 
 		for range 5 {
 			reciver := append(buffer.Bytes(), make([]byte, 1024)...)
-			Control.Udp.SetReadDeadline(time.Now().Add(time.Millisecond * 5))
+			Control.Udp.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
 			recSize, remote, err := Control.Udp.ReadFrom(reciver)
 			if err != nil {
 				if errNet, isNet := err.(net.Error); isNet {
@@ -152,39 +158,55 @@ func (Control *ConnectedControl) Authenticate(Api api.Api) (*AuthenticatedContro
 			}
 
 			feed := &ControlFeed{}
-			if err = feed.ReadFrom(bytes.NewReader(reciver[:recSize])); err != nil {
+			if err = DecodeMessage(reciver[:recSize], feed); err != nil {
 				LogDebug.Println("failed to read response from tunnel")
 				return nil, err
-			} else if feed.Response.RequestID != 10 {
-				LogDebug.Println("got response for different request")
-				continue
 			} else if feed.Response == nil || feed.Response.Content == nil {
 				LogDebug.Println("feed response or Response content is empty")
 				return nil, fmt.Errorf("cannot get response")
+			} else if feed.Response.RequestID != 10 {
+				LogDebug.Println("got response for different request")
+				continue
 			}
 
 			controlRes := feed.Response.Content
 			if controlRes.RequestQueued {
 				LogDebug.Println("register queued, waiting 1s")
-				time.Sleep(time.Second)
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(time.Second):
+				}
 				continue
 			} else if controlRes.InvalidSignature {
 				return nil, fmt.Errorf("register return invalid signature")
 			} else if controlRes.Unauthorized {
 				return nil, fmt.Errorf("unauthorized")
 			} else if controlRes.AgentRegistered != nil {
-				return &AuthenticatedControl{
-					ApiClient:   Api,
-					Conn:        *Control,
-					LastPong:    *Control.Pong,
-					CurrentPing: nil,
-					Registered:  *controlRes.AgentRegistered,
-					Buff:        reciver[recSize:],
-					ForceEpired: false,
-				}, nil
+				auth := &AuthenticatedControl{
+					ApiClient: Api,
+					Conn:      *Control,
+					State: rwlock.Rwlock[ControlState]{Value: ControlState{
+						LastPong:   *Control.Pong,
+						Registered: *controlRes.AgentRegistered,
+					}},
+				}
+				auth.start()
+				return auth, nil
 			}
 			LogDebug.Println("expected AgentRegistered but got something else")
 		}
 	}
 	return nil, fmt.Errorf("failed1 to connect agent")
 }
+
+// Sign client and tunnel address in API and return register message
+func registerKey(ctx context.Context, Api api.Client, pong *Pong) ([]byte, error) {
+	LogDebug.Println("Registring agent proto")
+	tk, err := Api.ProtoRegisterRegister(ctx, pong.ClientAddr.AddrPort, pong.TunnelAddr.AddrPort)
+	if err != nil {
+		LogDebug.Println("failed to sign and register")
+		return nil, err
+	}
+	return hex.DecodeString(tk)
+}
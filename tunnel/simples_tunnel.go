@@ -1,8 +1,8 @@
 package tunnel
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"errors"
 	"net/netip"
 	"slices"
 	"time"
@@ -11,19 +11,18 @@ import (
 )
 
 type SimplesTunnel struct {
-	ApiClaim           api.Api
+	ApiClaim           api.Client
 	ControlAddr        netip.AddrPort
 	ControlChannel     *AuthenticatedControl
 	UdpTunnel          UdpTunnel
 	LastKeepAlive      uint64
 	LastPing           uint64
-	LastPong           uint64
 	LastUdpAuth        uint64
 	lastControlTargets []netip.AddrPort
 }
 
-func ControlAddresses(Api api.Api) ([]netip.AddrPort, error) {
-	controls, err := Api.AgentRoutings(nil)
+func ControlAddresses(ctx context.Context, Api api.Client) ([]netip.AddrPort, error) {
+	controls, err := Api.AgentRoutings(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -35,23 +34,27 @@ func ControlAddresses(Api api.Api) ([]netip.AddrPort, error) {
 	return addrs, nil
 }
 
-func (Tun *SimplesTunnel) Setup() error {
+func (Tun *SimplesTunnel) Setup(ctx context.Context) error {
 	if err := AssignUdpTunnel(&Tun.UdpTunnel); err != nil {
 		return err
 	}
 
-	addresses, err := ControlAddresses(Tun.ApiClaim)
+	addresses, err := ControlAddresses(ctx, Tun.ApiClaim)
 	if err != nil {
+		Tun.UdpTunnel.Close()
 		return err
 	}
 
-	setup, err := (&SetupFindSuitableChannel{Address: addresses}).Setup()
+	setup, err := (&SetupFindSuitableChannel{Address: addresses}).Setup(ctx)
 	if err != nil {
+		Tun.UdpTunnel.Close()
 		return err
 	}
 
-	control_channel, err := setup.Authenticate(Tun.ApiClaim)
+	control_channel, err := setup.Authenticate(ctx, Tun.ApiClaim)
 	if err != nil {
+		setup.Udp.Close()
+		Tun.UdpTunnel.Close()
 		return err
 	}
 	Tun.ControlAddr = setup.ControlAddr
@@ -59,41 +62,40 @@ func (Tun *SimplesTunnel) Setup() error {
 	return nil
 }
 
-func (Tun *SimplesTunnel) ReloadControlAddr() (bool, error) {
-	addresses, err := ControlAddresses(Tun.ApiClaim)
+func (Tun *SimplesTunnel) ReloadControlAddr(ctx context.Context) (bool, error) {
+	addresses, err := ControlAddresses(ctx, Tun.ApiClaim)
 	if err != nil {
 		return false, err
 	}
 
-	if slices.ContainsFunc(Tun.lastControlTargets, func(a netip.AddrPort) bool {
-		return !slices.ContainsFunc(addresses, func(b netip.AddrPort) bool {
-			return a.Compare(b) == 0
-		})
-	}) {
+	if slices.Equal(Tun.lastControlTargets, addresses) {
 		return false, nil
 	}
-	setup, err := (&SetupFindSuitableChannel{addresses}).Setup()
+	setup, err := (&SetupFindSuitableChannel{addresses}).Setup(ctx)
 	if err != nil {
 		return false, err
 	}
-	updated, err := Tun.UpdateControlAddr(*setup)
+	updated, err := Tun.UpdateControlAddr(ctx, *setup)
 	Tun.lastControlTargets = addresses
 	return updated, err
 }
 
-func (Tun *SimplesTunnel) UpdateControlAddr(conncted ConnectedControl) (ok bool, err error) {
+func (Tun *SimplesTunnel) UpdateControlAddr(ctx context.Context, conncted ConnectedControl) (ok bool, err error) {
 	if conncted.ControlAddr.Compare(Tun.ControlAddr) == 0 {
 		LogDebug.Println("not required Update control addr")
+		conncted.Udp.Close()
 		return
 	}
 
 	var controlChannel *AuthenticatedControl
-	controlChannel, err = conncted.Authenticate(Tun.ApiClaim)
+	controlChannel, err = conncted.Authenticate(ctx, Tun.ApiClaim)
 	if err != nil {
+		conncted.Udp.Close()
 		return
 	}
 	LogDebug.Printf("Update control address %s to %s\n", Tun.ControlAddr.String(), conncted.ControlAddr.String())
 
+	Tun.ControlChannel.Close()
 	Tun.ControlChannel = controlChannel
 	Tun.ControlAddr = conncted.ControlAddr
 	Tun.LastPing = 0
@@ -104,12 +106,19 @@ func (Tun *SimplesTunnel) UpdateControlAddr(conncted ConnectedControl) (ok bool,
 	return
 }
 
-func (Tun *SimplesTunnel) Update() (*NewClient, error) {
-	if Tun.ControlChannel.IsIspired() {
+// Send ping, keepalive and udp setup when required and wait one second for new client
+func (Tun *SimplesTunnel) Update(ctx context.Context) (*NewClient, error) {
+	control := Tun.ControlChannel
+	if err := control.Err(); err != nil {
+		return nil, err
+	} else if control.IsIspired() {
 		LogDebug.Println("Creating new controller channel...")
-		if err := Tun.ControlChannel.Authenticate(); err != nil {
+		if err := control.Authenticate(ctx); err != nil {
 			LogDebug.Println(err)
-			time.Sleep(time.Second * 2)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second * 2):
+			}
 			return nil, nil
 		}
 	}
@@ -117,21 +126,17 @@ func (Tun *SimplesTunnel) Update() (*NewClient, error) {
 	now := uint64(time.Now().UnixMilli())
 	if now-Tun.LastPing > 1_000 {
 		Tun.LastPing = now
-		if err := Tun.ControlChannel.SendPing(200, time.UnixMilli(int64(now))); err != nil {
-			LogDebug.Println("failed to send ping")
-		}
+		go func() {
+			if _, err := control.Ping(context.Background()); err != nil {
+				LogDebug.Printf("failed to send ping: %s\n", err.Error())
+			}
+		}()
 	}
 
-	// d, _ := json.MarshalIndent(Tun, "", "  ")
-	// LogDebug.Panicf(string(d))
-
 	if Tun.UdpTunnel.RequiresAuth() {
 		if 5_000 < now-Tun.LastUdpAuth {
 			Tun.LastUdpAuth = now
-			if err := Tun.ControlChannel.SendSetupUDPChannel(9000); err != nil {
-				LogDebug.Println("failed to send udp setup request to control")
-				LogDebug.Println(err)
-			}
+			go Tun.setupUdpChannel(control)
 		}
 	} else if Tun.UdpTunnel.RequireResend() {
 		if 1_000 < now-Tun.LastUdpAuth {
@@ -141,73 +146,87 @@ func (Tun *SimplesTunnel) Update() (*NewClient, error) {
 				LogDebug.Println(err)
 			}
 		}
+	}
 
-		timeTillExpire := func(x, y uint64) uint64 {
-			if x > y {
-				return y
-			}
-			return x
-		}(uint64(Tun.ControlChannel.Registered.ExpiresAt.UnixMilli()), uint64(now))
-		if 10_000 < now-Tun.LastKeepAlive && timeTillExpire < 30_000 {
-			Tun.LastKeepAlive = now
-			LogDebug.Println("send KeepAlive")
-			if err := Tun.ControlChannel.SendKeepAlive(100); err != nil {
-				LogDebug.Println("failed to send KeepAlive")
-				LogDebug.Println(err)
-			}
-			if err := Tun.ControlChannel.SendSetupUDPChannel(1); err != nil {
-				LogDebug.Println("failed to send setup udp channel request")
-				LogDebug.Println(err)
+	state, unlock := control.State.Read()
+	expiresAt, lastPongAt := uint64(state.Registered.ExpiresAt.UnixMilli()), state.LastPongAt
+	unlock()
+
+	timeTillExpire := max(expiresAt, now) - now
+	if 10_000 < now-Tun.LastKeepAlive && timeTillExpire < 30_000 {
+		Tun.LastKeepAlive = now
+		LogDebug.Println("send KeepAlive")
+		go func() {
+			if err := control.KeepAlive(context.Background()); err != nil {
+				LogDebug.Printf("failed to send KeepAlive: %s\n", err.Error())
 			}
-		}
+			Tun.setupUdpChannel(control)
+		}()
+	}
 
-		timeout := 0
-		for range 30 {
-			if timeout >= 10 {
-				LogDebug.Println("feed recv timeout")
-				break
-			}
-			LogDebug.Println("RX Feed message")
-			men, err := Tun.ControlChannel.RecFeedMsg()
-			if err != nil {
-				timeout++
-				LogDebug.Printf("failed to parse response: %s\n", err.Error())
-				continue
+	select {
+	case client := <-control.NewClients():
+		return client, nil
+	case <-control.Done():
+		return nil, control.Err()
+	case <-ctx.Done():
+		return nil, nil
+	case <-time.After(time.Second):
+	}
+
+	if !lastPongAt.IsZero() && time.Since(lastPongAt) > 6*time.Second {
+		LogDebug.Println("timeout waiting for pong")
+		control.ForceEpired.Store(true)
+	}
+
+	return nil, nil
+}
+
+func (Tun *SimplesTunnel) setupUdpChannel(control *AuthenticatedControl) {
+	details, err := control.SetupUdpChannel(context.Background())
+	if err != nil {
+		LogDebug.Printf("failed to setup udp channel: %s\n", err.Error())
+		return
+	}
+	LogDebug.Print("Response SetUdpTunnel")
+	if err := Tun.UdpTunnel.SetUdpTunnel(*details); err != nil {
+		LogDebug.Print(err)
+	}
+}
+
+// Update control channel until ctx is done and call onClient to every new client,
+// control address is reloaded every 30 seconds, if reload fail keep current control address
+func (Tun *SimplesTunnel) Serve(ctx context.Context, onClient func(NewClient)) error {
+	lastControlUpdate := time.Now().UnixMilli()
+	for ctx.Err() == nil {
+		now := time.Now().UnixMilli()
+		if 30_000 < now-lastControlUpdate {
+			lastControlUpdate = now
+			LogDebug.Println("Reloading control addr")
+			if _, err := Tun.ReloadControlAddr(ctx); err != nil {
+				LogDebug.Printf("failed to reload control addr: %s\n", err.Error())
 			}
+		}
 
-			if men.NewClient != nil {
-				return men.NewClient, nil
-			} else if men.Response != nil {
-				cont := men.Response.Content
-				if cont.UdpChannelDetails != nil {
-					LogDebug.Print("Response SetUdpTunnel")
-					if err := Tun.UdpTunnel.SetUdpTunnel(*men.Response.Content.UdpChannelDetails); err != nil {
-						timeout++
-						LogDebug.Print(err)
-					}
-				} else if cont.Pong != nil {
-					Tun.LastPong = uint64(time.Now().UnixMilli())
-					if cont.Pong.ClientAddr.Compare(Tun.ControlChannel.Conn.Pong.ClientAddr.AddrPort) != 0 {
-						LogDebug.Printf("Client IP changed: %q -> %q\n", cont.Pong.ClientAddr, Tun.ControlChannel.Conn.Pong.ClientAddr.AddrPort)
-					}
-				} else if cont.Unauthorized {
-					LogDebug.Panicln("unauthorized, check token or reload agent")
-					Tun.ControlChannel.ForceEpired = true
-					return nil, fmt.Errorf("unauthorized, check token or reload agent")
-				} else {
-					LogDebug.Printf("got response")
-					d , _ := json.MarshalIndent(men, "", "  ")
-					LogDebug.Printf(string(d))
-				}
+		newClient, err := Tun.Update(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
 			}
+			LogDebug.Println(err.Error())
+			return err
+		} else if newClient != nil {
+			onClient(*newClient)
 		}
 	}
+	return nil
+}
 
-	if Tun.LastPong != 0 && uint64(time.Now().UnixMilli())-Tun.LastPong > 6_000 {
-		LogDebug.Println("timeout waiting for pong")
-		Tun.LastPong = 0
-		Tun.ControlChannel.ForceEpired = true
+// Close control channel and udp tunnel sockets
+func (Tun *SimplesTunnel) Close() error {
+	var err error
+	if Tun.ControlChannel != nil {
+		err = Tun.ControlChannel.Close()
 	}
-
-	return nil, nil
+	return errors.Join(err, Tun.UdpTunnel.Close())
 }
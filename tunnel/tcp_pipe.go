@@ -0,0 +1,38 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// Copy bytes from one connection to other and close write side of to when from end
+func TcpPipe(from, to *net.TCPConn) error {
+	_, err := io.Copy(to, from)
+	if closeErr := to.CloseWrite(); err == nil && closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+		err = closeErr
+	}
+	return err
+}
+
+// Relay bytes between tunnel and local connection until both sides end,
+// if one side fail both connections are closed
+func TcpRelay(tunnel, local *net.TCPConn) error {
+	errs := make(chan error, 2)
+	go func() { errs <- TcpPipe(tunnel, local) }()
+	go func() { errs <- TcpPipe(local, tunnel) }()
+
+	var relayErr error
+	for range 2 {
+		if err := <-errs; err != nil && !errors.Is(err, net.ErrClosed) {
+			if relayErr == nil {
+				relayErr = err
+			}
+			tunnel.Close()
+			local.Close()
+		}
+	}
+	tunnel.Close()
+	local.Close()
+	return relayErr
+}
@@ -1,10 +1,14 @@
 package tunnel
 
-import "net"
+import (
+	"io"
+	"net"
+)
 
 type TcpTunnel struct {
 	ClaimInstructions ClaimInstructions
 }
+
 func (tcp *TcpTunnel) Connect() (*net.TCPConn, error) {
 	stream, err := net.DialTCP("tcp", nil, net.TCPAddrFromAddrPort(tcp.ClaimInstructions.Address.AddrPort))
 	if err != nil {
@@ -16,10 +20,9 @@ func (tcp *TcpTunnel) Connect() (*net.TCPConn, error) {
 		return nil, err
 	}
 	res := make([]byte, 8)
-	if _, err := stream.Read(res); err != nil {
+	if _, err := io.ReadFull(stream, res); err != nil {
 		stream.Close()
 		return nil, err
 	}
-	LogDebug.Printf("%+v\n", res)
 	return stream, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,198 @@
+package tunneltest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/google/uuid"
+	"sirherobrine23.org/playit-cloud/go-playit/tunnel"
+)
+
+// Packet sent by agent to UDP channel
+type UdpPacket struct {
+	Agent   uuid.UUID
+	Flow    tunnel.UdpFlow // Flow from agent, Src is tunnel address and Dst is client
+	Payload []byte
+}
+
+// Push NewClient to every session of agent and wait agent claim connection with token,
+// returned connection is the player side of tunnel
+func (server *Server) ConnectTcp(ctx context.Context, agentID uuid.UUID, peer, connect netip.AddrPort) (*net.TCPConn, error) {
+	token := make([]byte, TokenLen)
+	rand.Read(token)
+	claimed := make(chan *net.TCPConn, 1)
+
+	server.lock.Lock()
+	sessions := server.agentSessions(agentID)
+	var controls []netip.AddrPort
+	for _, session := range sessions {
+		controls = append(controls, session.Control)
+	}
+	if len(controls) > 0 {
+		server.claims[string(token)] = claimed
+	}
+	server.lock.Unlock()
+	if len(controls) == 0 {
+		return nil, server.errNoSession(agentID)
+	}
+	defer func() {
+		server.lock.Lock()
+		delete(server.claims, string(token))
+		server.lock.Unlock()
+	}()
+
+	feed := &tunnel.ControlFeed{NewClient: &tunnel.NewClient{
+		ConnectAddr: tunnel.AddressPort{AddrPort: connect},
+		PeerAddr:    tunnel.AddressPort{AddrPort: peer},
+		ClaimInstructions: tunnel.ClaimInstructions{
+			Address: tunnel.AddressPort{AddrPort: server.Tcp.Addr().(*net.TCPAddr).AddrPort()},
+			Token:   token,
+		},
+		TunnelServerId: server.ServerID,
+		DataCenterId:   server.DataCenterID,
+	}}
+	for _, control := range controls {
+		if err := server.sendFeed(control, feed); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case conn := <-claimed:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("claim to %s: %w", peer.String(), ctx.Err())
+	}
+}
+
+func (server *Server) tcpLoop() {
+	defer server.wg.Done()
+	for {
+		conn, err := server.Tcp.AcceptTCP()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go server.claim(conn)
+	}
+}
+
+// Read token and send conn to ConnectTcp, unknown tokens are closed
+func (server *Server) claim(conn *net.TCPConn) {
+	token := make([]byte, TokenLen)
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if _, err := io.ReadFull(conn, token); err != nil {
+		conn.Close()
+		return
+	}
+
+	server.lock.Lock()
+	claimed, ok := server.claims[string(token)]
+	delete(server.claims, string(token))
+	server.lock.Unlock()
+	if !ok {
+		tunnel.LogDebug.Printf("tunneltest: invalid claim token from %s\n", conn.RemoteAddr().String())
+		conn.Close()
+		return
+	} else if err := tunnel.WriteU64(conn, uint64(time.Now().UnixMilli())); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	claimed <- conn
+}
+
+func (server *Server) udpLoop() {
+	defer server.wg.Done()
+	buff := make([]byte, 1<<16)
+	for {
+		size, remote, err := server.Udp.ReadFromUDPAddrPort(buff)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		server.lock.Lock()
+		if sessionID, ok := server.udpTokens[string(buff[:size])]; ok {
+			if session := server.sessions[sessionID]; session != nil {
+				session.Udp = remote
+			}
+			server.lock.Unlock()
+			// Confirm channel with same token
+			server.Udp.WriteToUDPAddrPort(buff[:size], remote)
+			continue
+		}
+
+		var agentID uuid.UUID
+		for _, session := range server.sessions {
+			if session.Udp == remote {
+				agentID = session.Agent
+				break
+			}
+		}
+		server.lock.Unlock()
+		if agentID == uuid.Nil {
+			tunnel.LogDebug.Printf("tunneltest: udp packet from unknown channel %s\n", remote.String())
+			continue
+		}
+
+		flow, _, err := tunnel.FromTailUdpFlow(buff[:size])
+		if err != nil {
+			tunnel.LogDebug.Printf("tunneltest: invalid udp footer from %s: %s\n", remote.String(), err.Error())
+			continue
+		}
+		select {
+		case server.packets <- UdpPacket{Agent: agentID, Flow: *flow, Payload: bytes.Clone(buff[:size-flow.Len()])}:
+		default:
+			tunnel.LogDebug.Println("tunneltest: udp packet queue full")
+		}
+	}
+}
+
+// Send packet from client to every confirmed udp channel of agent,
+// flow Src is client address and Dst is tunnel address
+func (server *Server) SendUdp(agentID uuid.UUID, flow tunnel.UdpFlow, payload []byte) error {
+	server.lock.Lock()
+	var channels []netip.AddrPort
+	for _, session := range server.agentSessions(agentID) {
+		if session.Udp.IsValid() {
+			channels = append(channels, session.Udp)
+		}
+	}
+	server.lock.Unlock()
+	if len(channels) == 0 {
+		return fmt.Errorf("agent %s not have udp channel confirmed", agentID.String())
+	}
+
+	buff := bytes.NewBuffer(bytes.Clone(payload))
+	if err := flow.WriteTo(buff); err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if _, err := server.Udp.WriteToUDPAddrPort(buff.Bytes(), channel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wait next packet sent by agents
+func (server *Server) ReadUdp(ctx context.Context) (*UdpPacket, error) {
+	select {
+	case packet := <-server.packets:
+		return &packet, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
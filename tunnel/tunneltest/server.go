@@ -0,0 +1,328 @@
+// Package tunneltest run playit tunnel server in loopback to tests agents without playit.gg,
+// control channel, TCP claims and UDP channel are served by same Server
+package tunneltest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"sirherobrine23.org/playit-cloud/go-playit/api/apitest"
+	"sirherobrine23.org/playit-cloud/go-playit/tunnel"
+)
+
+const (
+	ControlPort       uint16        = 5525 // Port used by agent to control channel
+	DefaultSessionTTL time.Duration = time.Minute
+	TokenLen          int           = 32 // Size of claim and udp channel tokens
+)
+
+// Agent registered in control channel
+type Session struct {
+	ID        tunnel.AgentSessionId
+	Agent     uuid.UUID
+	Control   netip.AddrPort // Agent control socket
+	Udp       netip.AddrPort // Agent udp channel socket, invalid until token is confirmed
+	ExpiresAt time.Time
+}
+
+// Tunnel server in loopback, ControlAddr is 127.x.y.z with port 5525 to agent find with /agents/routing/get
+type Server struct {
+	Control *net.UDPConn     // Control channel
+	Udp     *net.UDPConn     // UDP channel, packets with flow footer
+	Tcp     *net.TCPListener // TCP claims
+
+	ServerID     uint64
+	DataCenterID uint32
+	SessionTTL   time.Duration // Session expire after register or keep alive, default DefaultSessionTTL
+
+	secret      []byte // HMAC key to register signature
+	lock        sync.Mutex
+	agents      map[uuid.UUID]uint64
+	agentIDs    map[uint64]uuid.UUID
+	sessions    map[uint64]*Session
+	udpTokens   map[string]uint64 // Token to session id
+	claims      map[string]chan *net.TCPConn
+	packets     chan UdpPacket
+	nextSession uint64
+	wg          sync.WaitGroup
+	closeOnce   sync.Once
+}
+
+// Start tunnel server and set control address and register key in API server,
+// call before agents use API server
+func NewServer(apiServer *apitest.Server) (*Server, error) {
+	control, err := listenControl()
+	if err != nil {
+		return nil, err
+	}
+	ip := control.LocalAddr().(*net.UDPAddr).AddrPort().Addr()
+
+	server := &Server{
+		Control:      control,
+		ServerID:     1,
+		DataCenterID: 1,
+		secret:       make([]byte, 32),
+		agents:       map[uuid.UUID]uint64{},
+		agentIDs:     map[uint64]uuid.UUID{},
+		sessions:     map[uint64]*Session{},
+		udpTokens:    map[string]uint64{},
+		claims:       map[string]chan *net.TCPConn{},
+		packets:      make(chan UdpPacket, 64),
+		nextSession:  1,
+	}
+	rand.Read(server.secret)
+	if server.Udp, err = net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, 0))); err != nil {
+		control.Close()
+		return nil, err
+	} else if server.Tcp, err = net.ListenTCP("tcp4", net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, 0))); err != nil {
+		control.Close()
+		server.Udp.Close()
+		return nil, err
+	}
+
+	apiServer.Targets4, apiServer.Targets6 = []netip.Addr{ip}, nil
+	apiServer.RegisterKey = server.registerKey
+
+	server.wg.Add(3)
+	go server.controlLoop()
+	go server.udpLoop()
+	go server.tcpLoop()
+	return server, nil
+}
+
+// Listen control in random 127.x.y.z to parallel servers, fallback to 127.0.0.1
+func listenControl() (*net.UDPConn, error) {
+	for range 10 {
+		buff := make([]byte, 3)
+		rand.Read(buff)
+		addr := netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, buff[0], buff[1], max(buff[2], 2)}), ControlPort)
+		conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(addr))
+		if err == nil {
+			return conn, nil
+		} else if errors.Is(err, syscall.EADDRNOTAVAIL) {
+			break
+		}
+	}
+	return net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), ControlPort)))
+}
+
+// Control address to agents
+func (server *Server) ControlAddr() netip.AddrPort {
+	return server.Control.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func (server *Server) sessionTTL() time.Duration {
+	if server.SessionTTL <= 0 {
+		return DefaultSessionTTL
+	}
+	return server.SessionTTL
+}
+
+// Copy of sessions
+func (server *Server) Sessions() []Session {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	var sessions []Session
+	for _, session := range server.sessions {
+		sessions = append(sessions, *session)
+	}
+	return sessions
+}
+
+// Expire agent sessions, next keep alive return Unauthorized
+func (server *Server) ExpireSessions(agentID uuid.UUID) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	for id, session := range server.sessions {
+		if session.Agent == agentID {
+			delete(server.sessions, id)
+		}
+	}
+}
+
+// Live sessions of agent, call with lock
+func (server *Server) agentSessions(agentID uuid.UUID) []*Session {
+	var sessions []*Session
+	for _, session := range server.sessions {
+		if session.Agent == agentID && time.Now().Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+func (server *Server) Close() error {
+	var err error
+	server.closeOnce.Do(func() {
+		err = errors.Join(server.Control.Close(), server.Udp.Close(), server.Tcp.Close())
+		server.wg.Wait()
+	})
+	return err
+}
+
+// Sign AgentRegister with HMAC, used as RegisterKey in API server
+func (server *Server) registerKey(agent apitest.Agent, client, tunnelAddr netip.AddrPort) ([]byte, error) {
+	server.lock.Lock()
+	agentID, ok := server.agents[agent.ID]
+	if !ok {
+		agentID = uint64(len(server.agents) + 1)
+		server.agents[agent.ID], server.agentIDs[agentID] = agentID, agent.ID
+	}
+	server.lock.Unlock()
+
+	register := &tunnel.AgentRegister{
+		AccountID:    agent.AccountID,
+		AgentId:      agentID,
+		AgentVersion: 1,
+		Timestamp:    uint64(time.Now().UnixMilli()),
+		ClientAddr:   tunnel.AddressPort{AddrPort: client},
+		TunnelAddr:   tunnel.AddressPort{AddrPort: tunnelAddr},
+	}
+	register.Signature = server.sign(register)
+
+	buff := bytes.NewBuffer([]byte{})
+	if err := (&tunnel.ControlRequest{AgentRegister: register}).WriteTo(buff); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (server *Server) sign(register *tunnel.AgentRegister) []byte {
+	buff := bytes.NewBuffer([]byte{})
+	register.WritePlain(buff)
+	mac := hmac.New(sha256.New, server.secret)
+	mac.Write(buff.Bytes())
+	return mac.Sum(nil)
+}
+
+func (server *Server) controlLoop() {
+	defer server.wg.Done()
+	buff := make([]byte, tunnel.MaxMessageLen)
+	for {
+		size, remote, err := server.Control.ReadFromUDPAddrPort(buff)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		msg := tunnel.ControlRpcMessage[*tunnel.ControlRequest]{Content: &tunnel.ControlRequest{}}
+		if err := tunnel.DecodeMessage(buff[:size], &msg); err != nil {
+			tunnel.LogDebug.Printf("tunneltest: invalid control request from %s: %s\n", remote.String(), err.Error())
+			continue
+		}
+		if res := server.handleRequest(remote, msg.Content); res != nil {
+			server.sendFeed(remote, &tunnel.ControlFeed{Response: &tunnel.ControlRpcMessage[*tunnel.ControlResponse]{
+				RequestID: msg.RequestID,
+				Content:   res,
+			}})
+		}
+	}
+}
+
+func (server *Server) sendFeed(addr netip.AddrPort, feed *tunnel.ControlFeed) error {
+	buff := bytes.NewBuffer([]byte{})
+	if err := feed.WriteTo(buff); err != nil {
+		return err
+	}
+	_, err := server.Control.WriteToUDPAddrPort(buff.Bytes(), addr)
+	return err
+}
+
+func (server *Server) handleRequest(remote netip.AddrPort, req *tunnel.ControlRequest) *tunnel.ControlResponse {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	switch {
+	case req.Ping != nil:
+		pong := &tunnel.Pong{
+			RequestNow:   uint64(req.Ping.Now.UnixMilli()),
+			ServerNow:    uint64(time.Now().UnixMilli()),
+			ServerId:     server.ServerID,
+			DataCenterId: server.DataCenterID,
+			ClientAddr:   tunnel.AddressPort{AddrPort: remote},
+			TunnelAddr:   tunnel.AddressPort{AddrPort: server.ControlAddr()},
+		}
+		var session *Session
+		if req.Ping.SessionID != nil {
+			session = server.session(*req.Ping.SessionID)
+		}
+		if session == nil {
+			// Without session id use last session registered from control address
+			for _, other := range server.sessions {
+				if other.Control == remote && time.Now().Before(other.ExpiresAt) && (session == nil || other.ID.SessionID > session.ID.SessionID) {
+					session = other
+				}
+			}
+		}
+		if session != nil {
+			expireAt := uint64(session.ExpiresAt.UnixMilli())
+			pong.SessionExpireAt = &expireAt
+		}
+		return &tunnel.ControlResponse{Pong: pong}
+	case req.AgentRegister != nil:
+		register := req.AgentRegister
+		agentID, ok := server.agentIDs[register.AgentId]
+		if !ok || !hmac.Equal(register.Signature, server.sign(register)) || register.ClientAddr.AddrPort != remote {
+			return &tunnel.ControlResponse{InvalidSignature: true}
+		}
+		session := &Session{
+			ID:        tunnel.AgentSessionId{SessionID: server.nextSession, AccountID: register.AccountID, AgentID: register.AgentId},
+			Agent:     agentID,
+			Control:   remote,
+			ExpiresAt: time.Now().Add(server.sessionTTL()),
+		}
+		server.nextSession++
+		server.sessions[session.ID.SessionID] = session
+		return &tunnel.ControlResponse{AgentRegistered: &tunnel.AgentRegistered{ID: session.ID, ExpiresAt: session.ExpiresAt}}
+	case req.AgentKeepAlive != nil:
+		session := server.session(*req.AgentKeepAlive)
+		if session == nil {
+			return &tunnel.ControlResponse{Unauthorized: true}
+		}
+		session.Control, session.ExpiresAt = remote, time.Now().Add(server.sessionTTL())
+		return &tunnel.ControlResponse{AgentRegistered: &tunnel.AgentRegistered{ID: session.ID, ExpiresAt: session.ExpiresAt}}
+	case req.SetupUdpChannel != nil:
+		session := server.session(*req.SetupUdpChannel)
+		if session == nil {
+			return &tunnel.ControlResponse{Unauthorized: true}
+		}
+		token := make([]byte, TokenLen)
+		rand.Read(token)
+		server.udpTokens[string(token)] = session.ID.SessionID
+		return &tunnel.ControlResponse{UdpChannelDetails: &tunnel.UdpChannelDetails{
+			TunnelAddr: tunnel.AddressPort{AddrPort: server.Udp.LocalAddr().(*net.UDPAddr).AddrPort()},
+			Token:      token,
+		}}
+	case req.AgentCheckPortMapping != nil:
+		if server.session(req.AgentCheckPortMapping.AgentSessionId) == nil {
+			return &tunnel.ControlResponse{Unauthorized: true}
+		}
+		return &tunnel.ControlResponse{AgentPortMapping: &tunnel.AgentPortMapping{Range: req.AgentCheckPortMapping.PortRange}}
+	}
+	return nil
+}
+
+// Session if exists and not expired, call with lock
+func (server *Server) session(id tunnel.AgentSessionId) *Session {
+	session := server.sessions[id.SessionID]
+	if session == nil || session.ID != id || time.Now().After(session.ExpiresAt) {
+		return nil
+	}
+	return session
+}
+
+func (server *Server) errNoSession(agentID uuid.UUID) error {
+	return fmt.Errorf("agent %s not registered in tunnel server", agentID.String())
+}
@@ -0,0 +1,443 @@
+package tunneltest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+	"sirherobrine23.org/playit-cloud/go-playit/api/apitest"
+	"sirherobrine23.org/playit-cloud/go-playit/tunnel"
+)
+
+var (
+	testPeer    = netip.MustParseAddrPort("203.0.113.7:40123")
+	testConnect = netip.MustParseAddrPort("147.185.221.16:25565")
+)
+
+// Every tunnel address resolve to same local address
+type staticLookup netip.AddrPort
+
+func (lookup staticLookup) Lookup(netip.AddrPort, api.PortType) *tunnel.AddressValue[netip.AddrPort] {
+	return &tunnel.AddressValue[netip.AddrPort]{Value: netip.AddrPort(lookup)}
+}
+
+// Resolve every tunnel address to local address with PROXY header
+type proxyLookup struct {
+	local netip.AddrPort
+	proxy tunnel.ProxyProtocol
+}
+
+func (lookup proxyLookup) Lookup(netip.AddrPort, api.PortType) *tunnel.AddressValue[netip.AddrPort] {
+	return &tunnel.AddressValue[netip.AddrPort]{Value: lookup.local, ProxyProtocol: lookup.proxy}
+}
+
+// Start API and tunnel server with one agent
+func newServers(t *testing.T) (*Server, uuid.UUID, *api.Client) {
+	t.Helper()
+	apiServer := apitest.NewServer()
+	t.Cleanup(apiServer.Close)
+	server, err := NewServer(apiServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	accountID, _ := apiServer.NewAccount(api.AccountStatusGuest)
+	agentID, secret, err := apiServer.NewAgent(accountID, "test", api.AgentTypeDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, agentID, apiServer.ApiClient(secret)
+}
+
+func echoTcp(t *testing.T) netip.AddrPort {
+	t.Helper()
+	ln, err := net.ListenTCP("tcp4", net.TCPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).AddrPort()
+}
+
+func echoUdp(t *testing.T) netip.AddrPort {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buff := make([]byte, 2048)
+		for {
+			size, remote, err := conn.ReadFromUDPAddrPort(buff)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDPAddrPort(buff[:size], remote)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func echo(t *testing.T, conn net.Conn, payload []byte) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	buff := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, buff); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buff, payload) {
+		t.Fatalf("got %q, want %q", buff, payload)
+	}
+}
+
+// Connect player to agent, retry until runner register in tunnel server
+func connectTcp(t *testing.T, ctx context.Context, server *Server, agentID uuid.UUID) *net.TCPConn {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		claimCtx, claimCancel := context.WithTimeout(ctx, time.Second)
+		conn, err := server.ConnectTcp(claimCtx, agentID, testPeer, testConnect)
+		claimCancel()
+		if err == nil {
+			return conn
+		} else if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSetupAndClaim(t *testing.T) {
+	server, agentID, client := newServers(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tun := &tunnel.SimplesTunnel{ApiClaim: *client}
+	if err := tun.Setup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	if tun.ControlAddr != server.ControlAddr() {
+		t.Fatalf("control %s, want %s", tun.ControlAddr, server.ControlAddr())
+	} else if sessions := server.Sessions(); len(sessions) != 1 || sessions[0].Agent != agentID {
+		t.Fatalf("sessions %+v", sessions)
+	}
+
+	clients := make(chan tunnel.NewClient, 1)
+	serveCtx, stop := context.WithCancel(ctx)
+	served := make(chan error, 1)
+	go func() { served <- tun.Serve(serveCtx, func(client tunnel.NewClient) { clients <- client }) }()
+
+	accepted := make(chan *net.TCPConn, 1)
+	go func() {
+		newClient := <-clients
+		if newClient.PeerAddr.AddrPort != testPeer || newClient.ConnectAddr.AddrPort != testConnect {
+			t.Errorf("new client %+v", newClient)
+		}
+		conn, err := (&tunnel.TcpTunnel{ClaimInstructions: newClient.ClaimInstructions}).Connect()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+
+	player, err := server.ConnectTcp(ctx, agentID, testPeer, testConnect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer player.Close()
+	agent := <-accepted
+	if agent == nil {
+		t.FailNow()
+	}
+	defer agent.Close()
+
+	go io.Copy(agent, agent)
+	echo(t, player, []byte("hello from player"))
+
+	stop()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.ConnectTcp(ctx, uuid.New(), testPeer, testConnect); err == nil {
+		t.Fatal("claim to agent without session")
+	}
+}
+
+func TestRunnerCancel(t *testing.T) {
+	server, agentID, client := newServers(t)
+	runner := &tunnel.TunnelRunner{
+		Lookup:      staticLookup(echoTcp(t)),
+		Tunnel:      tunnel.SimplesTunnel{ApiClaim: *client},
+		GracePeriod: 100 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
+
+	player := connectTcp(t, ctx, server, agentID)
+	defer player.Close()
+	echo(t, player, []byte("relay to local server"))
+
+	// Open relay is closed after grace period
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run not stopped after cancel")
+	}
+	player.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := player.Read(make([]byte, 1)); err == nil {
+		t.Fatal("relay open after Run return")
+	}
+}
+
+func TestUdpRelay(t *testing.T) {
+	server, agentID, client := newServers(t)
+	runner := &tunnel.TunnelRunner{
+		Lookup:      staticLookup(echoUdp(t)),
+		Tunnel:      tunnel.SimplesTunnel{ApiClaim: *client},
+		GracePeriod: 100 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	flow := tunnel.UdpFlow{V4: &tunnel.UdpFlowBase{Src: testPeer, Dst: testConnect}}
+	payload := []byte("udp to local server")
+	for {
+		// Udp channel is setup after register, send again until first reply
+		if err := server.SendUdp(agentID, flow, payload); err == nil {
+			readCtx, readCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			packet, err := server.ReadUdp(readCtx)
+			readCancel()
+			if err == nil {
+				if packet.Agent != agentID || !bytes.Equal(packet.Payload, payload) {
+					t.Fatalf("packet %+v", packet)
+				} else if packet.Flow.Src() != testConnect || packet.Flow.Dst() != testPeer {
+					t.Fatalf("flow %s -> %s", packet.Flow.Src(), packet.Flow.Dst())
+				}
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("udp packet not relayed")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		server, agentID, client := newServers(t)
+		ln, err := net.ListenTCP("tcp4", net.TCPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		headers := make(chan []byte, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			header := make([]byte, len(tunnel.ProxyV1Header(testPeer, testConnect)))
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			io.ReadFull(conn, header)
+			headers <- header
+			io.Copy(conn, conn)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		runner := &tunnel.TunnelRunner{
+			Lookup:      proxyLookup{ln.Addr().(*net.TCPAddr).AddrPort(), tunnel.ProxyProtocolV1},
+			Tunnel:      tunnel.SimplesTunnel{ApiClaim: *client},
+			GracePeriod: 100 * time.Millisecond,
+		}
+		done := make(chan error, 1)
+		go func() { done <- runner.Run(ctx) }()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		player := connectTcp(t, ctx, server, agentID)
+		defer player.Close()
+		select {
+		case header := <-headers:
+			if want := tunnel.ProxyV1Header(testPeer, testConnect); !bytes.Equal(header, want) {
+				t.Fatalf("header %q, want %q", header, want)
+			}
+		case <-ctx.Done():
+			t.Fatal("local server not connected")
+		}
+		echo(t, player, []byte("after proxy header"))
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		server, agentID, client := newServers(t)
+		local, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer local.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		runner := &tunnel.TunnelRunner{
+			Lookup:      proxyLookup{local.LocalAddr().(*net.UDPAddr).AddrPort(), tunnel.ProxyProtocolV2},
+			Tunnel:      tunnel.SimplesTunnel{ApiClaim: *client},
+			GracePeriod: 100 * time.Millisecond,
+		}
+		done := make(chan error, 1)
+		go func() { done <- runner.Run(ctx) }()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		flow := tunnel.UdpFlow{V4: &tunnel.UdpFlowBase{Src: testPeer, Dst: testConnect}}
+		payload := []byte("udp with proxy header")
+		want := append(tunnel.ProxyV2Header(false, testPeer, testConnect), payload...)
+		buff := make([]byte, 2048)
+		for {
+			// Udp channel is setup after register, send again until local server receive
+			server.SendUdp(agentID, flow, payload)
+			local.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			if size, _, err := local.ReadFromUDPAddrPort(buff); err == nil {
+				if !bytes.Equal(buff[:size], want) {
+					t.Fatalf("datagram %x, want %x", buff[:size], want)
+				}
+				return
+			} else if ctx.Err() != nil {
+				t.Fatal("udp packet not relayed")
+			}
+		}
+	})
+}
+
+func TestListen(t *testing.T) {
+	server, agentID, client := newServers(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := client.CreateTunnel(ctx, api.Tunnel{
+		Name:      "listen",
+		PortType:  api.PortTypeTcp,
+		PortCount: 1,
+		Origin:    api.TunnelOriginCreate{Agent: api.AssignedAgentCreate{AgentID: agentID}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := client.AgentInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(info.Tunnels) != 1 {
+		t.Fatalf("%d agent tunnels", len(info.Tunnels))
+	}
+	agentTunnel := info.Tunnels[0]
+	connect := netip.AddrPortFrom(netip.AddrFrom4([4]byte{147, 185, 221, byte(agentTunnel.IpNum)}), agentTunnel.Port.From)
+
+	ln, err := tunnel.Listen(ctx, *client, agentTunnel.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if addr, ok := ln.Addr().(*tunnel.TunnelAddr); !ok || addr.Network() != "tcp" || addr.Port != agentTunnel.Port.From || addr.Domain != agentTunnel.AssignedDomain {
+		t.Fatalf("listener address %#v", ln.Addr())
+	}
+
+	accepted := make(chan net.Conn)
+	acceptErr := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				acceptErr <- err
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	// Client to other tunnel port is not claimed by listener
+	claimCtx, claimCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	if _, err := server.ConnectTcp(claimCtx, agentID, testPeer, testConnect); err == nil {
+		t.Fatal("client to other tunnel accepted")
+	}
+	claimCancel()
+
+	player, err := server.ConnectTcp(ctx, agentID, testPeer, connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer player.Close()
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+	case err := <-acceptErr:
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != testPeer.String() || conn.LocalAddr().String() != connect.String() {
+		t.Fatalf("connection %s -> %s", conn.RemoteAddr(), conn.LocalAddr())
+	}
+	go io.Copy(conn, conn)
+	echo(t, player, []byte("accepted by listener"))
+
+	// Close unblock pending Accept
+	time.Sleep(50 * time.Millisecond)
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-acceptErr:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("expected net.ErrClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept not returned after Close")
+	}
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Accept after Close: %v", err)
+	} else if err := ln.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+	"sirherobrine23.org/playit-cloud/go-playit/tunnel/rwlock"
+)
+
+const (
+	DefaultUdpFlowTimeout time.Duration = time.Minute // Default time to close idle flow
+	DefaultUdpMaxFlows    int           = 1024        // Default max flows open in same time
+)
+
+type UdpFlowKey struct {
+	Src, Dst netip.AddrPort
+}
+
+type UdpClient struct {
+	Flow         UdpFlow      // Flow from tunnel server
+	Local        *net.UDPConn // Socket connected to local server
+	Header       []byte       // PROXY v2 header prepended to packets, nil if disabled
+	LastActivity atomic.Int64 // Last packet in unix milliseconds
+}
+
+// Map tunnel flows to local sockets
+type UdpClients struct {
+	Tunnel     *UdpTunnel
+	Lookup     AddressLookup[netip.AddrPort]
+	Timeout    time.Duration // Close flow after this time without packets, default is DefaultUdpFlowTimeout
+	MaxFlows   int           // Max open flows, default is DefaultUdpMaxFlows
+	SpecialLan bool          // Bind local sockets to 127.x.y.z address of peer
+	clients    rwlock.Rwlock[map[UdpFlowKey]*UdpClient]
+	replies    sync.WaitGroup
+}
+
+func (clients *UdpClients) timeout() time.Duration {
+	if clients.Timeout <= 0 {
+		return DefaultUdpFlowTimeout
+	}
+	return clients.Timeout
+}
+
+func (clients *UdpClients) maxFlows() int {
+	if clients.MaxFlows <= 0 {
+		return DefaultUdpMaxFlows
+	}
+	return clients.MaxFlows
+}
+
+// Current open flows
+func (clients *UdpClients) Len() int {
+	flows, unlock := clients.clients.Read()
+	defer unlock()
+	return len(flows)
+}
+
+// Send packet from tunnel to local server, if flow not exists create new socket to local server
+func (clients *UdpClients) ForwardPacket(flow UdpFlow, data []byte) error {
+	key := UdpFlowKey{flow.Src(), flow.Dst()}
+
+	flows, unlock := clients.clients.Read()
+	client := flows[key]
+	unlock()
+
+	if client == nil {
+		var err error
+		if client, err = clients.newClient(key, flow); err != nil {
+			return err
+		}
+	}
+
+	client.LastActivity.Store(time.Now().UnixMilli())
+	if client.Header != nil {
+		data = append(slices.Clip(client.Header), data...)
+	}
+	_, err := client.Local.Write(data)
+	return err
+}
+
+func (clients *UdpClients) newClient(key UdpFlowKey, flow UdpFlow) (*UdpClient, error) {
+	flows, unlock := clients.clients.Write()
+	defer unlock()
+	if *flows == nil {
+		*flows = map[UdpFlowKey]*UdpClient{}
+	}
+
+	if client := (*flows)[key]; client != nil {
+		return client, nil
+	} else if len(*flows) >= clients.maxFlows() {
+		return nil, fmt.Errorf("udp flow limit reached (%d), dropping %s", clients.maxFlows(), key.Src.String())
+	}
+
+	lookup := clients.Lookup
+	if lookup == nil {
+		lookup = &LookupWithOverrides{}
+	}
+	found := lookup.Lookup(key.Dst, api.PortTypeUdp)
+	if found == nil {
+		return nil, fmt.Errorf("could not find local address for %s", key.Dst.String())
+	}
+
+	localAddr := LocalAddrPort(found, key.Dst)
+	local, err := UdpSocket(clients.SpecialLan, key.Src, localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &UdpClient{Flow: flow, Local: local, Header: found.ProxyProtocol.DgramHeader(key.Src, key.Dst)}
+	client.LastActivity.Store(time.Now().UnixMilli())
+	(*flows)[key] = client
+	LogDebug.Printf("udp flow %s -> %s opened\n", key.Src.String(), localAddr.String())
+
+	clients.replies.Add(1)
+	go clients.reply(key, client)
+	return client, nil
+}
+
+// Read packets from local server and send back to tunnel until flow is idle
+func (clients *UdpClients) reply(key UdpFlowKey, client *UdpClient) {
+	defer clients.replies.Done()
+	defer clients.remove(key, client)
+
+	flow := client.Flow.Flip()
+	buff := make([]byte, 2048)
+	for {
+		client.Local.SetReadDeadline(time.Now().Add(clients.timeout()))
+		size, err := client.Local.Read(buff[:len(buff)-V6_LEN])
+		if err != nil {
+			if netErr, isNet := err.(net.Error); isNet && netErr.Timeout() {
+				if time.Since(time.UnixMilli(client.LastActivity.Load())) < clients.timeout() {
+					continue
+				}
+			} else if !errors.Is(err, net.ErrClosed) {
+				LogDebug.Printf("udp flow %s: %s\n", key.Src.String(), err.Error())
+			}
+			return
+		}
+
+		client.LastActivity.Store(time.Now().UnixMilli())
+		if _, err := clients.Tunnel.Send(buff[:size], flow); err != nil {
+			LogDebug.Printf("udp flow %s: failed to send to tunnel: %s\n", key.Src.String(), err.Error())
+		}
+	}
+}
+
+func (clients *UdpClients) remove(key UdpFlowKey, client *UdpClient) {
+	flows, unlock := clients.clients.Write()
+	if (*flows)[key] == client {
+		delete(*flows, key)
+	}
+	unlock()
+	client.Local.Close()
+	LogDebug.Printf("udp flow %s closed\n", key.Src.String())
+}
+
+// Wait open flows end by idle timeout
+func (clients *UdpClients) Wait() {
+	clients.replies.Wait()
+}
+
+// Close all open flows and wait replies end,
+// ForwardPacket must not be called after Close
+func (clients *UdpClients) Close() {
+	flows, unlock := clients.clients.Read()
+	for _, client := range flows {
+		client.Local.Close()
+	}
+	unlock()
+	clients.replies.Wait()
+}
@@ -0,0 +1,223 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"sirherobrine23.org/playit-cloud/go-playit/api"
+	"sirherobrine23.org/playit-cloud/go-playit/tunnel/rwlock"
+)
+
+type udpPeer struct {
+	Flow     UdpFlow
+	LastSeen time.Time
+}
+
+// net.PacketConn to UDP flows of one agent tunnel
+type UdpListener struct {
+	Tunnel      SimplesTunnel
+	AgentTunnel api.AgentTunnel
+
+	peers     rwlock.Rwlock[map[netip.AddrPort]udpPeer]
+	readLock  sync.Mutex
+	buff      []byte
+	lastPrune time.Time
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	closeOnce sync.Once
+
+	// Deadlines in unix nano, 0 without deadline. Kept here because udp sockets
+	// are created after udp channel setup and replaced on new setup
+	readDeadline, writeDeadline atomic.Int64
+}
+
+// Time until deadline, false if deadline is passed
+func untilDeadline(deadline *atomic.Int64) (time.Duration, bool) {
+	unixNano := deadline.Load()
+	if unixNano == 0 {
+		return -1, true
+	}
+	until := time.Until(time.Unix(0, unixNano))
+	return until, until > 0
+}
+
+func storeDeadline(deadline *atomic.Int64, t time.Time) {
+	if t.IsZero() {
+		deadline.Store(0)
+		return
+	}
+	deadline.Store(t.UnixNano())
+}
+
+// Listen UDP packets from agent tunnel, packets are returned by ReadFrom
+// until ctx is done or PacketConn closed
+func ListenPacket(ctx context.Context, Api api.Client, TunnelID uuid.UUID) (net.PacketConn, error) {
+	agentTunnel, err := findAgentTunnel(ctx, Api, TunnelID, api.PortTypeUdp)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &UdpListener{
+		Tunnel:      SimplesTunnel{ApiClaim: Api},
+		AgentTunnel: *agentTunnel,
+		buff:        make([]byte, 1<<16),
+		lastPrune:   time.Now(),
+	}
+	conn.peers.Value = map[netip.AddrPort]udpPeer{}
+	if err := conn.Tunnel.Setup(ctx); err != nil {
+		return nil, err
+	}
+
+	conn.ctx, conn.cancel = context.WithCancelCause(ctx)
+	go func() {
+		err := conn.Tunnel.Serve(conn.ctx, func(client NewClient) {
+			LogDebug.Printf("ignoring tcp client %s in udp listener\n", client.PeerAddr.AddrPort.String())
+		})
+		conn.cancel(err)
+		conn.Tunnel.Close()
+	}()
+	return conn, nil
+}
+
+func (conn *UdpListener) closedErr() error {
+	if err := context.Cause(conn.ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return net.ErrClosed
+}
+
+// Read packet from tunnel and return payload with real client address
+func (conn *UdpListener) ReadFrom(p []byte) (int, net.Addr, error) {
+	conn.readLock.Lock()
+	defer conn.readLock.Unlock()
+
+	udp := &conn.Tunnel.UdpTunnel
+	for {
+		if conn.ctx.Err() != nil {
+			return 0, nil, conn.closedErr()
+		}
+
+		// Wait udp channel setup until read deadline
+		if !udp.IsSetup() {
+			wait, ok := untilDeadline(&conn.readDeadline)
+			if !ok {
+				return 0, nil, os.ErrDeadlineExceeded
+			} else if wait < 0 || wait > time.Millisecond*100 {
+				wait = time.Millisecond * 100
+			}
+			select {
+			case <-conn.ctx.Done():
+			case <-time.After(wait):
+			}
+			continue
+		} else if unixNano := conn.readDeadline.Load(); unixNano != 0 {
+			udp.SetReadDeadline(time.Unix(0, unixNano)) // Sockets can be new after setup
+		}
+
+		rx, err := udp.ReceiveFrom(conn.buff)
+		if err != nil {
+			if netErr, isNet := err.(net.Error); isNet && netErr.Timeout() {
+				return 0, nil, err
+			} else if conn.ctx.Err() == nil {
+				LogDebug.Println(err)
+			}
+			continue
+		} else if rx.ConfirmerdConnection {
+			continue
+		}
+
+		flow := rx.ReceivedPacket.Flow
+		if !agentTunnelMatches(conn.AgentTunnel, flow.Dst(), api.PortTypeUdp) {
+			LogDebug.Printf("ignoring packet %s to %s, not from tunnel %s\n", flow.Src().String(), flow.Dst().String(), conn.AgentTunnel.ID.String())
+			continue
+		}
+
+		conn.addPeer(flow)
+		return copy(p, conn.buff[:rx.ReceivedPacket.Bytes]), net.UDPAddrFromAddrPort(peerKey(flow.Src())), nil
+	}
+}
+
+// Peer address without ipv4 mapped in ipv6, v6 flows can carry mapped ipv4
+func peerKey(addr netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+}
+
+func (conn *UdpListener) addPeer(flow UdpFlow) {
+	peers, unlock := conn.peers.Write()
+	defer unlock()
+	(*peers)[peerKey(flow.Src())] = udpPeer{Flow: flow, LastSeen: time.Now()}
+
+	// Remove peers without packets
+	if time.Since(conn.lastPrune) > DefaultUdpFlowTimeout {
+		conn.lastPrune = time.Now()
+		for addr, peer := range *peers {
+			if time.Since(peer.LastSeen) > DefaultUdpFlowTimeout {
+				delete(*peers, addr)
+			}
+		}
+	}
+}
+
+// Send packet to client, client must have sent packet before to know tunnel flow
+func (conn *UdpListener) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if conn.ctx.Err() != nil {
+		return 0, conn.closedErr()
+	} else if _, ok := untilDeadline(&conn.writeDeadline); !ok {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	udpAddr, isUdp := addr.(*net.UDPAddr)
+	if !isUdp {
+		return 0, fmt.Errorf("invalid address type %T", addr)
+	}
+	peerAddr := peerKey(udpAddr.AddrPort())
+
+	peers, unlock := conn.peers.Read()
+	peer, ok := peers[peerAddr]
+	unlock()
+	if !ok {
+		return 0, fmt.Errorf("no udp flow to %s", peerAddr.String())
+	}
+
+	buff := make([]byte, len(p), len(p)+V6_LEN)
+	copy(buff, p)
+	if _, err := conn.Tunnel.UdpTunnel.Send(buff, peer.Flow.Flip()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (conn *UdpListener) Close() error {
+	conn.closeOnce.Do(func() {
+		conn.cancel(nil)
+		conn.Tunnel.Close()
+	})
+	return nil
+}
+
+func (conn *UdpListener) LocalAddr() net.Addr {
+	return tunnelAddr(conn.AgentTunnel, api.PortTypeUdp)
+}
+
+func (conn *UdpListener) SetDeadline(t time.Time) error {
+	return errors.Join(conn.SetReadDeadline(t), conn.SetWriteDeadline(t))
+}
+
+// Read deadline is applied to udp sockets and to wait of udp channel setup
+func (conn *UdpListener) SetReadDeadline(t time.Time) error {
+	storeDeadline(&conn.readDeadline, t)
+	return conn.Tunnel.UdpTunnel.SetReadDeadline(t)
+}
+
+func (conn *UdpListener) SetWriteDeadline(t time.Time) error {
+	storeDeadline(&conn.writeDeadline, t)
+	return conn.Tunnel.UdpTunnel.SetWriteDeadline(t)
+}
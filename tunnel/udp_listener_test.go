@@ -0,0 +1,57 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUdpListenerPeerKey(t *testing.T) {
+	conn := &UdpListener{}
+	conn.peers.Value = map[netip.AddrPort]udpPeer{}
+
+	// V6 flow with ipv4 client mapped in ipv6
+	v6 := *testFlowV6.V6
+	v6.Src = netip.MustParseAddrPort("[::ffff:198.51.100.20]:53211")
+	flow := UdpFlow{V6: &v6}
+	conn.addPeer(flow)
+
+	for _, addr := range []string{"198.51.100.20:53211", "[::ffff:198.51.100.20]:53211"} {
+		udpAddr := net.UDPAddrFromAddrPort(netip.MustParseAddrPort(addr))
+		peers, unlock := conn.peers.Read()
+		peer, ok := peers[peerKey(udpAddr.AddrPort())]
+		unlock()
+		if !ok {
+			t.Errorf("no peer to %s", addr)
+		} else if peer.Flow.Src() != flow.Src() {
+			t.Errorf("peer %s with flow %s", addr, dump(peer.Flow))
+		}
+	}
+}
+
+func TestUdpListenerDeadline(t *testing.T) {
+	conn := &UdpListener{buff: make([]byte, 1<<16)}
+	conn.peers.Value = map[netip.AddrPort]udpPeer{}
+	conn.ctx, conn.cancel = context.WithCancelCause(context.Background())
+	defer conn.cancel(nil)
+
+	// Udp channel is not setup, sockets are nil
+	if err := conn.SetDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, _, err := conn.ReadFrom(make([]byte, 1500))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	} else if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("read returned after %s", elapsed)
+	}
+
+	if _, err := conn.WriteTo([]byte("ping"), net.UDPAddrFromAddrPort(netip.MustParseAddrPort("198.51.100.20:53211"))); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected write timeout, got %v", err)
+	}
+}
@@ -1,7 +1,6 @@
 package tunnel
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -82,40 +81,61 @@ func (w *UdpFlow) WriteTo(writer io.Writer) error {
 	return nil
 }
 
+// Read footer from all remaining bytes, footer must be only data in reader
+func (w *UdpFlow) ReadFrom(reader io.Reader) error {
+	dec := NewDecoder(reader)
+	buff, err := io.ReadAll(io.LimitReader(dec, int64(V6_LEN+1)))
+	if err != nil {
+		return err
+	}
+	flow, _, err := FromTailUdpFlow(buff)
+	if err != nil {
+		return err
+	} else if flow.Len() != len(buff) {
+		return fmt.Errorf("%d unexpected bytes before footer", len(buff)-flow.Len())
+	}
+	*w = *flow
+	return nil
+}
+
+func (w *UdpFlow) Flip() UdpFlow {
+	if w.V4 != nil {
+		return UdpFlow{V4: &UdpFlowBase{Src: w.V4.Dst, Dst: w.V4.Src}}
+	}
+	return UdpFlow{V6: &struct {
+		UdpFlowBase
+		Flow uint32
+	}{UdpFlowBase{Src: w.V6.Dst, Dst: w.V6.Src}, w.V6.Flow}}
+}
+
 func FromTailUdpFlow(slice []byte) (*UdpFlow, uint64, error) {
 	if len(slice) < 8 {
 		return nil, 0, fmt.Errorf("not space to footer")
 	}
 	footer := binary.BigEndian.Uint64(slice[len(slice)-8:])
 	switch footer {
-	case REDIRECT_FLOW_4_FOOTER_ID | REDIRECT_FLOW_4_FOOTER_ID_OLD:
+	case REDIRECT_FLOW_4_FOOTER_ID, REDIRECT_FLOW_4_FOOTER_ID_OLD:
 		if len(slice) < V4_LEN {
-			return nil, 0, fmt.Errorf("v4 not have space")
+			return nil, footer, fmt.Errorf("v4 not have space")
 		}
 		slice = slice[len(slice)-V4_LEN:]
-		src_ip, _ := ReadBuffN(bytes.NewReader(slice), 4)
-		srcIP, _ := netip.AddrFromSlice(src_ip)
-		dst_ip, _ := ReadBuffN(bytes.NewReader(slice), 4)
-		dstIP, _ := netip.AddrFromSlice(dst_ip)
-		src_port, dst_port := ReadU16(bytes.NewReader(slice)), ReadU16(bytes.NewReader(slice))
+		srcIP, dstIP := netip.AddrFrom4([4]byte(slice[0:4])), netip.AddrFrom4([4]byte(slice[4:8]))
+		srcPort, dstPort := binary.BigEndian.Uint16(slice[8:10]), binary.BigEndian.Uint16(slice[10:12])
 
 		return &UdpFlow{
 			V4: &UdpFlowBase{
-				Src: netip.AddrPortFrom(srcIP, src_port),
-				Dst: netip.AddrPortFrom(dstIP, dst_port),
+				Src: netip.AddrPortFrom(srcIP, srcPort),
+				Dst: netip.AddrPortFrom(dstIP, dstPort),
 			},
-		}, 0, nil
+		}, footer, nil
 	case REDIRECT_FLOW_6_FOOTER_ID:
 		if len(slice) < V6_LEN {
 			return nil, footer, fmt.Errorf("v6 not have space")
 		}
 		slice = slice[len(slice)-V6_LEN:]
-		src_ip, _ := ReadBuffN(bytes.NewReader(slice), 16)
-		srcIP, _ := netip.AddrFromSlice(src_ip)
-		dst_ip, _ := ReadBuffN(bytes.NewReader(slice), 16)
-		dstIP, _ := netip.AddrFromSlice(dst_ip)
-		src_port, dst_port := ReadU16(bytes.NewReader(slice)), ReadU16(bytes.NewReader(slice))
-		flow := ReadU32(bytes.NewReader(slice))
+		srcIP, dstIP := netip.AddrFrom16([16]byte(slice[0:16])), netip.AddrFrom16([16]byte(slice[16:32]))
+		srcPort, dstPort := binary.BigEndian.Uint16(slice[32:34]), binary.BigEndian.Uint16(slice[34:36])
+		flow := binary.BigEndian.Uint32(slice[36:40])
 
 		return &UdpFlow{
 			V6: &struct {
@@ -123,12 +143,12 @@ func FromTailUdpFlow(slice []byte) (*UdpFlow, uint64, error) {
 				Flow uint32
 			}{
 				UdpFlowBase{
-					Src: netip.AddrPortFrom(srcIP, src_port),
-					Dst: netip.AddrPortFrom(dstIP, dst_port),
+					Src: netip.AddrPortFrom(srcIP, srcPort),
+					Dst: netip.AddrPortFrom(dstIP, dstPort),
 				},
 				flow,
 			},
-		}, 0, nil
+		}, footer, nil
 	}
-	return nil, footer, nil
+	return nil, footer, fmt.Errorf("unknown footer id %x", footer)
 }
@@ -0,0 +1,106 @@
+package tunnel
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+var (
+	testFlowV4 = UdpFlow{V4: &UdpFlowBase{
+		Src: netip.MustParseAddrPort("198.51.100.20:53211"),
+		Dst: netip.MustParseAddrPort("147.185.221.1:10000"),
+	}}
+	testFlowV6 = UdpFlow{V6: &struct {
+		UdpFlowBase
+		Flow uint32
+	}{UdpFlowBase{
+		Src: netip.MustParseAddrPort("[2001:db8::20]:53211"),
+		Dst: netip.MustParseAddrPort("[2602:fbaf::1]:10000"),
+	}, 0x12345}}
+)
+
+func newUdpFlow() MessageEncoding {
+	return &UdpFlow{}
+}
+
+func TestUdpFlowGolden(t *testing.T) {
+	flow := testFlowV4
+	checkGolden(t, "udp_flow_v4", &flow, newUdpFlow)
+	flow = testFlowV6
+	checkGolden(t, "udp_flow_v6", &flow, newUdpFlow)
+
+	// Server can send new v4 footer id, agent write only old id
+	var decoded UdpFlow
+	if err := DecodeMessage(readGolden(t, "udp_flow_v4_new_id"), &decoded); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(decoded, testFlowV4) {
+		t.Fatalf("decoded new v4 footer as %s", dump(decoded))
+	}
+}
+
+func TestFromTailUdpFlow(t *testing.T) {
+	payload := []byte("udp payload")
+	for _, test := range []struct {
+		name   string
+		flow   UdpFlow
+		footer uint64
+	}{
+		{"udp_flow_v4", testFlowV4, REDIRECT_FLOW_4_FOOTER_ID_OLD},
+		{"udp_flow_v4_new_id", testFlowV4, REDIRECT_FLOW_4_FOOTER_ID},
+		{"udp_flow_v6", testFlowV6, REDIRECT_FLOW_6_FOOTER_ID},
+	} {
+		packet := append(append([]byte{}, payload...), readGolden(t, test.name)...)
+		flow, footer, err := FromTailUdpFlow(packet)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		} else if footer != test.footer {
+			t.Errorf("%s: footer %x, want %x", test.name, footer, test.footer)
+		} else if !reflect.DeepEqual(*flow, test.flow) {
+			t.Errorf("%s: decoded %s", test.name, dump(flow))
+		} else if string(packet[:len(packet)-flow.Len()]) != string(payload) {
+			t.Errorf("%s: payload %q", test.name, packet[:len(packet)-flow.Len()])
+		}
+	}
+
+	// V6 footer id with only v4 size
+	short := readGolden(t, "udp_flow_v6")[V6_LEN-V4_LEN:]
+	if _, footer, err := FromTailUdpFlow(short); err == nil || footer != REDIRECT_FLOW_6_FOOTER_ID {
+		t.Errorf("decoded v6 flow from %d bytes", len(short))
+	}
+	if _, _, err := FromTailUdpFlow(append(payload, 1, 2, 3, 4, 5, 6, 7, 8)); err == nil {
+		t.Error("decoded unknown footer id")
+	}
+	if _, _, err := FromTailUdpFlow(payload[:7]); err == nil {
+		t.Error("decoded footer from 7 bytes")
+	}
+}
+
+func TestUdpFlowFlip(t *testing.T) {
+	for _, flow := range []UdpFlow{testFlowV4, testFlowV6} {
+		flipped := flow.Flip()
+		if flipped.Src() != flow.Dst() || flipped.Dst() != flow.Src() || flipped.Len() != flow.Len() {
+			t.Errorf("flip %s to %s", dump(flow), dump(flipped))
+		} else if back := flipped.Flip(); !reflect.DeepEqual(back, flow) {
+			t.Errorf("flip twice %s to %s", dump(flow), dump(back))
+		}
+	}
+}
+
+func FuzzUdpFlow(f *testing.F) {
+	fuzzMessage(f, newUdpFlow, readGolden(f, "udp_flow_v4"), readGolden(f, "udp_flow_v4_new_id"), readGolden(f, "udp_flow_v6"))
+}
+
+// Tail decode must not panic with any packet, flow is always in packet tail
+func FuzzFromTailUdpFlow(f *testing.F) {
+	f.Add(append([]byte("payload"), readGolden(f, "udp_flow_v4")...))
+	f.Add(append([]byte("payload"), readGolden(f, "udp_flow_v6")...))
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		flow, _, err := FromTailUdpFlow(packet)
+		if err != nil {
+			return
+		} else if flow.Len() > len(packet) {
+			t.Fatalf("flow with %d bytes from %d bytes packet", flow.Len(), len(packet))
+		}
+	})
+}
@@ -3,6 +3,7 @@ package tunnel
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
@@ -43,7 +44,7 @@ func AssignUdpTunnel(tunUdp *UdpTunnel) error {
 
 	tunUdp.Details = rwlock.Rwlock[ChannelDetails]{Value: ChannelDetails{
 		AddrHistory: []netip.AddrPort{},
-		Udp: nil,
+		Udp:         nil,
 	}}
 
 	tunUdp.LastConfirm = atomic.Uint32{}
@@ -97,8 +98,8 @@ func (udp *UdpTunnel) SetUdpTunnel(details UdpChannelDetails) error {
 			oldAddr := current.TunnelAddr
 			lock.AddrHistory = append(lock.AddrHistory, oldAddr.AddrPort)
 		}
-		lock.Udp = &details
 	}
+	lock.Udp = &details
 
 	unlock()
 	return udp.SendToken(&details)
@@ -198,28 +199,63 @@ func (Udp *UdpTunnel) ReceiveFrom(buff []byte) (*UdpTunnelRx, error) {
 		return nil, err
 	}
 
-	LogDebug.Println("Check token")
-	LogDebug.Println(buff)
-	LogDebug.Println(token)
-	LogDebug.Println("end check token")
 	if bytes.Equal(buff[:byteSize], token) {
 		LogDebug.Println("udp session confirmed")
 		Udp.LastConfirm.Store(now_sec())
 		return &UdpTunnelRx{ConfirmerdConnection: true}, nil
 	}
 
-	if len(buff) + V6_LEN < byteSize {
+	if len(buff) < byteSize+V6_LEN {
 		return nil, fmt.Errorf("receive buffer too small")
 	}
 
-	footer, footerInt, err := FromTailUdpFlow(buff[byteSize:])
+	footer, footerInt, err := FromTailUdpFlow(buff[:byteSize])
 	if err != nil {
 		if footerInt == UDP_CHANNEL_ESTABLISH_ID {
-			actual := hex.EncodeToString(buff[byteSize:]);
-			expected := hex.EncodeToString(token);
+			actual := hex.EncodeToString(buff[:byteSize])
+			expected := hex.EncodeToString(token)
 			return nil, fmt.Errorf("unexpected UDP establish packet, actual: %s, expected: %s", actual, expected)
 		}
-		return nil, fmt.Errorf("failed to extract udp footer: %s, err: %s", hex.EncodeToString(buff[byteSize:]), err.Error())
+		return nil, fmt.Errorf("failed to extract udp footer: %s, err: %s", hex.EncodeToString(buff[:byteSize]), err.Error())
 	}
-	return &UdpTunnelRx{ReceivedPacket: &struct{Bytes uint64; Flow UdpFlow}{uint64(byteSize) - uint64(footer.Len()), *footer}}, nil
+	return &UdpTunnelRx{ReceivedPacket: &struct {
+		Bytes uint64
+		Flow  UdpFlow
+	}{uint64(byteSize) - uint64(footer.Len()), *footer}}, nil
+}
+
+// Set read deadline of udp tunnel sockets, used to stop ReceiveFrom without close sockets
+func (udp *UdpTunnel) SetReadDeadline(t time.Time) error {
+	var err error
+	if udp.Udp4 != nil {
+		err = udp.Udp4.SetReadDeadline(t)
+	}
+	if udp.Udp6 != nil {
+		err = errors.Join(err, udp.Udp6.SetReadDeadline(t))
+	}
+	return err
+}
+
+// Set write deadline of udp tunnel sockets
+func (udp *UdpTunnel) SetWriteDeadline(t time.Time) error {
+	var err error
+	if udp.Udp4 != nil {
+		err = udp.Udp4.SetWriteDeadline(t)
+	}
+	if udp.Udp6 != nil {
+		err = errors.Join(err, udp.Udp6.SetWriteDeadline(t))
+	}
+	return err
+}
+
+// Close udp tunnel sockets
+func (udp *UdpTunnel) Close() error {
+	var err error
+	if udp.Udp4 != nil {
+		err = udp.Udp4.Close()
+	}
+	if udp.Udp6 != nil {
+		err = errors.Join(err, udp.Udp6.Close())
+	}
+	return err
 }